@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
@@ -10,6 +12,30 @@ type Config struct {
 	BindIP       string `env:"BIND_IP" env-default:"0.0.0.0"`
 	Port         string `env:"PORT" env-default:"8000"`
 	DatabasePath string `env:"DATABASE_PATH" env-required:"true"`
+	// Время жизни сессии и отправка куки только по HTTPS
+	SessionTTL   time.Duration `env:"SESSION_TTL" env-default:"168h"`
+	CookieSecure bool          `env:"COOKIE_SECURE" env-default:"true"`
+	// Срок хранения заметок в корзине (0 - до очистки вручную) и период ее очистки
+	TrashRetention     time.Duration `env:"TRASH_RETENTION" env-default:"720h"`
+	TrashPurgeInterval time.Duration `env:"TRASH_PURGE_INTERVAL" env-default:"1h"`
+	// Правила для имен пользователей, пустой шаблон оставляет правило по умолчанию
+	UsernameMinLength int      `env:"USERNAME_MIN_LENGTH" env-default:"3"`
+	UsernameMaxLength int      `env:"USERNAME_MAX_LENGTH" env-default:"32"`
+	UsernamePattern   string   `env:"USERNAME_PATTERN"`
+	ReservedUsernames []string `env:"RESERVED_USERNAMES" env-separator:"," env-default:"admin,administrator,root,system,api,static,support"`
+	// Правила для паролей новых пользователей
+	PasswordMinLength    int  `env:"PASSWORD_MIN_LENGTH" env-default:"8"`
+	PasswordMinClasses   int  `env:"PASSWORD_MIN_CLASSES" env-default:"2"`
+	PasswordRejectCommon bool `env:"PASSWORD_REJECT_COMMON" env-default:"true"`
+	// Адрес сайта для ссылок в письмах
+	PublicURL string `env:"PUBLIC_URL" env-default:"http://localhost:8080"`
+	// Почта: SMTP, если задан хост, иначе файл, если задан путь, иначе лог
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     int    `env:"SMTP_PORT" env-default:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+	MailFrom     string `env:"MAIL_FROM" env-default:"notes@localhost"`
+	MailFile     string `env:"MAIL_FILE"`
 }
 
 func GetConfig() (Config, error) {
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"my_notes_project/internal/api"
 	"my_notes_project/internal/core"
 	"my_notes_project/internal/database"
+	"my_notes_project/internal/mail"
+	"os"
+	"regexp"
 
 	"github.com/sirupsen/logrus"
 )
@@ -28,7 +32,15 @@ func main() {
 	// Устанавливаем уровень логгирования
 	logger.SetLevel(lvl)
 
-	// Настраиваем путь до базы данных
+	// Команда migrate работает со схемой базы данных без запуска сервера
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = runMigrate(os.Args[2:], config, logger, os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	// Открываем базу данных и применяем миграции
 	db, err := database.NewSQLiteDatabase(config.DatabasePath, logger)
 	if err != nil {
 		panic(err)
@@ -36,9 +48,22 @@ func main() {
 	// Закрываем базу данных
 	defer db.CloseSQLiteDatabase()
 
+	// Правила для имен новых пользователей
+	policy, err := usernamePolicy(config)
+	if err != nil {
+		panic(err)
+	}
+
 	// Создаем новый апи и кор
-	core := core.NewTheCore(db, logger)
-	restAPI := api.NewRestAPI(core, logger)
+	core := core.NewTheCore(db, logger,
+		core.WithSessionTTL(config.SessionTTL),
+		core.WithUsernamePolicy(policy),
+		core.WithPasswordPolicy(passwordPolicy(config)),
+		core.WithMailer(newMailer(config, logger)),
+		core.WithPublicURL(config.PublicURL),
+		core.WithTrashRetention(config.TrashRetention),
+	)
+	restAPI := api.NewRestAPI(core, logger, api.WithSecureCookies(config.CookieSecure))
 
 	// Обрабатываем хендлеры на ошибку
 	err = restAPI.HandlersInit()
@@ -46,7 +71,52 @@ func main() {
 		panic(err)
 	}
 
+	// Заметки, пролежавшие в корзине дольше срока хранения, удаляются в фоне
+	if config.TrashRetention > 0 && config.TrashPurgeInterval > 0 {
+		go core.RunTrashPurger(context.Background(), config.TrashPurgeInterval)
+	}
+
 	// Для прослушивания и приема входящих запросов
 	log.Fatal(restAPI.Listen("0.0.0.0:8080"))
 
 }
+
+func usernamePolicy(config Config) (core.UsernamePolicy, error) {
+	// Собираем правила из конфига поверх правил по умолчанию
+	policy := core.DefaultUsernamePolicy
+	policy.MinLength = config.UsernameMinLength
+	policy.MaxLength = config.UsernameMaxLength
+	policy.Reserved = config.ReservedUsernames
+
+	if config.UsernamePattern != "" {
+		pattern, err := regexp.Compile(config.UsernamePattern)
+		if err != nil {
+			return policy, err
+		}
+		policy.Pattern = pattern
+	}
+
+	return policy, nil
+}
+
+func passwordPolicy(config Config) core.PasswordPolicy {
+	// Собираем правила для паролей из конфига
+	policy := core.DefaultPasswordPolicy
+	policy.MinLength = config.PasswordMinLength
+	policy.MinClasses = config.PasswordMinClasses
+	policy.RejectCommon = config.PasswordRejectCommon
+
+	return policy
+}
+
+func newMailer(config Config, logger *logrus.Logger) mail.Mailer {
+	// Выбираем способ отправки писем по конфигу
+	switch {
+	case config.SMTPHost != "":
+		return mail.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom)
+	case config.MailFile != "":
+		return mail.NewFileMailer(config.MailFile, config.MailFrom)
+	}
+
+	return mail.NewLogMailer(logger)
+}
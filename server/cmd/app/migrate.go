@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"my_notes_project/internal/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+const migrateUsage = "usage: prog migrate [status|dry-run|up]"
+
+func runMigrate(args []string, config Config, logger *logrus.Logger, out io.Writer) error {
+	// Команда управления миграциями схемы базы данных
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	// Открываем базу данных без применения миграций
+	db, err := database.OpenSQLiteDatabase(config.DatabasePath, logger)
+	if err != nil {
+		return err
+	}
+	defer db.CloseSQLiteDatabase()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		// Показываем текущую версию и список ожидающих миграций
+		fmt.Fprintf(out, "current version: %d\nlatest version:  %d\n", status.Current, status.Latest)
+		if status.TooNew() {
+			fmt.Fprintln(out, "database is newer than this binary")
+		}
+
+		for _, m := range status.Pending {
+			fmt.Fprintf(out, "pending: %04d_%s\n", m.Version, m.Name)
+		}
+	case "dry-run":
+		// Печатаем SQL, который будет выполнен, ничего не меняя
+		if status.TooNew() {
+			return fmt.Errorf("%w: database version %d, latest known %d",
+				database.ErrSchemaTooNew, status.Current, status.Latest)
+		}
+
+		for _, m := range status.Pending {
+			fmt.Fprintf(out, "-- %04d_%s\n%s\n", m.Version, m.Name, m.SQL)
+		}
+
+		fmt.Fprintf(out, "-- %d migration(s) would be applied\n", len(status.Pending))
+	case "up":
+		// Применяем миграции без запуска сервера
+		return db.Migrate()
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
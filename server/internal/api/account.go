@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"my_notes_project/internal/core"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (r *RestAPI) apiChangePassword(ctx *fiber.Ctx) error {
+	// Меняем пароль, текущая сессия остается
+	var req PasswordChangeRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if req.NewPasswordRepeat == "" {
+		req.NewPasswordRepeat = req.NewPassword
+	}
+
+	err := r.core.ChangePassword(currentUser(ctx).Name, req.OldPassword, req.NewPassword,
+		req.NewPasswordRepeat, ctx.Cookies(sessionCookie))
+	if err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiDeleteAccount(ctx *fiber.Ctx) error {
+	// Удаляем учетную запись и куки ее сессии
+	var req AccountDeleteRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err := r.core.DeleteAccount(currentUser(ctx).Name, req.Password); err != nil {
+		return err
+	}
+
+	r.clearSessionCookie(ctx)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) accountPage(ctx *fiber.Ctx, status int, m fiber.Map) error {
+	// Страница настроек учетной записи, m дополняет данные шаблона
+	totpEnabled, recoveryCodesLeft, err := r.core.GetTOTPStatus(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	data := fiber.Map{
+		"Title":             "Account",
+		"Username":          currentUser(ctx).Name,
+		"Email":             currentUser(ctx).Email,
+		"CreatedAt":         currentUser(ctx).CreatedAt,
+		"LastLoginAt":       currentUser(ctx).LastLoginAt,
+		"TOTPEnabled":       totpEnabled,
+		"RecoveryCodesLeft": recoveryCodesLeft,
+	}
+	for k, v := range m {
+		data[k] = v
+	}
+
+	return r.render(ctx, status, "account", data)
+}
+
+func (r *RestAPI) accountInit() {
+	// HTML страница для смены пароля и удаления учетной записи
+	r.app.Get("/account", r.requireAuth, func(ctx *fiber.Ctx) error {
+		return r.accountPage(ctx, fiber.StatusOK, nil)
+	}).Post("/account/password", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Меняем пароль, ошибки проверки показываем на странице
+		err = r.core.ChangePassword(currentUser(ctx).Name, formValue(form, "old_password"),
+			formValue(form, "password1"), formValue(form, "password2"), ctx.Cookies(sessionCookie))
+		if errors.Is(err, core.ErrValidation) {
+			return r.accountPage(ctx, fiber.StatusBadRequest, fiber.Map{"PasswordErrors": formErrors(err)})
+		} else if err != nil {
+			return err
+		}
+
+		return r.accountPage(ctx, fiber.StatusOK, fiber.Map{"PasswordChanged": true})
+	}).Post("/account/email", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Меняем адрес почты для восстановления пароля после повторного ввода пароля
+		email := formValue(form, "email")
+		err = r.core.UpdateEmail(currentUser(ctx).Name, formValue(form, "password"), email)
+		if errors.Is(err, core.ErrValidation) || errors.Is(err, core.ErrConflict) {
+			status, _ := errorStatus(err)
+			return r.accountPage(ctx, status, fiber.Map{"Email": email, "EmailErrors": formErrors(err)})
+		} else if err != nil {
+			return err
+		}
+
+		return r.accountPage(ctx, fiber.StatusOK, fiber.Map{"Email": email, "EmailChanged": true})
+	}).Post("/account/delete", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Удаляем учетную запись после повторного ввода пароля
+		err = r.core.DeleteAccount(currentUser(ctx).Name, formValue(form, "password"))
+		if errors.Is(err, core.ErrValidation) {
+			return r.accountPage(ctx, fiber.StatusBadRequest, fiber.Map{"DeleteErrors": formErrors(err)})
+		} else if err != nil {
+			return err
+		}
+
+		r.clearSessionCookie(ctx)
+
+		return ctx.Redirect("/")
+	})
+}
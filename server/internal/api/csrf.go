@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Куки и поле формы с CSRF токеном (double submit cookie)
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+func (r *RestAPI) csrfToken(ctx *fiber.Ctx) (string, error) {
+	// Используем уже выданный токен, чтобы не ломать открытые вкладки
+	if token := ctx.Cookies(csrfCookie); token != "" {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	ctx.Cookie(&fiber.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   r.secureCookies,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	return token, nil
+}
+
+func (r *RestAPI) csrfProtect(ctx *fiber.Ctx) error {
+	// Токен из формы или заголовка должен совпадать с токеном из куки,
+	// сторонний сайт не может прочитать куки и подставить его в запрос
+	cookie := ctx.Cookies(csrfCookie)
+
+	token := ctx.FormValue(csrfField)
+	if token == "" {
+		token = ctx.Get(csrfHeader)
+	}
+
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(token)) != 1 {
+		r.logger.Errorf("csrf token mismatch: %s %s", ctx.Method(), ctx.Path())
+		return fiber.NewError(fiber.StatusForbidden, "invalid csrf token")
+	}
+
+	return ctx.Next()
+}
+
+func (r *RestAPI) apiCSRFProtect(ctx *fiber.Ctx) error {
+	// Браузер сам прикладывает куки сессии к запросам со сторонних сайтов, поэтому
+	// изменяющие запросы API с сессией требуют токен в заголовке, как и формы.
+	// Запросы с токеном доступа и чтение не проверяем
+	switch ctx.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return ctx.Next()
+	}
+
+	if currentUser(ctx) == nil || currentToken(ctx) != nil {
+		return ctx.Next()
+	}
+
+	return r.csrfProtect(ctx)
+}
+
+func (r *RestAPI) render(ctx *fiber.Ctx, status int, name string, m fiber.Map) error {
+	// Все HTML страницы получают CSRF токен для своих форм
+	token, err := r.csrfToken(ctx)
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	m["CSRFToken"] = token
+	return ctx.Status(status).Render(name, m)
+}
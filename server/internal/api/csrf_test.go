@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"my_notes_project/internal/entities"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(t *testing.T, path string, fields map[string]string, cookie string) *http.Request {
+	// Запрос как от HTML формы, cookie передается в куки csrf_token, если не пустая
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		require.Nil(t, w.WriteField(k, v))
+	}
+	require.Nil(t, w.Close())
+
+	req := httptest.NewRequest(fiber.MethodPost, path, &body)
+	req.Header.Set(fiber.HeaderContentType, w.FormDataContentType())
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: cookie})
+	}
+
+	return req
+}
+
+func TestCSRFRejectsForms(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	require.Nil(t, r.HandlersInit())
+
+	// Формы без входа отклоняются до обращения к ядру
+	for _, path := range []string{"/reg", "/auth", "/logout", "/password/forgot", "/password/reset", "/auth/2fa"} {
+		fields := map[string]string{"username": "ivan", "password": "Ocean-breeze-7"}
+
+		resp, err := r.app.Test(formRequest(t, path, fields, ""))
+		require.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, "%s without token", path)
+
+		resp, err = r.app.Test(formRequest(t, path, fields, "cookie-token"))
+		require.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, "%s without form field", path)
+
+		fields[csrfField] = "other-token"
+		resp, err = r.app.Test(formRequest(t, path, fields, "cookie-token"))
+		require.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, "%s with mismatched token", path)
+	}
+}
+
+func TestCSRFAcceptsMatchingToken(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	require.Nil(t, r.HandlersInit())
+
+	// Без сессии выход просто удаляет куки и возвращает на главную
+	resp, err := r.app.Test(formRequest(t, "/logout", map[string]string{csrfField: "cookie-token"}, "cookie-token"))
+	require.Nil(t, err)
+	assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+}
+
+func TestCSRFProtect(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	r.app.Post("/", r.csrfProtect, func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		status int
+	}{
+		{"no cookie", "", "token", fiber.StatusForbidden},
+		{"no header", "token", "", fiber.StatusForbidden},
+		{"mismatch", "token", "other", fiber.StatusForbidden},
+		{"match", "token", "token", fiber.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		// Токен можно передать заголовком вместо поля формы
+		req := httptest.NewRequest(fiber.MethodPost, "/", nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			req.Header.Set(csrfHeader, tt.header)
+		}
+
+		resp, err := r.app.Test(req)
+		require.Nil(t, err)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+	}
+}
+
+func TestAPICSRFProtect(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	r.app.Use(func(ctx *fiber.Ctx) error {
+		// Вместо sessionMiddleware и bearerMiddleware
+		switch ctx.Get("X-Test-Auth") {
+		case "session":
+			ctx.Locals(userKey, &entities.User{Name: "ivan"})
+		case "token":
+			ctx.Locals(userKey, &entities.User{Name: "ivan"})
+			ctx.Locals(tokenKey, &entities.APIToken{})
+		}
+		return ctx.Next()
+	}, r.apiCSRFProtect)
+	r.app.All("/", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		header string
+		status int
+	}{
+		{"anonymous", fiber.MethodPost, "", "", fiber.StatusNoContent},
+		{"session read", fiber.MethodGet, "session", "", fiber.StatusNoContent},
+		{"session without header", fiber.MethodDelete, "session", "", fiber.StatusForbidden},
+		{"session mismatch", fiber.MethodPost, "session", "other", fiber.StatusForbidden},
+		{"session match", fiber.MethodPost, "session", "token", fiber.StatusNoContent},
+		{"access token", fiber.MethodDelete, "token", "", fiber.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+		req.Header.Set("X-Test-Auth", tt.auth)
+		if tt.header != "" {
+			req.Header.Set(csrfHeader, tt.header)
+		}
+
+		resp, err := r.app.Test(req)
+		require.Nil(t, err)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+	}
+}
+
+func TestAPIRejectsForms(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	require.Nil(t, r.HandlersInit())
+
+	// Тело API принимается только в JSON, форма со стороннего сайта отклоняется до ядра
+	for _, path := range []string{"/api/v1/users", "/api/v1/session", "/api/v1/password-reset"} {
+		fields := map[string]string{"username": "ivan", "password": "Ocean-breeze-7", "email": "ivan@example.com"}
+
+		resp, err := r.app.Test(formRequest(t, path, fields, ""))
+		require.Nil(t, err)
+		assert.Equal(t, fiber.StatusUnsupportedMediaType, resp.StatusCode, path)
+	}
+}
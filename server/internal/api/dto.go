@@ -0,0 +1,497 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"my_notes_project/internal/core"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/search"
+	"strings"
+	"time"
+)
+
+// Тело запроса на создание или изменение заметки
+type NoteRequest struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Если поле не передано, при изменении метки заметки не меняются
+	Tags []string `json:"tags,omitempty"`
+	// Без блокнота новая заметка попадает в блокнот по умолчанию, а измененная остается в своем
+	NotebookID uint64 `json:"notebook_id,omitempty"`
+}
+
+func (n NoteRequest) validate() error {
+	verr := &core.ValidationError{}
+	if strings.TrimSpace(n.Title) == "" {
+		verr.Add("title", "is required")
+	}
+
+	if strings.TrimSpace(n.Content) == "" {
+		verr.Add("content", "is required")
+	}
+
+	return verr.OrNil()
+}
+
+// Заметка в ответах API
+type NoteResponse struct {
+	ID         uint64    `json:"id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	NotebookID uint64    `json:"notebook_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Tags       []string  `json:"tags"`
+	Version    int       `json:"version"`
+	// Только у заметок из корзины: когда заметка удалена
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Только в результатах поиска: HTML фрагмент, найденные слова в <mark>
+	Snippet string `json:"snippet,omitempty"`
+}
+
+func newNoteResponse(note *entities.Note) NoteResponse {
+	return NoteResponse{
+		ID:         note.ID,
+		Title:      note.Title,
+		Content:    note.Content,
+		NotebookID: note.NotebookID,
+		CreatedAt:  note.CreatedAt,
+		UpdatedAt:  note.UpdatedAt,
+		Tags:       append([]string{}, note.Tags...),
+		Version:    note.Version,
+		DeletedAt:  note.DeletedAt,
+	}
+}
+
+// Страница списка заметок
+type NoteListResponse struct {
+	Notes []NoteResponse `json:"notes"`
+	// Передается в параметре cursor для следующей страницы, пустой на последней
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func newNoteListResponse(page *entities.NotePage) NoteListResponse {
+	resp := NoteListResponse{Notes: make([]NoteResponse, 0, len(page.Notes))}
+	for _, note := range page.Notes {
+		resp.Notes = append(resp.Notes, newNoteResponse(note))
+	}
+
+	if page.Next != nil {
+		resp.NextCursor = page.Next.String()
+	}
+
+	return resp
+}
+
+func newSearchResponse(matches []*entities.NoteMatch) NoteListResponse {
+	resp := NoteListResponse{Notes: make([]NoteResponse, 0, len(matches))}
+	for _, m := range matches {
+		note := newNoteResponse(m.Note)
+		note.Snippet = string(snippetHTML(m.Snippet))
+		resp.Notes = append(resp.Notes, note)
+	}
+
+	return resp
+}
+
+func snippetHTML(snippet string) template.HTML {
+	// Текст заметки экранируем, найденные слова выделяем тегом mark
+	return template.HTML(strings.NewReplacer(
+		search.HighlightStart, "<mark>",
+		search.HighlightEnd, "</mark>",
+	).Replace(template.HTMLEscapeString(snippet)))
+}
+
+// Метка и число отмеченных ею заметок
+type TagResponse struct {
+	Name  string `json:"name"`
+	Notes int    `json:"notes"`
+}
+
+func newTagResponse(tag *entities.Tag) TagResponse {
+	return TagResponse{
+		Name:  tag.Name,
+		Notes: tag.Notes,
+	}
+}
+
+// Тело запроса на добавление меток к заметке
+type NoteTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (n NoteTagsRequest) validate() error {
+	if len(n.Tags) == 0 {
+		return core.NewValidationError("tags", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на переименование метки
+type TagRenameRequest struct {
+	Name string `json:"name"`
+}
+
+func (t TagRenameRequest) validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return core.NewValidationError("name", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на объединение метки с другой
+type TagMergeRequest struct {
+	Into string `json:"into"`
+}
+
+func (t TagMergeRequest) validate() error {
+	if strings.TrimSpace(t.Into) == "" {
+		return core.NewValidationError("into", "is required")
+	}
+
+	return nil
+}
+
+// Блокнот в ответах API
+type NotebookResponse struct {
+	ID        uint64    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *uint64   `json:"parent_id"`
+	Notes     int       `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newNotebookResponse(notebook *entities.Notebook) NotebookResponse {
+	return NotebookResponse{
+		ID:        notebook.ID,
+		Name:      notebook.Name,
+		ParentID:  notebook.ParentID,
+		Notes:     notebook.Notes,
+		CreatedAt: notebook.CreatedAt,
+	}
+}
+
+// Тело запроса на создание блокнота, без parent_id блокнот верхнего уровня
+type NotebookRequest struct {
+	Name     string  `json:"name"`
+	ParentID *uint64 `json:"parent_id,omitempty"`
+}
+
+func (n NotebookRequest) validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return core.NewValidationError("name", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на переименование блокнота
+type NotebookRenameRequest struct {
+	Name string `json:"name"`
+}
+
+func (n NotebookRenameRequest) validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return core.NewValidationError("name", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на перенос блокнота, null переносит на верхний уровень
+type NotebookMoveRequest struct {
+	ParentID *uint64 `json:"parent_id"`
+}
+
+// Тело запроса на перенос заметки в другой блокнот
+type NoteMoveRequest struct {
+	NotebookID uint64 `json:"notebook_id"`
+}
+
+func (n NoteMoveRequest) validate() error {
+	if n.NotebookID == 0 {
+		return core.NewValidationError("notebook_id", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на регистрацию
+type UserRequest struct {
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	PasswordRepeat string `json:"password_repeat,omitempty"`
+}
+
+func (u UserRequest) validate() error {
+	verr := &core.ValidationError{}
+	if u.Username == "" {
+		verr.Add("username", "is required")
+	}
+
+	if u.Password == "" {
+		verr.Add("password", "is required")
+	}
+
+	if u.PasswordRepeat != "" && u.PasswordRepeat != u.Password {
+		verr.Add("password_repeat", "passwords do not match")
+	}
+
+	return verr.OrNil()
+}
+
+// Пользователь в ответах API, без пароля
+type UserResponse struct {
+	ID       uint64 `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+}
+
+func newUserResponse(user *entities.User) UserResponse {
+	return UserResponse{
+		ID:       user.ID,
+		Username: user.Name,
+		Email:    user.Email,
+	}
+}
+
+// Тело запроса на вход
+type SessionRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Текущая сессия
+type SessionResponse struct {
+	User      UserResponse `json:"user"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	// Пароль верный, но сессия начнет действовать после POST /session/mfa
+	MFARequired bool `json:"mfa_required,omitempty"`
+}
+
+// Тело запроса со вторым фактором: код из приложения или код восстановления
+type MFARequest struct {
+	Code string `json:"code"`
+}
+
+func (m MFARequest) validate() error {
+	if m.Code == "" {
+		return core.NewValidationError("code", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на выпуск персонального токена
+type TokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (t TokenRequest) validate() error {
+	verr := &core.ValidationError{}
+	if strings.TrimSpace(t.Name) == "" {
+		verr.Add("name", "is required")
+	}
+
+	if len(t.Scopes) == 0 {
+		verr.Add("scopes", "is required")
+	}
+
+	for _, scope := range t.Scopes {
+		if !core.IsValidScope(scope) {
+			verr.Add("scopes", fmt.Sprintf("unknown scope %q", scope))
+		}
+	}
+
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		verr.Add("expires_at", "must be in the future")
+	}
+
+	return verr.OrNil()
+}
+
+// Персональный токен в ответах API, без секрета
+type TokenResponse struct {
+	ID         uint64     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newTokenResponse(token *entities.APIToken) TokenResponse {
+	return TokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+// Только что выпущенный токен вместе с секретом
+type CreatedTokenResponse struct {
+	Token string `json:"token"`
+	TokenResponse
+}
+
+// Тело запроса на смену пароля, повтор можно не передавать
+type PasswordChangeRequest struct {
+	OldPassword       string `json:"old_password"`
+	NewPassword       string `json:"new_password"`
+	NewPasswordRepeat string `json:"new_password_repeat,omitempty"`
+}
+
+func (p PasswordChangeRequest) validate() error {
+	verr := &core.ValidationError{}
+	if p.OldPassword == "" {
+		verr.Add("old_password", "is required")
+	}
+
+	if p.NewPassword == "" {
+		verr.Add("new_password", "is required")
+	}
+
+	return verr.OrNil()
+}
+
+// Тело запроса на удаление учетной записи
+type AccountDeleteRequest struct {
+	Password string `json:"password"`
+}
+
+func (a AccountDeleteRequest) validate() error {
+	if a.Password == "" {
+		return core.NewValidationError("password", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на смену адреса почты, пустой адрес отвязывает почту
+type EmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (e EmailRequest) validate() error {
+	if e.Password == "" {
+		return core.NewValidationError("password", "is required")
+	}
+
+	return nil
+}
+
+// Тело запроса на письмо для восстановления пароля
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+func (p PasswordResetRequest) validate() error {
+	if p.Email == "" {
+		return core.NewValidationError("email", "is required")
+	}
+
+	return core.ValidateEmail(p.Email)
+}
+
+// Тело запроса на установку нового пароля по токену из письма
+type PasswordResetConfirmRequest struct {
+	Token             string `json:"token"`
+	NewPassword       string `json:"new_password"`
+	NewPasswordRepeat string `json:"new_password_repeat,omitempty"`
+}
+
+func (p PasswordResetConfirmRequest) validate() error {
+	verr := &core.ValidationError{}
+	if p.Token == "" {
+		verr.Add("token", "is required")
+	}
+
+	if p.NewPassword == "" {
+		verr.Add("new_password", "is required")
+	}
+
+	return verr.OrNil()
+}
+
+// Единый формат ошибки для всех ответов API
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Status  int          `json:"status"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	// При конфликте версий текущее состояние заметки
+	Current *NoteResponse `json:"current,omitempty"`
+}
+
+// Ошибка в конкретном поле тела запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Правка заметки, в списке истории без содержимого
+type RevisionResponse struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content,omitempty"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newRevisionResponse(revision *entities.Revision) RevisionResponse {
+	return RevisionResponse{
+		Number:    revision.Number,
+		Title:     revision.Title,
+		Content:   revision.Content,
+		Author:    revision.Author,
+		CreatedAt: revision.CreatedAt,
+	}
+}
+
+// Строка построчного сравнения правок: op равен equal, insert или delete,
+// old и new номера строки в сравниваемых правках
+type DiffLineResponse struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+	Old  int    `json:"old,omitempty"`
+	New  int    `json:"new,omitempty"`
+}
+
+// Сравнение двух правок заметки
+type RevisionDiffResponse struct {
+	From  RevisionResponse   `json:"from"`
+	To    RevisionResponse   `json:"to"`
+	Lines []DiffLineResponse `json:"lines"`
+}
+
+func newRevisionDiffResponse(d *core.RevisionDiff) RevisionDiffResponse {
+	resp := RevisionDiffResponse{
+		From:  newRevisionResponse(d.From),
+		To:    newRevisionResponse(d.To),
+		Lines: make([]DiffLineResponse, 0, len(d.Lines)),
+	}
+
+	// Содержимое правок уже есть в строках сравнения
+	resp.From.Content, resp.To.Content = "", ""
+	for _, l := range d.Lines {
+		resp.Lines = append(resp.Lines, DiffLineResponse{Op: l.Op.String(), Text: l.Text, Old: l.Old, New: l.New})
+	}
+
+	return resp
+}
+
+// Результат очистки корзины
+type TrashEmptyResponse struct {
+	Deleted int `json:"deleted"`
+}
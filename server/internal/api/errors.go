@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"my_notes_project/internal/core"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (r *RestAPI) errorHandler(ctx *fiber.Ctx, err error) error {
+	// Единая обработка ошибок: код ответа по типу ошибки, JSON для API и страница для браузера
+	status, message := errorStatus(err)
+
+	// Клиенту сообщаем, когда можно повторить попытку
+	var rerr *core.RateLimitError
+	if errors.As(err, &rerr) {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(rerr.RetryAfter.Seconds()))))
+	}
+	// При конфликте версий сообщаем текущую версию заметки и отдаем ее саму
+	var current *NoteResponse
+	var cerr *core.VersionConflictError
+	if errors.As(err, &cerr) {
+		ctx.Set(fiber.HeaderETag, noteETag(cerr.Current))
+		resp := newNoteResponse(cerr.Current)
+		current = &resp
+	}
+	if status >= fiber.StatusInternalServerError {
+		r.logger.Error(err)
+		message = "internal server error"
+	} else {
+		r.logger.Debugf("%s %s: %v", ctx.Method(), ctx.Path(), err)
+	}
+
+	// Ошибки проверки полей отдаем списком
+	var fields []FieldError
+	var verr *core.ValidationError
+	if errors.As(err, &verr) {
+		for _, f := range verr.Fields {
+			fields = append(fields, FieldError{Field: f.Field, Message: f.Message})
+		}
+	}
+
+	if wantsJSON(ctx) {
+		return ctx.Status(status).JSON(ErrorResponse{
+			Error: ErrorBody{
+				Status:  status,
+				Message: message,
+				Fields:  fields,
+				Current: current,
+			},
+		})
+	}
+
+	err = ctx.Status(status).Render("error", fiber.Map{
+		"Title":   "Error",
+		"Status":  status,
+		"Message": message,
+		"Fields":  fields,
+	})
+	if err != nil {
+		// Если шаблон не удалось отрисовать, отвечаем простым текстом
+		r.logger.Error(err)
+		ctx.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return ctx.Status(status).SendString(message)
+	}
+
+	return nil
+}
+
+func errorStatus(err error) (int, string) {
+	// Подбираем код ответа по ошибке ядра или fiber
+	var ferr *fiber.Error
+	switch {
+	case errors.As(err, &ferr):
+		return ferr.Code, ferr.Message
+	case errors.Is(err, core.ErrValidation):
+		return fiber.StatusBadRequest, err.Error()
+	case errors.Is(err, core.ErrUnauthorized):
+		return fiber.StatusUnauthorized, err.Error()
+	case errors.Is(err, core.ErrForbidden):
+		return fiber.StatusForbidden, err.Error()
+	case errors.Is(err, core.ErrNotFound):
+		return fiber.StatusNotFound, err.Error()
+	case errors.Is(err, core.ErrConflict):
+		return fiber.StatusConflict, err.Error()
+	case errors.Is(err, core.ErrRateLimited):
+		return fiber.StatusTooManyRequests, err.Error()
+	}
+
+	return fiber.StatusInternalServerError, err.Error()
+}
+
+func wantsJSON(ctx *fiber.Ctx) bool {
+	// API всегда отвечает JSON, остальные маршруты по заголовку Accept
+	if strings.HasPrefix(ctx.Path(), "/api/") {
+		return true
+	}
+
+	return ctx.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"my_notes_project/internal/core"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+)
+
+// Куки с промежуточной сессией между паролем и кодом второго фактора
+const mfaCookie = "mfa_session"
+
+func (r *RestAPI) apiCompleteMFA(ctx *fiber.Ctx) error {
+	// Второй шаг входа по коду из приложения или коду восстановления
+	var req MFARequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	token, session, err := r.core.CompleteMFA(ctx.Cookies(mfaCookie), req.Code)
+	if err != nil {
+		return err
+	}
+
+	user, err := r.core.GetUserBySession(token)
+	if err != nil {
+		return err
+	}
+
+	r.setCookie(ctx, mfaCookie, "", time.Unix(0, 0))
+	r.setSessionCookie(ctx, token, session.ExpiresAt)
+
+	return ctx.Status(fiber.StatusCreated).JSON(SessionResponse{
+		User:      newUserResponse(user),
+		ExpiresAt: &session.ExpiresAt,
+	})
+}
+
+func qrDataURL(content string) (template.URL, error) {
+	// QR код в виде PNG, встроенного прямо в страницу
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+func (r *RestAPI) totpPage(ctx *fiber.Ctx, status int, m fiber.Map) error {
+	// Страница включения второго фактора с QR кодом
+	data := fiber.Map{
+		"Title": "Two-factor authentication",
+	}
+	for k, v := range m {
+		data[k] = v
+	}
+
+	// Пока коды восстановления не выданы, показываем секрет для сканирования
+	if _, done := data["RecoveryCodes"]; !done {
+		secret, uri, err := r.core.BeginTOTPEnrollment(currentUser(ctx).Name)
+		if err != nil {
+			return err
+		}
+
+		qr, err := qrDataURL(uri)
+		if err != nil {
+			r.logger.Error(err)
+			return err
+		}
+
+		data["Secret"] = secret
+		data["URI"] = uri
+		data["QRCode"] = qr
+	}
+
+	return r.render(ctx, status, "totp", data)
+}
+
+func (r *RestAPI) mfaInit() {
+	// Второй шаг входа и управление вторым фактором
+	r.app.Get("/auth/2fa", func(ctx *fiber.Ctx) error {
+		return r.render(ctx, fiber.StatusOK, "mfa", fiber.Map{
+			"Title": "Two-factor authentication",
+		})
+	}).Post("/auth/2fa", r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Проверяем код, при ошибке показываем форму еще раз
+		token, session, err := r.core.CompleteMFA(ctx.Cookies(mfaCookie), formValue(form, "code"))
+		if errors.Is(err, core.ErrValidation) {
+			return r.render(ctx, fiber.StatusBadRequest, "mfa", fiber.Map{
+				"Title":  "Two-factor authentication",
+				"Errors": formErrors(err),
+			})
+		} else if err != nil {
+			return err
+		}
+
+		r.setCookie(ctx, mfaCookie, "", time.Unix(0, 0))
+		r.setSessionCookie(ctx, token, session.ExpiresAt)
+
+		return ctx.Redirect("/")
+	}).Get("/account/2fa", r.requireAuth, func(ctx *fiber.Ctx) error {
+		return r.totpPage(ctx, fiber.StatusOK, nil)
+	}).Post("/account/2fa", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Включаем второй фактор после проверки первого кода
+		codes, err := r.core.ConfirmTOTPEnrollment(currentUser(ctx).Name, formValue(form, "code"))
+		if errors.Is(err, core.ErrValidation) {
+			return r.totpPage(ctx, fiber.StatusBadRequest, fiber.Map{"Errors": formErrors(err)})
+		} else if err != nil {
+			return err
+		}
+
+		return r.totpPage(ctx, fiber.StatusOK, fiber.Map{"RecoveryCodes": codes})
+	}).Post("/account/2fa/disable", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Отключаем второй фактор после повторного ввода пароля
+		err = r.core.DisableTOTP(currentUser(ctx).Name, formValue(form, "password"))
+		if errors.Is(err, core.ErrValidation) {
+			return r.accountPage(ctx, fiber.StatusBadRequest, fiber.Map{"TOTPErrors": formErrors(err)})
+		} else if err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/account")
+	})
+}
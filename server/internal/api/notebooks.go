@@ -0,0 +1,257 @@
+package api
+
+import (
+	"mime/multipart"
+	"my_notes_project/internal/entities"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func parseNotebookID(ctx *fiber.Ctx) (uint64, error) {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid notebook id")
+	}
+
+	return id, nil
+}
+
+func (r *RestAPI) apiListNotebooks(ctx *fiber.Ctx) error {
+	// Плоский список блокнотов, дерево собирается по parent_id
+	notebooks, err := r.core.GetNotebooks(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]NotebookResponse, 0, len(notebooks))
+	for _, notebook := range notebooks {
+		resp = append(resp, newNotebookResponse(notebook))
+	}
+
+	return ctx.JSON(resp)
+}
+
+func (r *RestAPI) apiCreateNotebook(ctx *fiber.Ctx) error {
+	// Создаем блокнот и возвращаем его вместе с адресом
+	var req NotebookRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	notebook, err := r.core.CreateNotebook(currentUser(ctx).Name, req.Name, req.ParentID)
+	if err != nil {
+		return err
+	}
+
+	ctx.Location(apiV1Prefix + "/notebooks/" + strconv.FormatUint(notebook.ID, 10))
+	return ctx.Status(fiber.StatusCreated).JSON(newNotebookResponse(notebook))
+}
+
+func (r *RestAPI) apiGetNotebook(ctx *fiber.Ctx) error {
+	// Блокнот получает только его владелец
+	id, err := parseNotebookID(ctx)
+	if err != nil {
+		return err
+	}
+
+	notebook, err := r.core.GetNotebook(currentUser(ctx).Name, id)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newNotebookResponse(notebook))
+}
+
+func (r *RestAPI) apiRenameNotebook(ctx *fiber.Ctx) error {
+	id, err := parseNotebookID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req NotebookRenameRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err = r.core.RenameNotebook(currentUser(ctx).Name, id, req.Name); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiMoveNotebook(ctx *fiber.Ctx) error {
+	id, err := parseNotebookID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req NotebookMoveRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err = r.core.MoveNotebook(currentUser(ctx).Name, id, req.ParentID); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiDeleteNotebook(ctx *fiber.Ctx) error {
+	// Вместе с блокнотом удаляются вложенные блокноты, заметки из них попадают в корзину
+	id, err := parseNotebookID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = r.core.DeleteNotebook(currentUser(ctx).Name, id); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiMoveNote(ctx *fiber.Ctx) error {
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req NoteMoveRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err = r.core.MoveNote(currentUser(ctx).Name, id, req.NotebookID); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func flattenNotebooks(nodes []*entities.NotebookNode) []*entities.NotebookNode {
+	// Дерево блокнотов в порядке обхода для боковой панели и списков выбора,
+	// вложенность показывается отступом по Depth
+	flat := []*entities.NotebookNode{}
+	for _, node := range nodes {
+		flat = append(flat, node)
+		flat = append(flat, flattenNotebooks(node.Children)...)
+	}
+
+	return flat
+}
+
+func formParentID(form *multipart.Form, key string) (*uint64, error) {
+	// Пустое значение означает верхний уровень
+	value := formValue(form, key)
+	if value == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "invalid "+key)
+	}
+
+	return &id, nil
+}
+
+func (r *RestAPI) notebooksInit() {
+	// Формы боковой панели главной страницы, после действия возвращаемся к блокноту
+	r.app.Post("/notebooks", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем блокнот внутри выбранного или на верхнем уровне
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		parentID, err := formParentID(form, "parent_id")
+		if err != nil {
+			return err
+		}
+
+		notebook, err := r.core.CreateNotebook(currentUser(ctx).Name, formValue(form, "name"), parentID)
+		if err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/?notebook_id=" + strconv.FormatUint(notebook.ID, 10))
+	}).Post("/notebooks/:id/rename", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		id, err := parseNotebookID(ctx)
+		if err != nil {
+			return err
+		}
+
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		if err = r.core.RenameNotebook(currentUser(ctx).Name, id, formValue(form, "name")); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/?notebook_id=" + ctx.Params("id"))
+	}).Post("/notebooks/:id/move", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		id, err := parseNotebookID(ctx)
+		if err != nil {
+			return err
+		}
+
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		parentID, err := formParentID(form, "parent_id")
+		if err != nil {
+			return err
+		}
+
+		if err = r.core.MoveNotebook(currentUser(ctx).Name, id, parentID); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/?notebook_id=" + ctx.Params("id"))
+	}).Post("/notebooks/:id/delete", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Удаляем блокнот с вложенными, их заметки переносим в корзину
+		id, err := parseNotebookID(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err = r.core.DeleteNotebook(currentUser(ctx).Name, id); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/")
+	}).Post("/note/:id/move", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Переносим заметку в выбранный блокнот
+		id, err := noteIDParam(ctx)
+		if err != nil {
+			return err
+		}
+
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		notebookID, err := strconv.ParseUint(formValue(form, "notebook_id"), 10, 64)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid notebook_id")
+		}
+
+		if err = r.core.MoveNote(currentUser(ctx).Name, id, notebookID); err != nil {
+			return err
+		}
+
+		return ctx.RedirectBack("/")
+	})
+}
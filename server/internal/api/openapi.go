@@ -0,0 +1,341 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Маршрут JSON API вместе с описанием для OpenAPI
+type apiRoute struct {
+	ID          string
+	Method      string
+	Path        string
+	Summary     string
+	Auth        bool
+	Scope       string // область доступа, которая нужна токену; сессии доступно все
+	SessionOnly bool   // маршрут недоступен по токену, только из сессии
+	Params      []apiParam
+	Request     interface{}
+	Status      int
+	Response    interface{}
+	Errors      []int
+	Handler     fiber.Handler
+}
+
+// Параметр пути, строки запроса или заголовок
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+}
+
+var idParam = apiParam{
+	Name:        "id",
+	In:          "path",
+	Type:        "integer",
+	Description: "Note id",
+	Required:    true,
+}
+
+var tokenIDParam = apiParam{
+	Name:        "id",
+	In:          "path",
+	Type:        "integer",
+	Description: "Token id",
+	Required:    true,
+}
+
+var notebookIDParam = apiParam{
+	Name:        "id",
+	In:          "path",
+	Type:        "integer",
+	Description: "Notebook id",
+	Required:    true,
+}
+
+var ifMatchParam = apiParam{
+	Name:        "If-Match",
+	In:          "header",
+	Type:        "string",
+	Description: `ETag of the note as it was read, e.g. "3"`,
+	Required:    true,
+}
+
+var revisionParam = apiParam{
+	Name:        "rev",
+	In:          "path",
+	Type:        "integer",
+	Description: "Revision number, the first revision is created with the note",
+	Required:    true,
+}
+
+var tagNameParam = apiParam{
+	Name:        "tag",
+	In:          "path",
+	Type:        "string",
+	Description: "Tag name",
+	Required:    true,
+}
+
+// Параметры списка заметок
+var noteListParams = []apiParam{
+	{Name: "q", In: "query", Type: "string", Description: `Full-text search: words, "phrases" and prefix*; returns the best matches with snippets, without paging`},
+	{Name: "limit", In: "query", Type: "integer", Description: "Page size, 20 by default, at most 100"},
+	{Name: "cursor", In: "query", Type: "string", Description: "next_cursor from the previous page"},
+	{Name: "sort", In: "query", Type: "string", Description: "created (default), updated or title"},
+	{Name: "order", In: "query", Type: "string", Description: "asc (default) or desc"},
+	{Name: "title", In: "query", Type: "string", Description: "Only notes whose title contains this text"},
+	{Name: "notebook_id", In: "query", Type: "integer", Description: "Only notes lying directly in this notebook"},
+	{Name: "tags", In: "query", Type: "string", Description: "Comma-separated tag names to filter by"},
+	{Name: "tag_mode", In: "query", Type: "string", Description: "all (default): notes with every tag; any: notes with at least one"},
+}
+
+// Параметры сравнения правок заметки
+var revisionDiffParams = []apiParam{
+	idParam,
+	{Name: "from", In: "query", Type: "integer", Description: "Older revision, the one before to by default"},
+	{Name: "to", In: "query", Type: "integer", Description: "Newer revision, the latest by default"},
+}
+
+func buildOpenAPI(prefix string, routes []apiRoute) map[string]interface{} {
+	// Собираем документ OpenAPI 3 из таблицы маршрутов
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	for _, route := range routes {
+		path := prefix + openAPIPath(route.Path)
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+
+		item[strings.ToLower(route.Method)] = buildOperation(route, schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Notes API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"sessionCookie": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        sessionCookie,
+					"description": "Session cookie, requests other than GET also need the csrf_token cookie value in the X-CSRF-Token header",
+				},
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal access token",
+				},
+			},
+		},
+	}
+}
+
+func buildOperation(route apiRoute, schemas map[string]interface{}) map[string]interface{} {
+	// Описание одной операции: параметры, тело запроса и ответы
+	op := map[string]interface{}{
+		"operationId": route.ID,
+		"summary":     route.Summary,
+	}
+
+	if route.Scope != "" {
+		op["description"] = "Required token scope: " + route.Scope
+	} else if route.SessionOnly {
+		op["description"] = "Not available with personal access tokens"
+	}
+
+	if len(route.Params) > 0 {
+		params := make([]interface{}, 0, len(route.Params))
+		for _, p := range route.Params {
+			param := map[string]interface{}{
+				"name":     p.Name,
+				"in":       p.In,
+				"required": p.Required,
+				"schema":   map[string]interface{}{"type": p.Type},
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+
+			params = append(params, param)
+		}
+
+		op["parameters"] = params
+	}
+
+	if route.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(schemaFor(reflect.TypeOf(route.Request), schemas)),
+		}
+	}
+
+	responses := map[string]interface{}{}
+
+	success := map[string]interface{}{"description": http.StatusText(route.Status)}
+	if route.Response != nil {
+		success["content"] = jsonContent(schemaFor(reflect.TypeOf(route.Response), schemas))
+	}
+	responses[strconv.Itoa(route.Status)] = success
+
+	// Все ошибки возвращаются в одном формате
+	errorSchema := schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)
+	codes := append([]int{}, route.Errors...)
+	if route.Request != nil {
+		// parseBody принимает только JSON
+		codes = append(codes, fiber.StatusUnsupportedMediaType)
+	}
+	if route.Auth {
+		codes = append(codes, fiber.StatusUnauthorized)
+	}
+	// Запрос с сессией без CSRF токена, нехватка прав токена или вход только по сессии
+	if (route.Auth && route.Method != fiber.MethodGet) || route.Scope != "" || route.SessionOnly {
+		codes = append(codes, fiber.StatusForbidden)
+	}
+	codes = append(codes, fiber.StatusInternalServerError)
+
+	for _, code := range codes {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     jsonContent(errorSchema),
+		}
+	}
+
+	op["responses"] = responses
+
+	if route.Auth {
+		security := []interface{}{
+			map[string]interface{}{"sessionCookie": []string{}},
+		}
+		if !route.SessionOnly {
+			security = append(security, map[string]interface{}{"bearerAuth": []string{}})
+		}
+
+		op["security"] = security
+	}
+
+	return op
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		fiber.MIMEApplicationJSON: map[string]interface{}{"schema": schema},
+	}
+}
+
+func openAPIPath(path string) string {
+	// Параметры fiber вида :id превращаем в {id}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + strings.TrimPrefix(part, ":") + "}"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	// Схема JSON для типа DTO, именованные структуры выносятся в components
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := schemaFor(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Struct:
+		if _, exists := schemas[t.Name()]; !exists {
+			// Сначала резервируем имя, чтобы не зациклиться на рекурсивных типах
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	// Поля берем из тегов json, поля без omitempty считаем обязательными
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Поля встроенной структуры encoding/json поднимает на уровень выше
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := structSchema(field.Type, schemas)
+			for k, v := range embedded["properties"].(map[string]interface{}) {
+				properties[k] = v
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaFor(field.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+
+	return schema
+}
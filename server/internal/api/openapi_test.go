@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type openAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type openAPIOperation struct {
+	OperationID string `json:"operationId"`
+	Parameters  []struct {
+		Name string `json:"name"`
+		In   string `json:"in"`
+	} `json:"parameters"`
+	Responses map[string]interface{} `json:"responses"`
+}
+
+func fetchOpenAPI(t *testing.T, r *RestAPI) ([]byte, openAPIDocument) {
+	resp, err := r.app.Test(httptest.NewRequest(fiber.MethodGet, "/api/openapi.json", nil))
+	require.Nil(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	var doc openAPIDocument
+	require.Nil(t, json.Unmarshal(body, &doc))
+
+	return body, doc
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	require.Nil(t, r.HandlersInit())
+
+	_, doc := fetchOpenAPI(t, r)
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	// Каждый зарегистрированный маршрут API описан в спецификации
+	registered := map[string]bool{}
+	for _, route := range r.app.GetRoutes(true) {
+		if !strings.HasPrefix(route.Path, apiV1Prefix+"/") || route.Method == fiber.MethodHead {
+			continue
+		}
+
+		path := openAPIPath(route.Path)
+		method := strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+
+		op, exists := doc.Paths[path][method]
+		if !assert.True(t, exists, "route %s %s is not documented", route.Method, route.Path) {
+			continue
+		}
+
+		assert.NotEmpty(t, op.Responses, "%s %s", route.Method, route.Path)
+
+		for _, name := range route.Params {
+			found := false
+			for _, p := range op.Parameters {
+				found = found || (p.In == "path" && p.Name == name)
+			}
+
+			assert.True(t, found, "path parameter %s of %s %s is not documented", name, route.Method, route.Path)
+		}
+	}
+
+	// Ошибки, которые возвращают общие обработчики, описаны у каждого маршрута
+	for _, route := range r.v1Routes() {
+		op := doc.Paths[openAPIPath(apiV1Prefix+route.Path)][strings.ToLower(route.Method)]
+		if route.Request != nil {
+			assert.Contains(t, op.Responses, "415", "%s %s accepts only JSON", route.Method, route.Path)
+		}
+		if route.Auth && route.Method != fiber.MethodGet {
+			assert.Contains(t, op.Responses, "403", "%s %s checks the CSRF token", route.Method, route.Path)
+		}
+	}
+
+	// Изменение заметки без If-Match отклоняется
+	assert.Contains(t, doc.Paths["/api/v1/notes/{id}"]["put"].Responses, "428")
+
+	// И наоборот, в спецификации нет несуществующих маршрутов
+	operationIDs := map[string]bool{}
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			assert.True(t, registered[method+" "+path], "documented %s %s is not registered", method, path)
+			assert.NotEmpty(t, op.OperationID)
+			assert.False(t, operationIDs[op.OperationID], "duplicate operationId %s", op.OperationID)
+			operationIDs[op.OperationID] = true
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	r := NewRestAPI(nil, logrus.New())
+	require.Nil(t, r.HandlersInit())
+
+	body, _ := fetchOpenAPI(t, r)
+
+	var raw map[string]interface{}
+	require.Nil(t, json.Unmarshal(body, &raw))
+
+	schemas := raw["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	assert.Contains(t, schemas, "ErrorResponse")
+
+	// Все ссылки $ref указывают на существующие схемы
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if ref, ok := value.(string); ok && key == "$ref" {
+					name := strings.TrimPrefix(ref, "#/components/schemas/")
+					assert.Contains(t, schemas, name, "unresolved reference %s", ref)
+				}
+				walk(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(raw)
+}
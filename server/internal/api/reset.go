@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"my_notes_project/internal/core"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (r *RestAPI) apiUpdateEmail(ctx *fiber.Ctx) error {
+	// Меняем адрес почты после проверки пароля и возвращаем пользователя
+	var req EmailRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	username := currentUser(ctx).Name
+	if err := r.core.UpdateEmail(username, req.Password, req.Email); err != nil {
+		return err
+	}
+
+	user, err := r.core.GetUserByName(username)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newUserResponse(user))
+}
+
+func (r *RestAPI) apiRequestPasswordReset(ctx *fiber.Ctx) error {
+	// Ответ одинаковый для известных и неизвестных адресов
+	var req PasswordResetRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err := r.core.RequestPasswordReset(req.Email); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusAccepted)
+}
+
+func (r *RestAPI) apiConfirmPasswordReset(ctx *fiber.Ctx) error {
+	// Устанавливаем новый пароль по токену из письма
+	var req PasswordResetConfirmRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if req.NewPasswordRepeat == "" {
+		req.NewPasswordRepeat = req.NewPassword
+	}
+
+	if err := r.core.ResetPassword(req.Token, req.NewPassword, req.NewPasswordRepeat); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) passwordPage(ctx *fiber.Ctx, name string, status int, m fiber.Map) error {
+	// Страницы восстановления пароля доступны без входа
+	data := fiber.Map{
+		"Title": "Password reset",
+	}
+	for k, v := range m {
+		data[k] = v
+	}
+
+	return r.render(ctx, status, name, data)
+}
+
+func (r *RestAPI) passwordResetInit() {
+	// HTML страницы для запроса письма и установки нового пароля
+	r.app.Get("/password/forgot", func(ctx *fiber.Ctx) error {
+		return r.passwordPage(ctx, "forgot", fiber.StatusOK, nil)
+	}).Post("/password/forgot", r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Отправляем письмо, если адрес принадлежит пользователю
+		email := formValue(form, "email")
+		if err = core.ValidateEmail(email); err != nil {
+			return r.passwordPage(ctx, "forgot", fiber.StatusBadRequest, fiber.Map{"Errors": formErrors(err)})
+		}
+
+		if err = r.core.RequestPasswordReset(email); err != nil {
+			return err
+		}
+
+		return r.passwordPage(ctx, "forgot", fiber.StatusOK, fiber.Map{"Sent": true})
+	}).Get("/password/reset", func(ctx *fiber.Ctx) error {
+		return r.passwordPage(ctx, "reset", fiber.StatusOK, fiber.Map{"Token": ctx.Query("token")})
+	}).Post("/password/reset", r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Устанавливаем новый пароль, ошибки показываем рядом с формой
+		token := formValue(form, "token")
+		err = r.core.ResetPassword(token, formValue(form, "password1"), formValue(form, "password2"))
+		if errors.Is(err, core.ErrValidation) {
+			return r.passwordPage(ctx, "reset", fiber.StatusBadRequest, fiber.Map{
+				"Token":  token,
+				"Errors": formErrors(err),
+			})
+		} else if err != nil {
+			return err
+		}
+
+		return r.passwordPage(ctx, "reset", fiber.StatusOK, fiber.Map{"Done": true})
+	})
+}
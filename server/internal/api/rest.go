@@ -1,10 +1,13 @@
 package api
 
 import (
-	"fmt"
+	"errors"
+	"mime/multipart"
 	"my_notes_project/internal/core"
 	"my_notes_project/internal/entities"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/html/v2"
@@ -13,30 +16,85 @@ import (
 
 type RestAPI struct {
 	// механизм,для связи с серверами через HTTP,логгером...
-	app    *fiber.App
-	logger *logrus.Logger
-	core   core.ServiceCore
+	app           *fiber.App
+	logger        *logrus.Logger
+	core          core.ServiceCore
+	secureCookies bool
 }
 
-func NewRestAPI(core core.ServiceCore, logger *logrus.Logger) *RestAPI {
+// Дополнительная настройка API
+type Option func(*RestAPI)
+
+// Отправлять куки только по HTTPS
+func WithSecureCookies(secure bool) Option {
+	return func(r *RestAPI) {
+		r.secureCookies = secure
+	}
+}
+
+func NewRestAPI(core core.ServiceCore, logger *logrus.Logger, opts ...Option) *RestAPI {
 	//Новый экземпляр для шаблонизатора
 	//Новый экземпляр  для файбер,в которую передаем дополнительные параметры конфигурации
 	engine := html.New("./web/templates", ".html")
-	app := fiber.New(fiber.Config{
-		Views: engine,
+	// Метки заметки в полях форм перечисляются через запятую
+	engine.AddFunc("join", strings.Join)
+	// Отступ вложенных блокнотов в списках выбора
+	engine.AddFunc("repeat", strings.Repeat)
+	r := &RestAPI{
+		logger:        logger,
+		core:          core,
+		secureCookies: true,
+	}
+
+	// Все ошибки обработчиков проходят через errorHandler
+	r.app = fiber.New(fiber.Config{
+		Views:        engine,
+		ErrorHandler: r.errorHandler,
 	})
 
 	//Для улучшения внешнего вид домашней страницы, добавила в проект статические файлы
-	app.Static("/static/", "./web/static")
+	r.app.Static("/static/", "./web/static")
 
-	return &RestAPI{
-		app:    app,
-		logger: logger,
-		core:   core,
+	for _, opt := range opts {
+		opt(r)
 	}
+
+	return r
 }
 
 func (r *RestAPI) HandlersInit() error {
+	// Определяем пользователя по куки сессии для всех обработчиков ниже
+	r.app.Use(r.sessionMiddleware)
+
+	// JSON API
+	if err := r.v1Init(); err != nil {
+		return err
+	}
+
+	// Персональные токены для доступа к API
+	r.tokensInit()
+
+	// Смена пароля и удаление учетной записи
+	r.accountInit()
+
+	// Восстановление пароля по письму
+	r.passwordResetInit()
+
+	// Двухфакторная аутентификация
+	r.mfaInit()
+
+	// Управление метками
+	r.tagsInit()
+
+	// Блокноты в боковой панели
+	r.notebooksInit()
+
+	// История изменений заметки
+	r.revisionsInit()
+
+	// Корзина удаленных заметок
+	r.trashInit()
+
 	// Добавляем иконку на сайт, обращаемся к статическому файлу
 	r.app.Get("favicon.ico", func(ctx *fiber.Ctx) error {
 		return ctx.SendFile("web/static/favicon.ico")
@@ -47,71 +105,118 @@ func (r *RestAPI) HandlersInit() error {
 			"Title":    "Notes",
 		}
 
-		// Создаем ключ, проверяем на пустую строку
-		// Получаем заметки пользователя,если они у него были
-		if username := ctx.Cookies("username"); username != "" {
+		// Получаем заметки пользователя,если он аутентифицирован
+		if user := currentUser(ctx); user != nil {
 			m["IsAuthed"] = true
-			notes, err := r.core.GetNotesByUserName(username)
+
+			// Результаты поиска показываем вместо списка
+			if query := ctx.Query("q"); query != "" {
+				matches, err := r.core.SearchNotes(user.Name, query)
+				if err != nil {
+					return err
+				}
+
+				results := make([]fiber.Map, 0, len(matches))
+				for _, match := range matches {
+					results = append(results, fiber.Map{
+						"Note":    match.Note,
+						"Snippet": snippetHTML(match.Snippet),
+					})
+				}
+
+				m["Query"] = query
+				m["Results"] = results
+				return r.render(ctx, fiber.StatusOK, "index", m)
+			}
+
+			// Заметки показываем постранично в выбранном порядке
+			q, err := noteQueryParams(ctx)
 			if err != nil {
-				r.logger.Error(err)
-				return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+				return err
 			}
 
-			m["Notes"] = notes
+			page, err := r.core.ListNotes(user.Name, q)
+			if err != nil {
+				return err
+			}
+
+			m["Notes"] = page.Notes
+			m["Sort"] = ctx.Query("sort")
+			m["Order"] = ctx.Query("order")
+			m["Filter"] = q.Title
+			m["TagFilter"] = ctx.Query("tags")
+			m["TagMode"] = ctx.Query("tag_mode")
+
+			// Дерево блокнотов для боковой панели, выбранный блокнот фильтрует заметки
+			tree, err := r.core.GetNotebookTree(user.Name)
+			if err != nil {
+				return err
+			}
+			m["Notebooks"] = flattenNotebooks(tree)
+			if q.NotebookID != 0 {
+				notebook, err := r.core.GetNotebook(user.Name, q.NotebookID)
+				if err != nil {
+					return err
+				}
+				m["Notebook"] = notebook
+			}
+
+			// Метки пользователя для быстрого фильтра
+			tags, err := r.core.GetTags(user.Name)
+			if err != nil {
+				return err
+			}
+			m["Tags"] = tags
+			if page.Next != nil {
+				m["NextURL"] = nextPageURL(ctx, page.Next)
+			}
 		}
 
 		r.logger.Debug(m)
-		return ctx.Render("index", m)
-	}).Post("/reg", func(ctx *fiber.Ctx) error {
+		return r.render(ctx, fiber.StatusOK, "index", m)
+	}).Post("/reg", r.csrfProtect, func(ctx *fiber.Ctx) error {
 		// Создаем пост запрос для регистрации пользователя
 		// Создаем форму для анализа, поступивших данных
 		form, err := ctx.MultipartForm()
 		if err != nil {
 			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
-		}
-
-		//Проверяем на валидацию имя пользователя
-		var name, password1, password2 string
-		if vals, exists := form.Value["username"]; !exists || len(vals) == 0 {
-			return ctx.Status(fiber.StatusBadRequest).SendString("no username")
-		} else {
-			name = vals[0]
-		}
-
-		//Проверяем на валидацию полученный пароль
-		if vals, exists := form.Value["password1"]; !exists || len(vals) == 0 {
-			return ctx.Status(fiber.StatusBadRequest).SendString("no password")
-		} else {
-			password1 = vals[0]
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
-		//Проверяем на соответствие этому паролю уже ранее полученный пароль
-		if vals, exists := form.Value["password2"]; !exists || len(vals) == 0 {
-			return ctx.Status(fiber.StatusBadRequest).SendString("no password")
-		} else {
-			password2 = vals[0]
-		}
-
-		if err = r.core.RegisterUser(name, password1, password2); err != nil {
+		//Проверяем на валидацию имя пользователя и пароли
+		name := formValue(form, "username")
+		password1 := formValue(form, "password1")
+		password2 := formValue(form, "password2")
+
+		err = r.core.RegisterUser(name, password1, password2)
+		if errors.Is(err, core.ErrValidation) || errors.Is(err, core.ErrConflict) {
+			//Показываем причину отказа рядом с формой регистрации
+			status, _ := errorStatus(err)
+			return r.render(ctx, status, "index", fiber.Map{
+				"IsAuthed":    false,
+				"Title":       "Notes",
+				"RegUsername": name,
+				"RegErrors":   formErrors(err),
+			})
+		} else if err != nil {
 			return err
 		}
 
 		return ctx.RedirectBack("/")
-	}).Post("/auth", func(ctx *fiber.Ctx) error {
+	}).Post("/auth", r.csrfProtect, func(ctx *fiber.Ctx) error {
 		//Создаем обработчик для аутентификации пользователя
 		//Создаем форму
 		form, err := ctx.MultipartForm()
 		if err != nil {
 			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
 		//Проверяем имя пользователя
 		var name, password string
 		if vals, exists := form.Value["username"]; !exists || len(vals) == 0 {
 			r.logger.Error("no username")
-			return ctx.Status(fiber.StatusBadRequest).SendString("no username")
+			return fiber.NewError(fiber.StatusBadRequest, "no username")
 		} else {
 			name = vals[0]
 		}
@@ -119,58 +224,67 @@ func (r *RestAPI) HandlersInit() error {
 		//Проверяем пароль пользователя
 		if vals, exists := form.Value["password"]; !exists || len(vals) == 0 {
 			r.logger.Error("no password")
-			return ctx.Status(fiber.StatusBadRequest).SendString("no password")
+			return fiber.NewError(fiber.StatusBadRequest, "no password")
 		} else {
 			password = vals[0]
 		}
 
-		//Проверяем действительно ли сопадает с данными пользователя
-		isValid, err := r.core.IsValidUserCredentials(name, password)
+		//Проверяем данные пользователя, после серии ошибок вход временно блокируется
+		if _, err = r.core.Authenticate(name, password, ctx.IP()); err != nil {
+			return err
+		}
+
+		//Создаем новую сессию и позволяем пользователю зайти
+		token, session, err := r.core.CreateSession(name)
 		if err != nil {
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
-		} else if !isValid {
-			return fmt.Errorf("invalid credentials")
+			return err
 		}
 
-		//Читаем поля формы и позволяем пользователю зайти
-		ctx.Cookie(&fiber.Cookie{
-			Name:  "username",
-			Value: name,
-			Path:  "/",
-		})
+		//Со вторым фактором сначала спрашиваем код
+		if session.MFAPending {
+			r.setCookie(ctx, mfaCookie, token, session.ExpiresAt)
+			return ctx.Redirect("/auth/2fa")
+		}
+
+		r.setSessionCookie(ctx, token, session.ExpiresAt)
 
 		return ctx.RedirectBack("/")
-	}).Get("/logout", func(ctx *fiber.Ctx) error {
-		//Проверяем авторизован ли пользователь, если да, то удаляем его куки
-		username := ctx.Cookies("username")
-		if username == "" {
-			return fmt.Errorf("not authed")
+	}).Post("/logout", r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Завершаем текущую сессию на сервере и удаляем куки
+		if token := ctx.Cookies(sessionCookie); token != "" {
+			if err := r.core.RevokeSession(token); err != nil {
+				return err
+			}
 		}
 
-		ctx.ClearCookie("username")
+		r.clearSessionCookie(ctx)
 
-		return ctx.RedirectBack("/")
-	}).Post("/note/add", func(ctx *fiber.Ctx) error {
-		//Создаем обработчик для добавления заметок
-		//Для начала проверяем на авторизацию
-		username := ctx.Cookies("username")
-		if username == "" {
-			r.logger.Error("not authed")
-			return fiber.NewError(fiber.StatusBadRequest, "not authed")
+		return ctx.Redirect("/")
+	}).Post("/logout/all", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Завершаем все сессии пользователя, в том числе на других устройствах
+		if err := r.core.RevokeAllSessions(currentUser(ctx).Name); err != nil {
+			return err
 		}
 
+		r.clearSessionCookie(ctx)
+
+		return ctx.Redirect("/")
+	}).Post("/note/add", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем обработчик для добавления заметок
+		username := currentUser(ctx).Name
+
 		//Создаем форму
 		form, err := ctx.MultipartForm()
 		if err != nil {
 			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
 		//Проверяем наличие заголовка заметки
 		var title, content string
 		if vals, exists := form.Value["title"]; !exists || len(vals) == 0 {
 			r.logger.Error("no title")
-			return ctx.Status(fiber.StatusBadRequest).SendString("no title")
+			return fiber.NewError(fiber.StatusBadRequest, "no title")
 		} else {
 			title = vals[0]
 		}
@@ -178,44 +292,50 @@ func (r *RestAPI) HandlersInit() error {
 		//Проверяем содержание заметки
 		if vals, exists := form.Value["content"]; !exists || len(vals) == 0 {
 			r.logger.Error("no content")
-			return ctx.Status(fiber.StatusBadRequest).SendString("no content")
+			return fiber.NewError(fiber.StatusBadRequest, "no content")
 		} else {
 			content = vals[0]
 		}
 
+		//Блокнот можно не выбирать, тогда заметка попадет в блокнот по умолчанию
+		var notebookID uint64
+		if value := formValue(form, "notebook_id"); value != "" {
+			if notebookID, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid notebook_id")
+			}
+		}
+
 		//Читаем поля формы и добавляем заметку
 		if err = r.core.AddNoteToUserByName(username, &entities.Note{
-			Title:   title,
-			Content: content,
+			Title:      title,
+			Content:    content,
+			Tags:       formTags(form),
+			NotebookID: notebookID,
 		}); err != nil {
 			return err
 		}
 
 		return ctx.RedirectBack("/")
-	}).Get("/note/remove/:id", func(ctx *fiber.Ctx) error {
-		//Создаем обработчик гет для удаления статьи
+	}).Post("/note/remove/:id", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем обработчик для удаления статьи
 		//Получаем id, парсим его, получаем значение
 		id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
 		if err != nil {
 			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
-		//По полученному id удаляем заметку
+		//По полученному id удаляем заметку, если она принадлежит пользователю
 		r.logger.Debug(id)
-		if err := r.core.RemoveNoteByID(id); err != nil {
-			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		if err = r.core.RemoveNoteByUserName(currentUser(ctx).Name, id); err != nil {
+			return err
 		}
 
-		return ctx.RedirectBack("/")
-	}).Post("/note/update/:id", func(ctx *fiber.Ctx) error {
+		//Страница удаленной заметки больше не открывается, поэтому возвращаемся на главную
+		return ctx.Redirect("/")
+	}).Post("/note/update/:id", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
 		//Создаем обработчик гет для обновления статьи
-		//Проверяем на авторизацию пользователя
-		username := ctx.Cookies("username")
-		if username == "" {
-			return fmt.Errorf("not authed")
-		}
+		username := currentUser(ctx).Name
 
 		r.logger.Debug(username)
 
@@ -223,7 +343,7 @@ func (r *RestAPI) HandlersInit() error {
 		id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
 		if err != nil {
 			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
 		r.logger.Debug(id)
@@ -233,12 +353,12 @@ func (r *RestAPI) HandlersInit() error {
 		var title, content string
 		if err != nil {
 			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
 
 		//Проверяем  наличие заголовка заметки
 		if vals, exists := form.Value["title"]; !exists || len(vals) == 0 {
-			return ctx.Status(fiber.StatusBadRequest).SendString("no title")
+			return fiber.NewError(fiber.StatusBadRequest, "no title")
 		} else {
 			title = vals[0]
 
@@ -246,29 +366,121 @@ func (r *RestAPI) HandlersInit() error {
 
 		//Проверяем содержание заметки
 		if vals, exists := form.Value["content"]; !exists || len(vals) == 0 {
-			return ctx.Status(fiber.StatusBadRequest).SendString("no content")
+			return fiber.NewError(fiber.StatusBadRequest, "no content")
 		} else {
 			content = vals[0]
 		}
 
+		//Версия, которую пользователь видел, когда открыл форму
+		version, err := formVersion(form)
+		if err != nil {
+			return err
+		}
+
 		//Читаем поля формы и обновляем соответствующие поля у заметки
-		err = r.core.UpdateNoteByUserName(username, &entities.Note{
+		note := &entities.Note{
 			ID:      id,
 			Title:   title,
 			Content: content,
-		})
+			Tags:    formTags(form),
+			Version: version,
+		}
+		err = r.core.UpdateNoteByUserName(username, note)
+
+		//Заметку успели изменить, показываем обе версии рядом, чтобы пользователь выбрал
+		var conflict *core.VersionConflictError
+		if errors.As(err, &conflict) {
+			_, hasTags := form.Value["tags"]
+			return r.render(ctx, fiber.StatusConflict, "conflict", fiber.Map{
+				"Title":   "Conflict: " + conflict.Current.Title,
+				"Mine":    note,
+				"Current": conflict.Current,
+				"HasTags": hasTags,
+				"Tags":    formValue(form, "tags"),
+			})
+		} else if err != nil {
+			return err
+		}
+
+		//Страница конфликта не передает Referer, после нее открываем заметку
+		return ctx.RedirectBack("/note/" + ctx.Params("id"))
+	}).Get("/note/:id", r.requireAuth, func(ctx *fiber.Ctx) error {
+		//Страница одной заметки для просмотра и редактирования
+		id, err := noteIDParam(ctx)
 		if err != nil {
-			r.logger.Error(err)
-			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			return err
 		}
 
-		return ctx.RedirectBack("/")
+		note, err := r.core.GetNoteByID(currentUser(ctx).Name, id)
+		if err != nil {
+			return err
+		}
+
+		//Блокноты для переноса заметки
+		tree, err := r.core.GetNotebookTree(currentUser(ctx).Name)
+		if err != nil {
+			return err
+		}
+
+		return r.render(ctx, fiber.StatusOK, "note", fiber.Map{
+			"Title":     note.Title,
+			"Note":      note,
+			"Notebooks": flattenNotebooks(tree),
+		})
 	})
 
 	return nil
 }
 
+func formValue(form *multipart.Form, key string) string {
+	// Первое значение поля формы или пустая строка
+	if vals, exists := form.Value[key]; exists && len(vals) > 0 {
+		return vals[0]
+	}
+
+	return ""
+}
+
+func formVersion(form *multipart.Form) (int, error) {
+	// Версия заметки из скрытого поля формы, оно есть во всех формах изменения
+	version, err := strconv.Atoi(formValue(form, "version"))
+	if err != nil || version < 1 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid version")
+	}
+
+	return version, nil
+}
+
+func formErrors(err error) []string {
+	// Сообщения об ошибках для показа рядом с формой
+	var verr *core.ValidationError
+	if !errors.As(err, &verr) {
+		_, message := errorStatus(err)
+		return []string{message}
+	}
+
+	msgs := make([]string, 0, len(verr.Fields))
+	for _, f := range verr.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+
+	return msgs
+}
+
 func (r *RestAPI) Listen(addr string) error {
 	//
 	return r.app.Listen(addr)
 }
+
+func nextPageURL(ctx *fiber.Ctx, next *entities.NoteCursor) string {
+	// Ссылка на следующую страницу с теми же параметрами сортировки и фильтра
+	values := url.Values{}
+	for _, key := range []string{"limit", "sort", "order", "title", "notebook_id", "tags", "tag_mode"} {
+		if v := ctx.Query(key); v != "" {
+			values.Set(key, v)
+		}
+	}
+	values.Set("cursor", next.String())
+
+	return ctx.Path() + "?" + values.Encode()
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"my_notes_project/internal/core"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func revisionNumberParam(ctx *fiber.Ctx) (int, error) {
+	number, err := strconv.Atoi(ctx.Params("rev"))
+	if err != nil || number < 1 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid revision number")
+	}
+
+	return number, nil
+}
+
+func revisionDiffQuery(ctx *fiber.Ctx) (from, to int, err error) {
+	// Номера сравниваемых правок из строки запроса, пустые выбирает ядро
+	verr := &core.ValidationError{}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"from", &from}, {"to", &to}} {
+		if s := ctx.Query(p.name); s != "" {
+			if *p.value, err = strconv.Atoi(s); err != nil {
+				verr.Add(p.name, "must be a number")
+			}
+		}
+	}
+
+	return from, to, verr.OrNil()
+}
+
+func (r *RestAPI) apiListRevisions(ctx *fiber.Ctx) error {
+	// История заметки от последней правки к первой, содержимое запрашивается отдельно
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := r.core.GetNoteRevisions(currentUser(ctx).Name, id)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]RevisionResponse, 0, len(revisions))
+	for _, revision := range revisions {
+		item := newRevisionResponse(revision)
+		item.Content = ""
+		resp = append(resp, item)
+	}
+
+	return ctx.JSON(resp)
+}
+
+func (r *RestAPI) apiGetRevision(ctx *fiber.Ctx) error {
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	number, err := revisionNumberParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	revision, err := r.core.GetNoteRevision(currentUser(ctx).Name, id, number)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newRevisionResponse(revision))
+}
+
+func (r *RestAPI) apiRestoreRevision(ctx *fiber.Ctx) error {
+	// Возвращаем заметку к старой правке и отдаем ее новое состояние
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	number, err := revisionNumberParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	note, err := r.core.RestoreNoteRevision(currentUser(ctx).Name, id, number)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderETag, noteETag(note))
+	return ctx.JSON(newNoteResponse(note))
+}
+
+func (r *RestAPI) apiDiffRevisions(ctx *fiber.Ctx) error {
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	from, to, err := revisionDiffQuery(ctx)
+	if err != nil {
+		return err
+	}
+
+	d, err := r.core.DiffNoteRevisions(currentUser(ctx).Name, id, from, to)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newRevisionDiffResponse(d))
+}
+
+func (r *RestAPI) revisionsInit() {
+	// Страница истории заметки со сравнением двух правок и их восстановлением
+	r.app.Get("/note/:id/history", r.requireAuth, func(ctx *fiber.Ctx) error {
+		id, err := noteIDParam(ctx)
+		if err != nil {
+			return err
+		}
+
+		from, to, err := revisionDiffQuery(ctx)
+		if err != nil {
+			return err
+		}
+
+		username := currentUser(ctx).Name
+		note, err := r.core.GetNoteByID(username, id)
+		if err != nil {
+			return err
+		}
+
+		revisions, err := r.core.GetNoteRevisions(username, id)
+		if err != nil {
+			return err
+		}
+
+		d, err := r.core.DiffNoteRevisions(username, id, from, to)
+		if err != nil {
+			return err
+		}
+
+		return r.render(ctx, fiber.StatusOK, "history", fiber.Map{
+			"Title":     "History: " + note.Title,
+			"Note":      note,
+			"Revisions": revisions,
+			"Diff":      d,
+		})
+	}).Post("/note/:id/revisions/:rev/restore", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Восстанавливаем правку и показываем заметку в новом состоянии
+		id, err := noteIDParam(ctx)
+		if err != nil {
+			return err
+		}
+
+		number, err := revisionNumberParam(ctx)
+		if err != nil {
+			return err
+		}
+
+		if _, err = r.core.RestoreNoteRevision(currentUser(ctx).Name, id, number); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/note/" + ctx.Params("id"))
+	})
+}
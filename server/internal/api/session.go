@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"my_notes_project/internal/core"
+	"my_notes_project/internal/entities"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Куки с идентификатором сессии
+	sessionCookie = "session"
+	// Ключ, под которым аутентифицированный пользователь лежит в контексте запроса
+	userKey = "user"
+)
+
+func (r *RestAPI) sessionMiddleware(ctx *fiber.Ctx) error {
+	// Без куки запрос обрабатывается как анонимный
+	token := ctx.Cookies(sessionCookie)
+	if token == "" {
+		return ctx.Next()
+	}
+
+	// Ищем сессию, недействительную куки удаляем
+	user, err := r.core.GetUserBySession(token)
+	if errors.Is(err, core.ErrUnauthorized) {
+		r.clearSessionCookie(ctx)
+		return ctx.Next()
+	} else if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	ctx.Locals(userKey, user)
+	return ctx.Next()
+}
+
+func (r *RestAPI) requireAuth(ctx *fiber.Ctx) error {
+	// Пропускаем дальше только аутентифицированных пользователей
+	if currentUser(ctx) == nil {
+		r.logger.Error("not authed")
+		return fiber.NewError(fiber.StatusUnauthorized, "not authed")
+	}
+
+	return ctx.Next()
+}
+
+func currentUser(ctx *fiber.Ctx) *entities.User {
+	// Пользователь, которого положил sessionMiddleware
+	user, _ := ctx.Locals(userKey).(*entities.User)
+	return user
+}
+
+func (r *RestAPI) setSessionCookie(ctx *fiber.Ctx, token string, expires time.Time) {
+	r.setCookie(ctx, sessionCookie, token, expires)
+}
+
+func (r *RestAPI) clearSessionCookie(ctx *fiber.Ctx) {
+	r.setCookie(ctx, sessionCookie, "", time.Unix(0, 0))
+}
+
+func (r *RestAPI) setCookie(ctx *fiber.Ctx, name, value string, expires time.Time) {
+	// Куки недоступна из JavaScript и не отправляется со сторонних сайтов,
+	// уже истекшая куки удаляется браузером
+	ctx.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   r.secureCookies,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
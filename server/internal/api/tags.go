@@ -0,0 +1,211 @@
+package api
+
+import (
+	"mime/multipart"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func splitTags(s string) []string {
+	// Метки в формах и строке запроса перечисляются через запятую,
+	// пустая строка дает пустой список, а не nil, чтобы снять все метки
+	return strings.Split(s, ",")
+}
+
+func tagParam(ctx *fiber.Ctx) (string, error) {
+	// Имя метки в пути может содержать пробелы и не латинские буквы
+	name, err := url.PathUnescape(ctx.Params("tag"))
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusBadRequest, "invalid tag name")
+	}
+
+	return name, nil
+}
+
+func (r *RestAPI) apiListTags(ctx *fiber.Ctx) error {
+	// Метки пользователя по алфавиту
+	tags, err := r.core.GetTags(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]TagResponse, 0, len(tags))
+	for _, tag := range tags {
+		resp = append(resp, newTagResponse(tag))
+	}
+
+	return ctx.JSON(resp)
+}
+
+func (r *RestAPI) apiAddNoteTags(ctx *fiber.Ctx) error {
+	// Добавляем метки к заметке и возвращаем ее
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req NoteTagsRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	note, err := r.core.AddNoteTags(currentUser(ctx).Name, id, req.Tags)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newNoteResponse(note))
+}
+
+func (r *RestAPI) apiRemoveNoteTag(ctx *fiber.Ctx) error {
+	// Снимаем одну метку с заметки
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tagParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	note, err := r.core.RemoveNoteTags(currentUser(ctx).Name, id, []string{tag})
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newNoteResponse(note))
+}
+
+func (r *RestAPI) apiRenameTag(ctx *fiber.Ctx) error {
+	// Переименовываем метку на всех заметках
+	tag, err := tagParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req TagRenameRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err = r.core.RenameTag(currentUser(ctx).Name, tag, req.Name); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiMergeTag(ctx *fiber.Ctx) error {
+	// Заменяем метку другой на всех заметках
+	tag, err := tagParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req TagMergeRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if err = r.core.MergeTags(currentUser(ctx).Name, tag, req.Into); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiDeleteTag(ctx *fiber.Ctx) error {
+	// Снимаем метку со всех заметок
+	tag, err := tagParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = r.core.DeleteTag(currentUser(ctx).Name, tag); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) tagsPage(ctx *fiber.Ctx, status int, errs []string) error {
+	// Страница управления метками, ошибки показываем над формами
+	tags, err := r.core.GetTags(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	return r.render(ctx, status, "tags", fiber.Map{
+		"Title":  "Tags",
+		"Tags":   tags,
+		"Errors": errs,
+	})
+}
+
+func (r *RestAPI) tagsInit() {
+	// HTML страница для переименования, объединения и удаления меток
+	tagAction := func(action func(username string, form *multipart.Form) error) fiber.Handler {
+		return func(ctx *fiber.Ctx) error {
+			form, err := ctx.MultipartForm()
+			if err != nil {
+				r.logger.Error(err)
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+
+			if err = action(currentUser(ctx).Name, form); err != nil {
+				status, _ := errorStatus(err)
+				return r.tagsPage(ctx, status, formErrors(err))
+			}
+
+			return ctx.Redirect("/tags")
+		}
+	}
+
+	r.app.Get("/tags", r.requireAuth, func(ctx *fiber.Ctx) error {
+		return r.tagsPage(ctx, fiber.StatusOK, nil)
+	}).Post("/tags/rename", r.requireAuth, r.csrfProtect, tagAction(func(username string, form *multipart.Form) error {
+		//Переименовываем метку, занятое имя предлагаем объединить
+		return r.core.RenameTag(username, formValue(form, "tag"), formValue(form, "name"))
+	})).Post("/tags/merge", r.requireAuth, r.csrfProtect, tagAction(func(username string, form *multipart.Form) error {
+		//Переносим заметки на другую метку
+		return r.core.MergeTags(username, formValue(form, "tag"), formValue(form, "into"))
+	})).Post("/tags/delete", r.requireAuth, r.csrfProtect, tagAction(func(username string, form *multipart.Form) error {
+		//Снимаем метку со всех заметок
+		return r.core.DeleteTag(username, formValue(form, "tag"))
+	})).Post("/note/:id/tags/remove", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Снимаем метку с заметки и возвращаемся на ее страницу
+		id, err := noteIDParam(ctx)
+		if err != nil {
+			return err
+		}
+
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		if _, err = r.core.RemoveNoteTags(currentUser(ctx).Name, id, form.Value["tag"]); err != nil {
+			return err
+		}
+
+		return ctx.RedirectBack("/")
+	})
+}
+
+func formTags(form *multipart.Form) []string {
+	// Метки из поля tags формы заметки, nil если поля в форме нет
+	vals, exists := form.Value["tags"]
+	if !exists {
+		return nil
+	}
+
+	if len(vals) == 0 {
+		return []string{}
+	}
+
+	return splitTags(vals[0])
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/core"
+	"my_notes_project/internal/entities"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Ключ, под которым токен запроса лежит в контексте
+const tokenKey = "api_token"
+
+func (r *RestAPI) bearerMiddleware(ctx *fiber.Ctx) error {
+	// Токен из заголовка Authorization заменяет сессию из куки
+	header := ctx.Get(fiber.HeaderAuthorization)
+	if header == "" {
+		return ctx.Next()
+	}
+
+	scheme, plain, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return r.apiUnauthorized(ctx, "invalid authorization header")
+	}
+
+	user, token, err := r.core.GetUserByAPIToken(strings.TrimSpace(plain))
+	if errors.Is(err, core.ErrUnauthorized) {
+		return r.apiUnauthorized(ctx, "invalid token")
+	} else if err != nil {
+		return err
+	}
+
+	ctx.Locals(userKey, user)
+	ctx.Locals(tokenKey, token)
+	return ctx.Next()
+}
+
+func currentToken(ctx *fiber.Ctx) *entities.APIToken {
+	// Токен, которым аутентифицирован запрос, nil для сессии
+	token, _ := ctx.Locals(tokenKey).(*entities.APIToken)
+	return token
+}
+
+func (r *RestAPI) apiRequireScope(scope string) fiber.Handler {
+	// Сессия дает все права, токен только те, что выбраны при создании
+	return func(ctx *fiber.Ctx) error {
+		if token := currentToken(ctx); token != nil && !token.HasScope(scope) {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("token has no %s scope", scope))
+		}
+
+		return ctx.Next()
+	}
+}
+
+func (r *RestAPI) apiRequireSession(ctx *fiber.Ctx) error {
+	// Управлять токенами можно только из сессии, токеном нельзя выпустить новый токен
+	if currentToken(ctx) != nil {
+		return fiber.NewError(fiber.StatusForbidden, "session required")
+	}
+
+	return ctx.Next()
+}
+
+func (r *RestAPI) apiUnauthorized(ctx *fiber.Ctx, message string) error {
+	ctx.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+	return fiber.NewError(fiber.StatusUnauthorized, message)
+}
+
+func (r *RestAPI) apiListTokens(ctx *fiber.Ctx) error {
+	// Возвращаем токены пользователя без секретов
+	tokens, err := r.core.GetAPITokens(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]TokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		resp = append(resp, newTokenResponse(token))
+	}
+
+	return ctx.JSON(resp)
+}
+
+func (r *RestAPI) apiCreateToken(ctx *fiber.Ctx) error {
+	// Выпускаем токен, секрет возвращается только в этом ответе
+	var req TokenRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	plain, token, err := r.core.CreateAPIToken(currentUser(ctx).Name, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(CreatedTokenResponse{
+		Token:         plain,
+		TokenResponse: newTokenResponse(token),
+	})
+}
+
+func (r *RestAPI) apiRevokeToken(ctx *fiber.Ctx) error {
+	// Отзываем токен пользователя
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid token id")
+	}
+
+	if err = r.core.RevokeAPIToken(currentUser(ctx).Name, id); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) tokensPage(ctx *fiber.Ctx, newToken string) error {
+	// Страница управления токенами, новый токен показываем один раз
+	tokens, err := r.core.GetAPITokens(currentUser(ctx).Name)
+	if err != nil {
+		r.logger.Error(err)
+		return err
+	}
+
+	return r.render(ctx, fiber.StatusOK, "tokens", fiber.Map{
+		"Title":    "API tokens",
+		"Tokens":   tokens,
+		"Scopes":   core.Scopes,
+		"NewToken": newToken,
+	})
+}
+
+func (r *RestAPI) tokensInit() {
+	// HTML страница для выпуска и отзыва токенов
+	r.app.Get("/tokens", r.requireAuth, func(ctx *fiber.Ctx) error {
+		return r.tokensPage(ctx, "")
+	}).Post("/tokens", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Создаем форму
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		//Проверяем название токена
+		var name string
+		if vals, exists := form.Value["name"]; !exists || len(vals) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "no name")
+		} else {
+			name = vals[0]
+		}
+
+		//Срок действия в днях можно не указывать, тогда токен бессрочный
+		var expiresAt *time.Time
+		if vals, exists := form.Value["expires_days"]; exists && len(vals) > 0 && vals[0] != "" {
+			days, err := strconv.Atoi(vals[0])
+			if err != nil || days <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid expiration")
+			}
+
+			t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+			expiresAt = &t
+		}
+
+		plain, _, err := r.core.CreateAPIToken(currentUser(ctx).Name, name, form.Value["scopes"], expiresAt)
+		if err != nil {
+			return err
+		}
+
+		return r.tokensPage(ctx, plain)
+	}).Post("/tokens/:id/revoke", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Получаем id токена и отзываем его
+		id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+		if err != nil {
+			r.logger.Error(err)
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		if err = r.core.RevokeAPIToken(currentUser(ctx).Name, id); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/tokens")
+	})
+}
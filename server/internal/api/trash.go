@@ -0,0 +1,75 @@
+package api
+
+import "github.com/gofiber/fiber/v2"
+
+func (r *RestAPI) apiListTrash(ctx *fiber.Ctx) error {
+	// Заметки из корзины, недавно удаленные первыми
+	notes, err := r.core.GetTrash(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]NoteResponse, 0, len(notes))
+	for _, note := range notes {
+		resp = append(resp, newNoteResponse(note))
+	}
+
+	return ctx.JSON(resp)
+}
+
+func (r *RestAPI) apiRestoreNote(ctx *fiber.Ctx) error {
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = r.core.RestoreNote(currentUser(ctx).Name, id); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiEmptyTrash(ctx *fiber.Ctx) error {
+	// Окончательно удаляем заметки из корзины и сообщаем их число
+	n, err := r.core.EmptyTrash(currentUser(ctx).Name)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(TrashEmptyResponse{Deleted: n})
+}
+
+func (r *RestAPI) trashInit() {
+	// HTML страница корзины с восстановлением заметок и ее очисткой
+	r.app.Get("/trash", r.requireAuth, func(ctx *fiber.Ctx) error {
+		notes, err := r.core.GetTrash(currentUser(ctx).Name)
+		if err != nil {
+			return err
+		}
+
+		return r.render(ctx, fiber.StatusOK, "trash", fiber.Map{
+			"Title": "Trash",
+			"Notes": notes,
+		})
+	}).Post("/trash/:id/restore", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Возвращаем заметку из корзины и открываем ее
+		id, err := noteIDParam(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err = r.core.RestoreNote(currentUser(ctx).Name, id); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/note/" + ctx.Params("id"))
+	}).Post("/trash/empty", r.requireAuth, r.csrfProtect, func(ctx *fiber.Ctx) error {
+		//Окончательно удаляем все заметки из корзины
+		if _, err := r.core.EmptyTrash(currentUser(ctx).Name); err != nil {
+			return err
+		}
+
+		return ctx.Redirect("/trash")
+	})
+}
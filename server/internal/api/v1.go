@@ -0,0 +1,815 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"my_notes_project/internal/core"
+	"my_notes_project/internal/entities"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiV1Prefix = "/api/v1"
+
+func (r *RestAPI) v1Routes() []apiRoute {
+	// Описание маршрутов JSON API, по нему же строится спецификация OpenAPI
+	return []apiRoute{
+		{
+			ID:       "listNotes",
+			Method:   fiber.MethodGet,
+			Path:     "/notes",
+			Summary:  "List notes of the current user page by page",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Params:   noteListParams,
+			Status:   fiber.StatusOK,
+			Response: NoteListResponse{},
+			Errors:   []int{fiber.StatusBadRequest},
+			Handler:  r.apiListNotes,
+		},
+		{
+			ID:       "createNote",
+			Method:   fiber.MethodPost,
+			Path:     "/notes",
+			Summary:  "Create a note",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Request:  NoteRequest{},
+			Status:   fiber.StatusCreated,
+			Response: NoteResponse{},
+			Errors:   []int{fiber.StatusBadRequest},
+			Handler:  r.apiCreateNote,
+		},
+		{
+			ID:       "getNote",
+			Method:   fiber.MethodGet,
+			Path:     "/notes/:id",
+			Summary:  "Get a note by id",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Params:   []apiParam{idParam},
+			Status:   fiber.StatusOK,
+			Response: NoteResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiGetNote,
+		},
+		{
+			ID:       "updateNote",
+			Method:   fiber.MethodPut,
+			Path:     "/notes/:id",
+			Summary:  "Replace title and content of a note, rejected if the note changed since the given version",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Params:   []apiParam{idParam, ifMatchParam},
+			Request:  NoteRequest{},
+			Status:   fiber.StatusOK,
+			Response: NoteResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound, fiber.StatusConflict, fiber.StatusPreconditionRequired},
+			Handler:  r.apiUpdateNote,
+		},
+		{
+			ID:      "deleteNote",
+			Method:  fiber.MethodDelete,
+			Path:    "/notes/:id",
+			Summary: "Move a note to the trash",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{idParam},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler: r.apiDeleteNote,
+		},
+		{
+			ID:       "addNoteTags",
+			Method:   fiber.MethodPost,
+			Path:     "/notes/:id/tags",
+			Summary:  "Add tags to a note",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Params:   []apiParam{idParam},
+			Request:  NoteTagsRequest{},
+			Status:   fiber.StatusOK,
+			Response: NoteResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiAddNoteTags,
+		},
+		{
+			ID:       "removeNoteTag",
+			Method:   fiber.MethodDelete,
+			Path:     "/notes/:id/tags/:tag",
+			Summary:  "Remove a tag from a note",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Params:   []apiParam{idParam, tagNameParam},
+			Status:   fiber.StatusOK,
+			Response: NoteResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiRemoveNoteTag,
+		},
+		{
+			ID:      "moveNote",
+			Method:  fiber.MethodPost,
+			Path:    "/notes/:id/move",
+			Summary: "Move a note to another notebook",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{idParam},
+			Request: NoteMoveRequest{},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler: r.apiMoveNote,
+		},
+		{
+			ID:       "listNoteRevisions",
+			Method:   fiber.MethodGet,
+			Path:     "/notes/:id/revisions",
+			Summary:  "List revisions of a note, newest first, without content",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Params:   []apiParam{idParam},
+			Status:   fiber.StatusOK,
+			Response: []RevisionResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiListRevisions,
+		},
+		{
+			ID:       "getNoteRevision",
+			Method:   fiber.MethodGet,
+			Path:     "/notes/:id/revisions/:rev",
+			Summary:  "Get a revision of a note with its content",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Params:   []apiParam{idParam, revisionParam},
+			Status:   fiber.StatusOK,
+			Response: RevisionResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiGetRevision,
+		},
+		{
+			ID:       "restoreNoteRevision",
+			Method:   fiber.MethodPost,
+			Path:     "/notes/:id/revisions/:rev/restore",
+			Summary:  "Restore title and content of a note from a revision, saved as a new revision",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Params:   []apiParam{idParam, revisionParam},
+			Status:   fiber.StatusOK,
+			Response: NoteResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiRestoreRevision,
+		},
+		{
+			ID:       "diffNoteRevisions",
+			Method:   fiber.MethodGet,
+			Path:     "/notes/:id/diff",
+			Summary:  "Compare the content of two revisions of a note line by line",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Params:   revisionDiffParams,
+			Status:   fiber.StatusOK,
+			Response: RevisionDiffResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiDiffRevisions,
+		},
+		{
+			ID:       "listTrash",
+			Method:   fiber.MethodGet,
+			Path:     "/trash",
+			Summary:  "List notes in the trash, most recently deleted first",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Status:   fiber.StatusOK,
+			Response: []NoteResponse{},
+			Handler:  r.apiListTrash,
+		},
+		{
+			ID:       "emptyTrash",
+			Method:   fiber.MethodDelete,
+			Path:     "/trash",
+			Summary:  "Permanently delete all notes in the trash",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Status:   fiber.StatusOK,
+			Response: TrashEmptyResponse{},
+			Handler:  r.apiEmptyTrash,
+		},
+		{
+			ID:      "restoreNote",
+			Method:  fiber.MethodPost,
+			Path:    "/trash/:id/restore",
+			Summary: "Restore a note from the trash",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{idParam},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler: r.apiRestoreNote,
+		},
+		{
+			ID:       "listNotebooks",
+			Method:   fiber.MethodGet,
+			Path:     "/notebooks",
+			Summary:  "List notebooks of the current user, parent_id links them into a tree",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Status:   fiber.StatusOK,
+			Response: []NotebookResponse{},
+			Handler:  r.apiListNotebooks,
+		},
+		{
+			ID:       "createNotebook",
+			Method:   fiber.MethodPost,
+			Path:     "/notebooks",
+			Summary:  "Create a notebook",
+			Auth:     true,
+			Scope:    core.ScopeNotesWrite,
+			Request:  NotebookRequest{},
+			Status:   fiber.StatusCreated,
+			Response: NotebookResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusConflict},
+			Handler:  r.apiCreateNotebook,
+		},
+		{
+			ID:       "getNotebook",
+			Method:   fiber.MethodGet,
+			Path:     "/notebooks/:id",
+			Summary:  "Get a notebook by id",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Params:   []apiParam{notebookIDParam},
+			Status:   fiber.StatusOK,
+			Response: NotebookResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler:  r.apiGetNotebook,
+		},
+		{
+			ID:      "renameNotebook",
+			Method:  fiber.MethodPut,
+			Path:    "/notebooks/:id",
+			Summary: "Rename a notebook",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{notebookIDParam},
+			Request: NotebookRenameRequest{},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound, fiber.StatusConflict},
+			Handler: r.apiRenameNotebook,
+		},
+		{
+			ID:      "moveNotebook",
+			Method:  fiber.MethodPost,
+			Path:    "/notebooks/:id/move",
+			Summary: "Move a notebook with its contents under another notebook or to the top level",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{notebookIDParam},
+			Request: NotebookMoveRequest{},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound, fiber.StatusConflict},
+			Handler: r.apiMoveNotebook,
+		},
+		{
+			ID:      "deleteNotebook",
+			Method:  fiber.MethodDelete,
+			Path:    "/notebooks/:id",
+			Summary: "Delete a notebook with nested notebooks, moving their notes to the trash",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{notebookIDParam},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusForbidden, fiber.StatusNotFound},
+			Handler: r.apiDeleteNotebook,
+		},
+		{
+			ID:       "listTags",
+			Method:   fiber.MethodGet,
+			Path:     "/tags",
+			Summary:  "List tags of the current user with note counts",
+			Auth:     true,
+			Scope:    core.ScopeNotesRead,
+			Status:   fiber.StatusOK,
+			Response: []TagResponse{},
+			Handler:  r.apiListTags,
+		},
+		{
+			ID:      "renameTag",
+			Method:  fiber.MethodPut,
+			Path:    "/tags/:tag",
+			Summary: "Rename a tag on all notes",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{tagNameParam},
+			Request: TagRenameRequest{},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusNotFound, fiber.StatusConflict},
+			Handler: r.apiRenameTag,
+		},
+		{
+			ID:      "mergeTag",
+			Method:  fiber.MethodPost,
+			Path:    "/tags/:tag/merge",
+			Summary: "Replace a tag with another one on all notes",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{tagNameParam},
+			Request: TagMergeRequest{},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+			Handler: r.apiMergeTag,
+		},
+		{
+			ID:      "deleteTag",
+			Method:  fiber.MethodDelete,
+			Path:    "/tags/:tag",
+			Summary: "Remove a tag from all notes",
+			Auth:    true,
+			Scope:   core.ScopeNotesWrite,
+			Params:  []apiParam{tagNameParam},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusNotFound},
+			Handler: r.apiDeleteTag,
+		},
+		{
+			ID:       "registerUser",
+			Method:   fiber.MethodPost,
+			Path:     "/users",
+			Summary:  "Register a user",
+			Request:  UserRequest{},
+			Status:   fiber.StatusCreated,
+			Response: UserResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusConflict},
+			Handler:  r.apiRegister,
+		},
+		{
+			ID:       "getSession",
+			Method:   fiber.MethodGet,
+			Path:     "/session",
+			Summary:  "Get the current session",
+			Auth:     true,
+			Status:   fiber.StatusOK,
+			Response: SessionResponse{},
+			Handler:  r.apiGetSession,
+		},
+		{
+			ID:       "createSession",
+			Method:   fiber.MethodPost,
+			Path:     "/session",
+			Summary:  "Log in and start a session, mfa_required means a second step is needed",
+			Request:  SessionRequest{},
+			Status:   fiber.StatusCreated,
+			Response: SessionResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusUnauthorized, fiber.StatusTooManyRequests},
+			Handler:  r.apiLogin,
+		},
+		{
+			ID:       "completeMFA",
+			Method:   fiber.MethodPost,
+			Path:     "/session/mfa",
+			Summary:  "Finish logging in with a TOTP or recovery code",
+			Request:  MFARequest{},
+			Status:   fiber.StatusCreated,
+			Response: SessionResponse{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusUnauthorized, fiber.StatusTooManyRequests},
+			Handler:  r.apiCompleteMFA,
+		},
+		{
+			ID:      "deleteSession",
+			Method:  fiber.MethodDelete,
+			Path:    "/session",
+			Summary: "Log out and revoke the current session",
+			Status:  fiber.StatusNoContent,
+			Handler: r.apiLogout,
+		},
+		{
+			ID:          "changePassword",
+			Method:      fiber.MethodPut,
+			Path:        "/user/password",
+			Summary:     "Change the password and revoke other sessions",
+			Auth:        true,
+			SessionOnly: true,
+			Request:     PasswordChangeRequest{},
+			Status:      fiber.StatusNoContent,
+			Errors:      []int{fiber.StatusBadRequest, fiber.StatusTooManyRequests},
+			Handler:     r.apiChangePassword,
+		},
+		{
+			ID:          "deleteAccount",
+			Method:      fiber.MethodDelete,
+			Path:        "/user",
+			Summary:     "Delete the account with all its notes",
+			Auth:        true,
+			SessionOnly: true,
+			Request:     AccountDeleteRequest{},
+			Status:      fiber.StatusNoContent,
+			Errors:      []int{fiber.StatusBadRequest, fiber.StatusTooManyRequests},
+			Handler:     r.apiDeleteAccount,
+		},
+		{
+			ID:          "updateEmail",
+			Method:      fiber.MethodPut,
+			Path:        "/user/email",
+			Summary:     "Set or clear the email used for password reset, requires the current password",
+			Auth:        true,
+			SessionOnly: true,
+			Request:     EmailRequest{},
+			Status:      fiber.StatusOK,
+			Response:    UserResponse{},
+			Errors:      []int{fiber.StatusBadRequest, fiber.StatusConflict, fiber.StatusTooManyRequests},
+			Handler:     r.apiUpdateEmail,
+		},
+		{
+			ID:      "requestPasswordReset",
+			Method:  fiber.MethodPost,
+			Path:    "/password-reset",
+			Summary: "Send a password reset link if the email belongs to an account",
+			Request: PasswordResetRequest{},
+			Status:  fiber.StatusAccepted,
+			Errors:  []int{fiber.StatusBadRequest, fiber.StatusTooManyRequests},
+			Handler: r.apiRequestPasswordReset,
+		},
+		{
+			ID:      "confirmPasswordReset",
+			Method:  fiber.MethodPost,
+			Path:    "/password-reset/confirm",
+			Summary: "Set a new password with a token from the reset email",
+			Request: PasswordResetConfirmRequest{},
+			Status:  fiber.StatusNoContent,
+			Errors:  []int{fiber.StatusBadRequest},
+			Handler: r.apiConfirmPasswordReset,
+		},
+		{
+			ID:          "listTokens",
+			Method:      fiber.MethodGet,
+			Path:        "/tokens",
+			Summary:     "List personal access tokens",
+			Auth:        true,
+			SessionOnly: true,
+			Status:      fiber.StatusOK,
+			Response:    []TokenResponse{},
+			Handler:     r.apiListTokens,
+		},
+		{
+			ID:          "createToken",
+			Method:      fiber.MethodPost,
+			Path:        "/tokens",
+			Summary:     "Create a personal access token",
+			Auth:        true,
+			SessionOnly: true,
+			Request:     TokenRequest{},
+			Status:      fiber.StatusCreated,
+			Response:    CreatedTokenResponse{},
+			Errors:      []int{fiber.StatusBadRequest},
+			Handler:     r.apiCreateToken,
+		},
+		{
+			ID:          "revokeToken",
+			Method:      fiber.MethodDelete,
+			Path:        "/tokens/:id",
+			Summary:     "Revoke a personal access token",
+			Auth:        true,
+			SessionOnly: true,
+			Params:      []apiParam{tokenIDParam},
+			Status:      fiber.StatusNoContent,
+			Errors:      []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+			Handler:     r.apiRevokeToken,
+		},
+	}
+}
+
+func (r *RestAPI) v1Init() error {
+	// JSON API для скриптов, работает через тот же ServiceCore, что и HTML страницы
+	routes := r.v1Routes()
+
+	v1 := r.app.Group(apiV1Prefix, r.bearerMiddleware, r.apiCSRFProtect)
+	for _, route := range routes {
+		handlers := []fiber.Handler{}
+		if route.Auth {
+			handlers = append(handlers, r.apiRequireAuth)
+		}
+		if route.SessionOnly {
+			handlers = append(handlers, r.apiRequireSession)
+		}
+		if route.Scope != "" {
+			handlers = append(handlers, r.apiRequireScope(route.Scope))
+		}
+
+		v1.Add(route.Method, route.Path, append(handlers, route.Handler)...)
+	}
+
+	// Спецификацию строим один раз при старте
+	spec, err := json.Marshal(buildOpenAPI(apiV1Prefix, routes))
+	if err != nil {
+		return err
+	}
+
+	r.app.Get("/api/openapi.json", func(ctx *fiber.Ctx) error {
+		ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		return ctx.Send(spec)
+	})
+
+	return nil
+}
+
+func (r *RestAPI) apiListNotes(ctx *fiber.Ctx) error {
+	// С параметром q ищем по тексту заметок, иначе возвращаем страницу и курсор следующей
+	if query := ctx.Query("q"); query != "" {
+		matches, err := r.core.SearchNotes(currentUser(ctx).Name, query)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(newSearchResponse(matches))
+	}
+
+	q, err := noteQueryParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	page, err := r.core.ListNotes(currentUser(ctx).Name, q)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(newNoteListResponse(page))
+}
+
+func (r *RestAPI) apiCreateNote(ctx *fiber.Ctx) error {
+	// Создаем заметку и возвращаем ее вместе с адресом
+	var req NoteRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	note := &entities.Note{
+		Title:      req.Title,
+		Content:    req.Content,
+		Tags:       req.Tags,
+		NotebookID: req.NotebookID,
+	}
+
+	if err := r.core.AddNoteToUserByName(currentUser(ctx).Name, note); err != nil {
+		return err
+	}
+
+	ctx.Location(fmt.Sprintf("/api/v1/notes/%d", note.ID))
+	ctx.Set(fiber.HeaderETag, noteETag(note))
+	return ctx.Status(fiber.StatusCreated).JSON(newNoteResponse(note))
+}
+
+func (r *RestAPI) apiGetNote(ctx *fiber.Ctx) error {
+	// Заметку получает только ее автор
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	note, err := r.core.GetNoteByID(currentUser(ctx).Name, id)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderETag, noteETag(note))
+	return ctx.JSON(newNoteResponse(note))
+}
+
+func (r *RestAPI) apiUpdateNote(ctx *fiber.Ctx) error {
+	// Полностью заменяем заголовок и содержимое заметки, метки только если они переданы.
+	// Без If-Match изменение не принимаем, устаревшая версия отклоняется с кодом 409
+	// и текущей версией в ответе
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req NoteRequest
+	if err = parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	note := &entities.Note{
+		ID:         id,
+		Title:      req.Title,
+		Content:    req.Content,
+		Tags:       req.Tags,
+		NotebookID: req.NotebookID,
+		Version:    version,
+	}
+
+	if err = r.core.UpdateNoteByUserName(currentUser(ctx).Name, note); err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderETag, noteETag(note))
+	return ctx.JSON(newNoteResponse(note))
+}
+
+func (r *RestAPI) apiDeleteNote(ctx *fiber.Ctx) error {
+	// Переносим заметку в корзину, если она принадлежит пользователю
+	id, err := noteIDParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = r.core.RemoveNoteByUserName(currentUser(ctx).Name, id); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiRegister(ctx *fiber.Ctx) error {
+	// Регистрируем пользователя, повтор пароля можно не передавать
+	var req UserRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	if req.PasswordRepeat == "" {
+		req.PasswordRepeat = req.Password
+	}
+
+	if err := r.core.RegisterUser(req.Username, req.Password, req.PasswordRepeat); err != nil {
+		return err
+	}
+
+	user, err := r.core.GetUserByName(req.Username)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(newUserResponse(user))
+}
+
+func (r *RestAPI) apiGetSession(ctx *fiber.Ctx) error {
+	// Возвращаем пользователя текущей сессии
+	return ctx.JSON(SessionResponse{
+		User: newUserResponse(currentUser(ctx)),
+	})
+}
+
+func (r *RestAPI) apiLogin(ctx *fiber.Ctx) error {
+	// Проверяем учетные данные и создаем сессию
+	var req SessionRequest
+	if err := parseBody(ctx, &req); err != nil {
+		return err
+	}
+
+	user, err := r.core.Authenticate(req.Username, req.Password, ctx.IP())
+	if err != nil {
+		return err
+	}
+
+	token, session, err := r.core.CreateSession(user.Name)
+	if err != nil {
+		return err
+	}
+
+	// Со вторым фактором выдаем только промежуточную сессию
+	if session.MFAPending {
+		r.setCookie(ctx, mfaCookie, token, session.ExpiresAt)
+	} else {
+		r.setSessionCookie(ctx, token, session.ExpiresAt)
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(SessionResponse{
+		User:        newUserResponse(user),
+		ExpiresAt:   &session.ExpiresAt,
+		MFARequired: session.MFAPending,
+	})
+}
+
+func (r *RestAPI) apiLogout(ctx *fiber.Ctx) error {
+	// Завершаем текущую сессию
+	if token := ctx.Cookies(sessionCookie); token != "" {
+		if err := r.core.RevokeSession(token); err != nil {
+			return err
+		}
+	}
+
+	r.clearSessionCookie(ctx)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *RestAPI) apiRequireAuth(ctx *fiber.Ctx) error {
+	// Для API ошибку аутентификации отдаем в JSON
+	if currentUser(ctx) == nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "not authed")
+	}
+
+	return ctx.Next()
+}
+
+func parseBody(ctx *fiber.Ctx, req interface{}) error {
+	// Разбираем JSON тело запроса и проверяем обязательные поля.
+	// Формы не принимаем: их может отправить сторонний сайт
+	if !ctx.Is("json") {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "request body must be application/json")
+	}
+
+	if err := ctx.BodyParser(req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+	}
+
+	if v, ok := req.(interface{ validate() error }); ok {
+		return v.validate()
+	}
+
+	return nil
+}
+
+func noteIDParam(ctx *fiber.Ctx) (uint64, error) {
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid note id")
+	}
+
+	return id, nil
+}
+
+func noteETag(note *entities.Note) string {
+	// ETag заметки это ее версия
+	return `"` + strconv.Itoa(note.Version) + `"`
+}
+
+func ifMatchVersion(ctx *fiber.Ctx) (int, error) {
+	// Версия из заголовка If-Match в том виде, в каком ее отдал ETag. Без версии
+	// клиенты затирали бы изменения друг друга, поэтому * тоже не принимаем
+	header := strings.TrimSpace(ctx.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return 0, fiber.NewError(fiber.StatusPreconditionRequired, "If-Match header with the note ETag is required")
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if err != nil || version < 1 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid If-Match header")
+	}
+
+	return version, nil
+}
+
+func noteQueryParams(ctx *fiber.Ctx) (entities.NoteQuery, error) {
+	// Параметры списка заметок из строки запроса, общие для API и HTML страниц
+	q := entities.NoteQuery{
+		Sort:  entities.NoteSort(ctx.Query("sort")),
+		Title: ctx.Query("title"),
+	}
+
+	if tags := ctx.Query("tags"); tags != "" {
+		q.Tags = splitTags(tags)
+	}
+
+	verr := &core.ValidationError{}
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			verr.Add("limit", "must be a number")
+		}
+
+		q.Limit = n
+	}
+
+	if notebook := ctx.Query("notebook_id"); notebook != "" {
+		id, err := strconv.ParseUint(notebook, 10, 64)
+		if err != nil {
+			verr.Add("notebook_id", "must be a number")
+		}
+
+		q.NotebookID = id
+	}
+
+	switch ctx.Query("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		verr.Add("order", "must be asc or desc")
+	}
+
+	switch ctx.Query("tag_mode") {
+	case "", "all":
+	case "any":
+		q.AnyTag = true
+	default:
+		verr.Add("tag_mode", "must be all or any")
+	}
+
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		after, err := entities.ParseNoteCursor(cursor)
+		if err != nil {
+			verr.Add("cursor", "is invalid")
+		}
+
+		q.After = after
+	}
+
+	return q, verr.OrNil()
+}
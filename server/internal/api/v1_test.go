@@ -0,0 +1,278 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"my_notes_project/internal/core"
+	"my_notes_project/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Клиент JSON API поверх app.Test, запоминает куки как браузер
+type apiClient struct {
+	t       *testing.T
+	app     *fiber.App
+	cookies map[string]string
+}
+
+func newAPITest(t *testing.T) *RestAPI {
+	// API с настоящим ядром и базой во временном каталоге
+	db, err := database.NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	t.Cleanup(func() { db.CloseSQLiteDatabase() })
+
+	r := NewRestAPI(core.NewTheCore(db, logrus.New()), logrus.New(), WithSecureCookies(false))
+	require.Nil(t, r.HandlersInit())
+
+	return r
+}
+
+func newAPIClient(t *testing.T, r *RestAPI) *apiClient {
+	return &apiClient{t: t, app: r.app, cookies: map[string]string{csrfCookie: "csrf-token"}}
+}
+
+func (c *apiClient) do(method, path string, body interface{}, headers map[string]string) (*http.Response, []byte) {
+	// Тело передаем в JSON, CSRF токен в заголовке совпадает с куки
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.Nil(c.t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	req.Header.Set(csrfHeader, c.cookies[csrfCookie])
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for name, value := range c.cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+
+	resp, err := c.app.Test(req)
+	require.Nil(c.t, err)
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Value == "" || cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie.Value
+		}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	require.Nil(c.t, err)
+
+	return resp, data
+}
+
+func (c *apiClient) login(username, password string) {
+	// Регистрируемся и входим, сессия остается в куки клиента
+	resp, _ := c.do(fiber.MethodPost, "/api/v1/users", UserRequest{Username: username, Password: password}, nil)
+	require.Equal(c.t, fiber.StatusCreated, resp.StatusCode)
+
+	resp, _ = c.do(fiber.MethodPost, "/api/v1/session", SessionRequest{Username: username, Password: password}, nil)
+	require.Equal(c.t, fiber.StatusCreated, resp.StatusCode)
+}
+
+func decode[T any](t *testing.T, data []byte) T {
+	var v T
+	require.Nil(t, json.Unmarshal(data, &v), string(data))
+	return v
+}
+
+func assertAPIError(t *testing.T, resp *http.Response, data []byte, status int) ErrorResponse {
+	// Все ошибки API приходят в одном формате с кодом внутри
+	assert.Equal(t, status, resp.StatusCode, string(data))
+	assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get(fiber.HeaderContentType))
+
+	body := decode[ErrorResponse](t, data)
+	assert.Equal(t, status, body.Error.Status)
+	assert.NotEmpty(t, body.Error.Message)
+
+	return body
+}
+
+func TestAPIUsers(t *testing.T) {
+	c := newAPIClient(t, newAPITest(t))
+
+	resp, data := c.do(fiber.MethodPost, "/api/v1/users", UserRequest{Username: "Ivan", Password: "Ocean-breeze-7"}, nil)
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode, string(data))
+	user := decode[UserResponse](t, data)
+	assert.NotZero(t, user.ID)
+	assert.Equal(t, "Ivan", user.Username)
+
+	// Наружу отдается DTO, а не сущность с хешем пароля
+	raw := decode[map[string]interface{}](t, data)
+	assert.ElementsMatch(t, []string{"id", "username"}, keys(raw))
+
+	resp, data = c.do(fiber.MethodPost, "/api/v1/users", UserRequest{Username: "ivan", Password: "Ocean-breeze-7"}, nil)
+	assertAPIError(t, resp, data, fiber.StatusConflict)
+
+	// Ошибки проверки перечисляют поля
+	resp, data = c.do(fiber.MethodPost, "/api/v1/users", UserRequest{Username: "", Password: ""}, nil)
+	body := assertAPIError(t, resp, data, fiber.StatusBadRequest)
+	fields := []string{}
+	for _, f := range body.Error.Fields {
+		fields = append(fields, f.Field)
+	}
+	assert.ElementsMatch(t, []string{"username", "password"}, fields)
+
+	resp, data = c.do(fiber.MethodPost, "/api/v1/users", UserRequest{Username: "Igor", Password: "short", PasswordRepeat: "other"}, nil)
+	assertAPIError(t, resp, data, fiber.StatusBadRequest)
+}
+
+func TestAPISession(t *testing.T) {
+	c := newAPIClient(t, newAPITest(t))
+
+	resp, data := c.do(fiber.MethodGet, "/api/v1/session", nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusUnauthorized)
+
+	resp, _ = c.do(fiber.MethodPost, "/api/v1/users", UserRequest{Username: "Ivan", Password: "Ocean-breeze-7"}, nil)
+	require.Equal(t, fiber.StatusCreated, resp.StatusCode)
+
+	resp, data = c.do(fiber.MethodPost, "/api/v1/session", SessionRequest{Username: "Ivan", Password: "wrong"}, nil)
+	assertAPIError(t, resp, data, fiber.StatusUnauthorized)
+	assert.NotContains(t, c.cookies, sessionCookie)
+
+	resp, data = c.do(fiber.MethodPost, "/api/v1/session", SessionRequest{Username: "ivan", Password: "Ocean-breeze-7"}, nil)
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode, string(data))
+	session := decode[SessionResponse](t, data)
+	assert.Equal(t, "Ivan", session.User.Username)
+	assert.NotNil(t, session.ExpiresAt)
+	assert.False(t, session.MFARequired)
+	assert.Contains(t, c.cookies, sessionCookie)
+
+	resp, data = c.do(fiber.MethodGet, "/api/v1/session", nil, nil)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, string(data))
+	assert.Equal(t, "Ivan", decode[SessionResponse](t, data).User.Username)
+
+	// Выход с сессией требует CSRF токен
+	resp, data = c.do(fiber.MethodDelete, "/api/v1/session", nil, map[string]string{csrfHeader: "other"})
+	assertAPIError(t, resp, data, fiber.StatusForbidden)
+
+	resp, _ = c.do(fiber.MethodDelete, "/api/v1/session", nil, nil)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.NotContains(t, c.cookies, sessionCookie)
+
+	resp, data = c.do(fiber.MethodGet, "/api/v1/session", nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusUnauthorized)
+}
+
+func TestAPINotes(t *testing.T) {
+	r := newAPITest(t)
+	c := newAPIClient(t, r)
+	c.login("Ivan", "Ocean-breeze-7")
+
+	resp, data := c.do(fiber.MethodPost, "/api/v1/notes", nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusUnsupportedMediaType)
+
+	resp, data = c.do(fiber.MethodPost, "/api/v1/notes", NoteRequest{Title: "", Content: "text"}, nil)
+	assertAPIError(t, resp, data, fiber.StatusBadRequest)
+
+	resp, data = c.do(fiber.MethodPost, "/api/v1/notes", NoteRequest{Title: "Plan", Content: "text", Tags: []string{"work"}}, nil)
+	require.Equal(t, fiber.StatusCreated, resp.StatusCode, string(data))
+	note := decode[NoteResponse](t, data)
+	assert.Equal(t, "Plan", note.Title)
+	assert.Equal(t, []string{"work"}, note.Tags)
+	assert.Equal(t, 1, note.Version)
+	assert.NotZero(t, note.NotebookID)
+	assert.Equal(t, `"1"`, resp.Header.Get(fiber.HeaderETag))
+
+	location := resp.Header.Get(fiber.HeaderLocation)
+	assert.Equal(t, "/api/v1/notes/"+itoa(note.ID), location)
+
+	// Сущность заметки с id пользователя наружу не попадает
+	raw := decode[map[string]interface{}](t, data)
+	assert.NotContains(t, raw, "user_id")
+	assert.NotContains(t, raw, "UserID")
+
+	resp, data = c.do(fiber.MethodGet, location, nil, nil)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, string(data))
+	assert.Equal(t, note, decode[NoteResponse](t, data))
+	assert.Equal(t, `"1"`, resp.Header.Get(fiber.HeaderETag))
+
+	resp, data = c.do(fiber.MethodGet, "/api/v1/notes/999", nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusNotFound)
+	resp, data = c.do(fiber.MethodGet, "/api/v1/notes/abc", nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusBadRequest)
+
+	resp, data = c.do(fiber.MethodGet, "/api/v1/notes", nil, nil)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, string(data))
+	list := decode[NoteListResponse](t, data)
+	require.Equal(t, 1, len(list.Notes))
+	assert.Equal(t, note.ID, list.Notes[0].ID)
+	assert.Empty(t, list.NextCursor)
+
+	resp, data = c.do(fiber.MethodPut, location, NoteRequest{Title: "Plan", Content: "new"}, map[string]string{fiber.HeaderIfMatch: `"1"`})
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode, string(data))
+	updated := decode[NoteResponse](t, data)
+	assert.Equal(t, "new", updated.Content)
+	assert.Equal(t, 2, updated.Version)
+	assert.Equal(t, []string{"work"}, updated.Tags)
+	assert.Equal(t, `"2"`, resp.Header.Get(fiber.HeaderETag))
+
+	// Без If-Match изменение не принимается, устаревшая версия отклоняется вместе с текущей
+	for _, header := range []map[string]string{nil, {fiber.HeaderIfMatch: "*"}} {
+		resp, data = c.do(fiber.MethodPut, location, NoteRequest{Title: "Plan", Content: "blind"}, header)
+		assertAPIError(t, resp, data, fiber.StatusPreconditionRequired)
+	}
+
+	resp, data = c.do(fiber.MethodPut, location, NoteRequest{Title: "Plan", Content: "stale"}, map[string]string{fiber.HeaderIfMatch: `"1"`})
+	body := assertAPIError(t, resp, data, fiber.StatusConflict)
+	assert.Equal(t, `"2"`, resp.Header.Get(fiber.HeaderETag))
+	if assert.NotNil(t, body.Error.Current) {
+		assert.Equal(t, updated, *body.Error.Current)
+	}
+
+	resp, data = c.do(fiber.MethodGet, location, nil, nil)
+	assert.Equal(t, "new", decode[NoteResponse](t, data).Content)
+
+	resp, data = c.do(fiber.MethodPut, "/api/v1/notes/999", NoteRequest{Title: "Plan", Content: "new"}, map[string]string{fiber.HeaderIfMatch: `"1"`})
+	assertAPIError(t, resp, data, fiber.StatusNotFound)
+
+	// Чужую заметку нельзя ни прочитать, ни изменить, ни удалить
+	other := newAPIClient(t, r)
+	other.login("Igor", "Mountain-air-9")
+	resp, data = other.do(fiber.MethodGet, location, nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusForbidden)
+	resp, data = other.do(fiber.MethodPut, location, NoteRequest{Title: "Mine", Content: "x"}, map[string]string{fiber.HeaderIfMatch: `"2"`})
+	assertAPIError(t, resp, data, fiber.StatusForbidden)
+	resp, data = other.do(fiber.MethodDelete, location, nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusForbidden)
+
+	resp, data = c.do(fiber.MethodDelete, location, nil, nil)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode, string(data))
+	assert.Empty(t, data)
+
+	resp, data = c.do(fiber.MethodGet, location, nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusNotFound)
+	resp, data = c.do(fiber.MethodDelete, location, nil, nil)
+	assertAPIError(t, resp, data, fiber.StatusNotFound)
+}
+
+func keys(m map[string]interface{}) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+
+	return result
+}
+
+func itoa(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
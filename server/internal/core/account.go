@@ -0,0 +1,98 @@
+package core
+
+import (
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/password"
+	"strings"
+)
+
+func (c TheCore) ChangePassword(username, oldPass, newPass, repeatedPassword, currentSession string) error {
+	// Меняем пароль только после проверки старого
+	user, err := c.reauthenticate(username, oldPass, "old_password")
+	if err != nil {
+		return err
+	}
+
+	//Новый пароль проверяем по тем же правилам, что и при регистрации
+	if err = c.validateNewPassword(user.Name, newPass, repeatedPassword); err != nil {
+		return err
+	}
+
+	hash, err := password.Hash(newPass, c.passwordParams)
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	user.Password = hash
+	if err = c.db.UpdateUser(user); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	// Сессии на других устройствах могли быть открыты со старым паролем
+	keep := ""
+	if currentSession != "" {
+		keep = hashToken(currentSession)
+	}
+
+	if err = c.db.RemoveSessionsByUserIDExcept(user.ID, keep); err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	c.logger.Infof("password of user %d changed, other sessions revoked", user.ID)
+	return nil
+}
+
+func (c TheCore) DeleteAccount(username, pass string) error {
+	// Удаление необратимо, поэтому еще раз спрашиваем пароль
+	user, err := c.reauthenticate(username, pass, "password")
+	if err != nil {
+		return err
+	}
+
+	// Заметки, сессии и токены удаляются вместе с пользователем
+	if err = c.db.RemoveUserByID(user.ID); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	if err = c.accountLimiter.Reset(strings.ToLower(user.Name)); err != nil {
+		c.logger.Error(err)
+	}
+
+	c.logger.Infof("user %d deleted their account", user.ID)
+	return nil
+}
+
+func (c TheCore) reauthenticate(username, pass, field string) (*entities.User, error) {
+	// Повторная проверка пароля подчиняется той же блокировке, что и вход,
+	// иначе угнанной сессией можно было бы подбирать пароль
+	account := strings.ToLower(username)
+	wait, err := c.accountLimiter.Reserve(account)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	} else if wait > 0 {
+		return nil, &RateLimitError{RetryAfter: wait}
+	}
+
+	isValid, err := c.IsValidUserCredentials(username, pass)
+	if err != nil {
+		c.accountLimiter.Release(account)
+		return nil, err
+	}
+
+	if !isValid {
+		if err = c.accountLimiter.Fail(account); err != nil {
+			c.logger.Error(err)
+		}
+
+		return nil, NewValidationError(field, "is incorrect")
+	}
+
+	c.accountLimiter.Release(account)
+
+	return c.GetUserByName(username)
+}
@@ -1,92 +1,351 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"my_notes_project/internal/database"
 	"my_notes_project/internal/entities"
+	"my_notes_project/internal/mail"
+	"my_notes_project/internal/password"
+	"my_notes_project/internal/ratelimit"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 type ServiceCore interface {
 	GetAllNotes() (map[uint64]*entities.Note, error)
-	RemoveNoteByID(uint64) error
+	RemoveNoteByUserName(string, uint64) error
 	UpdateNoteByUserName(string, *entities.Note) error
 	GetNotesByUserName(string) (map[uint64]*entities.Note, error)
+	GetNoteByID(string, uint64) (*entities.Note, error)
+	ListNotes(string, entities.NoteQuery) (*entities.NotePage, error)
+	SearchNotes(string, string) ([]*entities.NoteMatch, error)
+	GetTags(string) ([]*entities.Tag, error)
+	AddNoteTags(string, uint64, []string) (*entities.Note, error)
+	RemoveNoteTags(string, uint64, []string) (*entities.Note, error)
+	RenameTag(string, string, string) error
+	MergeTags(string, string, string) error
+	DeleteTag(string, string) error
+	GetNotebooks(string) ([]*entities.Notebook, error)
+	GetNotebookTree(string) ([]*entities.NotebookNode, error)
+	GetNotebook(string, uint64) (*entities.Notebook, error)
+	CreateNotebook(string, string, *uint64) (*entities.Notebook, error)
+	RenameNotebook(string, uint64, string) error
+	MoveNotebook(string, uint64, *uint64) error
+	DeleteNotebook(string, uint64) error
+	MoveNote(string, uint64, uint64) error
+	GetNoteRevisions(string, uint64) ([]*entities.Revision, error)
+	GetNoteRevision(string, uint64, int) (*entities.Revision, error)
+	DiffNoteRevisions(string, uint64, int, int) (*RevisionDiff, error)
+	RestoreNoteRevision(string, uint64, int) (*entities.Note, error)
+	GetTrash(string) ([]*entities.Note, error)
+	RestoreNote(string, uint64) error
+	EmptyTrash(string) (int, error)
 	RegisterUser(string, string, string) error
+	GetUserByName(string) (*entities.User, error)
 	IsValidUserCredentials(string, string) (bool, error)
+	Authenticate(string, string, string) (*entities.User, error)
+	ChangePassword(string, string, string, string, string) error
+	DeleteAccount(string, string) error
+	UpdateEmail(string, string, string) error
+	RequestPasswordReset(string) error
+	ResetPassword(string, string, string) error
+	BeginTOTPEnrollment(string) (string, string, error)
+	ConfirmTOTPEnrollment(string, string) ([]string, error)
+	DisableTOTP(string, string) error
+	GetTOTPStatus(string) (bool, int, error)
+	CompleteMFA(string, string) (string, *entities.Session, error)
 	AddNoteToUserByName(string, *entities.Note) error
+	CreateSession(string) (string, *entities.Session, error)
+	GetUserBySession(string) (*entities.User, error)
+	RevokeSession(string) error
+	RevokeAllSessions(string) error
+	CreateAPIToken(string, string, []string, *time.Time) (string, *entities.APIToken, error)
+	GetAPITokens(string) ([]*entities.APIToken, error)
+	RevokeAPIToken(string, uint64) error
+	GetUserByAPIToken(string) (*entities.User, *entities.APIToken, error)
 }
 
 type TheCore struct {
-	db     database.DBRepository
-	logger *logrus.Logger
+	db             database.DBRepository
+	logger         *logrus.Logger
+	passwordParams password.Params
+	sessionTTL     time.Duration
+	usernamePolicy UsernamePolicy
+	passwordPolicy PasswordPolicy
+	accountPolicy  ratelimit.Policy
+	ipPolicy       ratelimit.Policy
+	accountLimiter *ratelimit.Limiter
+	ipLimiter      *ratelimit.Limiter
+	resetPolicy    ratelimit.Policy
+	resetLimiter   *ratelimit.Limiter
+	mailer         mail.Mailer
+	publicURL      string
+	trashRetention time.Duration
+	// Источник текущего времени, в тестах подменяется
+	now func() time.Time
 }
 
-func NewTheCore(db database.DBRepository, logger *logrus.Logger) *TheCore {
-	return &TheCore{
-		db:     db,
-		logger: logger,
+// Дополнительная настройка ядра
+type Option func(*TheCore)
+
+// Параметры хеширования паролей
+func WithPasswordParams(p password.Params) Option {
+	return func(c *TheCore) {
+		c.passwordParams = p
+	}
+}
+
+// Источник текущего времени для отметок создания и изменения, сроков сессий и токенов
+func WithClock(now func() time.Time) Option {
+	return func(c *TheCore) {
+		c.now = now
+	}
+}
+
+func NewTheCore(db database.DBRepository, logger *logrus.Logger, opts ...Option) *TheCore {
+	c := &TheCore{
+		db:             db,
+		logger:         logger,
+		passwordParams: password.DefaultParams,
+		sessionTTL:     DefaultSessionTTL,
+		usernamePolicy: DefaultUsernamePolicy,
+		passwordPolicy: DefaultPasswordPolicy,
+		accountPolicy:  ratelimit.DefaultAccountPolicy,
+		ipPolicy:       ratelimit.DefaultIPPolicy,
+		resetPolicy:    DefaultResetPolicy,
+		mailer:         mail.NewLogMailer(logger),
+		publicURL:      "http://localhost:8080",
+		trashRetention: DefaultTrashRetention,
+		now:            time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	// Счетчики неудачных входов хранятся в базе данных и переживают перезапуск
+	c.accountLimiter = ratelimit.New(db, logger, "user:", c.accountPolicy, ratelimit.WithClock(c.now))
+	c.ipLimiter = ratelimit.New(db, logger, "ip:", c.ipPolicy, ratelimit.WithClock(c.now))
+	c.resetLimiter = ratelimit.New(db, logger, "reset:", c.resetPolicy, ratelimit.WithClock(c.now))
+
+	return c
 }
 
 // Возвращает все заметки
 func (c TheCore) GetAllNotes() (map[uint64]*entities.Note, error) {
 	//Обращаемся в базу данных и возвращаем все заметки
-	return c.db.GetAllNotes()
+	notes, err := c.db.GetAllNotes()
+	return notes, convertError(err)
 }
 
-func (c TheCore) RemoveNoteByID(id uint64) error {
-	//Обращаемся в базу данных и удаляем заметку по id
-	return c.db.RemoveNoteByID(id)
+func (c TheCore) RemoveNoteByUserName(username string, id uint64) error {
+	//Удалять заметку может только ее автор
+	if _, err := c.GetNoteByID(username, id); err != nil {
+		return err
+	}
+
+	//Переносим заметку в корзину, окончательно ее удалит очистка корзины
+	return convertError(c.db.TrashNote(id, c.now()))
 }
 
 func (c TheCore) UpdateNoteByUserName(username string, note *entities.Note) error {
 	// проверка на пустые Title и Content
-	if strings.TrimSpace(note.Title) == "" || strings.TrimSpace(note.Content) == "" {
-		c.logger.Error("empty title or content")
-		return fmt.Errorf("empty title or content")
+	if err := validateNote(note); err != nil {
+		c.logger.Error(err)
+		return err
 	}
 
-	// Получаем заметки по имени пользователя
-	notes, err := c.db.GetNotesByUserName(username)
+	if note.Version < 0 {
+		return NewValidationError("version", "must not be negative")
+	}
+
+	// Проверяем, что заметка существует и принадлежит пользователю
+	existing, err := c.GetNoteByID(username, note.ID)
 	if err != nil {
-		c.logger.Error(err)
 		return err
 	}
 
-	// Проверяем на существование заметку по id
-	_, exists := notes[note.ID]
-	if !exists {
-		c.logger.Error("not found")
-		return fmt.Errorf("not found")
+	// Изменение устаревшей версии отклоняем, иначе оно затрет более новые правки.
+	// Без версии обновляет только само ядро, например при восстановлении правки
+	if note.Version != 0 && note.Version != existing.Version {
+		return &VersionConflictError{Current: existing, Version: note.Version}
 	}
 
-	// Обновляем заметку, если она существует
-	return c.db.UpdateNote(note)
+	// Без списка меток оставляем те, что уже стоят на заметке
+	if note.Tags == nil {
+		note.Tags = existing.Tags
+	}
+
+	// Без блокнота заметка остается в прежнем
+	if note.NotebookID == 0 {
+		note.NotebookID = existing.NotebookID
+	} else if note.NotebookID, err = c.noteNotebook(existing.UserID, note.NotebookID); err != nil {
+		return err
+	}
+
+	note.UserID = existing.UserID
+	note.CreatedAt = existing.CreatedAt
+	note.UpdatedAt = c.now()
+
+	// Обновляем заметку, если она существует и ее не успели изменить после проверки
+	err = c.db.UpdateNote(note)
+	if errors.Is(err, database.ErrVersionMismatch) {
+		current, gerr := c.db.GetNoteByID(note.ID)
+		if gerr != nil {
+			c.logger.Error(gerr)
+			return convertError(gerr)
+		}
+
+		return &VersionConflictError{Current: current, Version: note.Version}
+	}
+
+	return convertError(err)
 }
 
 func (c TheCore) GetNotesByUserName(username string) (map[uint64]*entities.Note, error) {
 	// Обращаемся в базу данных и получаем заметки по имени пользователя
-	return c.db.GetNotesByUserName(username)
+	notes, err := c.db.GetNotesByUserName(username)
+	return notes, convertError(err)
+}
+
+const (
+	// Размер страницы списка заметок по умолчанию и наибольший допустимый
+	DefaultNotePageSize = 20
+	MaxNotePageSize     = 100
+)
+
+func (c TheCore) ListNotes(username string, q entities.NoteQuery) (*entities.NotePage, error) {
+	// Проверяем параметры выборки, пустые заменяем значениями по умолчанию
+	verr := &ValidationError{}
+	if q.Limit == 0 {
+		q.Limit = DefaultNotePageSize
+	} else if q.Limit < 0 || q.Limit > MaxNotePageSize {
+		verr.Add("limit", fmt.Sprintf("must be between 1 and %d", MaxNotePageSize))
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = entities.NoteSortCreated
+	case entities.NoteSortCreated, entities.NoteSortUpdated, entities.NoteSortTitle:
+	default:
+		verr.Add("sort", fmt.Sprintf("must be one of: %s, %s, %s",
+			entities.NoteSortCreated, entities.NoteSortUpdated, entities.NoteSortTitle))
+	}
+
+	q.Tags = normalizeTags(verr, "tags", q.Tags)
+
+	// Курсор от сортировки по заголовку не подходит для сортировки по времени
+	if q.After != nil && q.Sort != entities.NoteSortTitle && q.After.Time == nil {
+		verr.Add("cursor", "does not match the sort order")
+	}
+
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	// Берем на одну заметку больше, чтобы узнать, есть ли следующая страница
+	limit := q.Limit
+	q.Limit++
+	notes, err := c.db.ListNotes(user.ID, q)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	page := &entities.NotePage{Notes: notes}
+	if len(notes) > limit {
+		page.Notes = notes[:limit]
+		page.Next = q.CursorAfter(page.Notes[limit-1])
+	}
+
+	return page, nil
+}
+
+func (c TheCore) GetNoteByID(username string, id uint64) (*entities.Note, error) {
+	note, err := c.ownNote(username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Заметка из корзины доступна только через корзину
+	if note.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	return note, nil
+}
+
+func (c TheCore) ownNote(username string, id uint64) (*entities.Note, error) {
+	// Получаем пользователя, который запрашивает заметку
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	note, err := c.db.GetNoteByID(id)
+	if err != nil {
+		c.logger.Errorf("note %d: %v", id, err)
+		return nil, convertError(err)
+	}
+
+	// Чужие заметки недоступны
+	if note.UserID != user.ID {
+		c.logger.Errorf("user %d is not allowed to access note %d", user.ID, id)
+		return nil, ErrForbidden
+	}
+
+	return note, nil
 }
 
-func (c TheCore) RegisterUser(name, password, repeatedPassword string) error {
-	//Проверяем совпадение паролей
-	if password != repeatedPassword {
-		return fmt.Errorf("passwords do not match")
+func (c TheCore) RegisterUser(name, pass, repeatedPassword string) error {
+	//Проверяем имя пользователя по правилам
+	if err := c.usernamePolicy.Validate(name); err != nil {
+		return err
+	}
+
+	//Проверяем стойкость пароля и совпадение паролей
+	if err := c.validateNewPassword(name, pass, repeatedPassword); err != nil {
+		return err
+	}
+
+	//Имена сравниваем без учета регистра, Ivan и ivan один и тот же пользователь
+	if _, err := c.db.GetUserByName(name); err == nil {
+		return fmt.Errorf("%w: user %s already exists", ErrConflict, name)
+	} else if !errors.Is(err, database.ErrNotFound) {
+		c.logger.Error(err)
+		return err
+	}
+
+	//Храним только соленый хеш пароля
+	hash, err := password.Hash(pass, c.passwordParams)
+	if err != nil {
+		c.logger.Error(err)
+		return err
 	}
 
 	//Создаем экземпляр пользователя
 	user := &entities.User{
-		Name:     name,
-		Password: password,
+		Name:      name,
+		Password:  hash,
+		CreatedAt: c.now(),
 	}
 
 	//Добовляем пользователя в базу данных и получаем его id
 	id, err := c.db.AddUser(user)
-	if err != nil {
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return fmt.Errorf("%w: user %s already exists", ErrConflict, name)
+	} else if err != nil {
 		c.logger.Error(err)
 		return err
 	}
@@ -97,44 +356,118 @@ func (c TheCore) RegisterUser(name, password, repeatedPassword string) error {
 	return nil
 }
 
-func (c TheCore) IsValidUserCredentials(username, password string) (bool, error) {
+func (c TheCore) GetUserByName(username string) (*entities.User, error) {
+	// Обращаемся в базу данных и получаем пользователя по имени
+	user, err := c.db.GetUserByName(username)
+	return user, convertError(err)
+}
+
+func (c TheCore) IsValidUserCredentials(username, pass string) (bool, error) {
 	//Получаем пользователя по имени
 	user, err := c.db.GetUserByName(username)
-	if err != nil {
+	if errors.Is(err, database.ErrNotFound) {
+		//Проверяем пароль впустую, чтобы по времени ответа нельзя было узнать, есть ли пользователь
+		password.Verify(dummyHash(), pass)
+		return false, nil
+	} else if err != nil {
 		c.logger.Error(err)
 		return false, err
 	}
 
-	// err == nil8
-
 	//Проверяем действительный ли пароль пользователя и тот, который он вводит
-	return user.Password == password, nil
+	isValid, err := password.Verify(user.Password, pass)
+	if err != nil || !isValid {
+		return false, err
+	}
+
+	//Старый открытый пароль или устаревшие параметры хеша заменяем новым хешем
+	if password.NeedsRehash(user.Password, c.passwordParams) {
+		c.rehashPassword(user, pass)
+	}
+
+	return true, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHashStr  string
+)
+
+func dummyHash() string {
+	// Хеш для проверки пароля несуществующего пользователя
+	dummyHashOnce.Do(func() {
+		dummyHashStr, _ = password.Hash("dummy password", password.DefaultParams)
+	})
+
+	return dummyHashStr
+}
+
+func (c TheCore) rehashPassword(user *entities.User, pass string) {
+	// Ошибка перехеширования не мешает входу, попробуем при следующем входе
+	hash, err := password.Hash(pass, c.passwordParams)
+	if err != nil {
+		c.logger.Error(err)
+		return
+	}
+
+	user.Password = hash
+	if err = c.db.UpdateUser(user); err != nil {
+		c.logger.Error(err)
+		return
+	}
+
+	c.logger.Infof("password of user %d rehashed", user.ID)
+}
+
+func validateNote(note *entities.Note) error {
+	// Заголовок и содержимое заметки не могут быть пустыми, метки приводим к нормальной форме
+	verr := &ValidationError{}
+	note.Tags = normalizeTags(verr, "tags", note.Tags)
+
+	if strings.TrimSpace(note.Title) == "" {
+		verr.Add("title", "must not be empty")
+	}
+
+	if strings.TrimSpace(note.Content) == "" {
+		verr.Add("content", "must not be empty")
+	}
+
+	return verr.OrNil()
 }
 
 func (c TheCore) AddNoteToUserByName(username string, note *entities.Note) error {
 	// проверка на пустые Title и Content
-	if strings.TrimSpace(note.Title) == "" || strings.TrimSpace(note.Content) == "" {
-		c.logger.Error("empty title or content")
-		return fmt.Errorf("empty title or content")
+	if err := validateNote(note); err != nil {
+		c.logger.Error(err)
+		return err
 	}
 
 	//Получаем пользователя из базы данных по его имени
 	user, err := c.db.GetUserByName(username)
 	if err != nil {
 		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	//Заметка попадает в указанный блокнот пользователя или в блокнот по умолчанию
+	if note.NotebookID, err = c.noteNotebook(user.ID, note.NotebookID); err != nil {
 		return err
 	}
 
-	//Устанавливаем у заметки id пользователя-автора
+	//Устанавливаем у заметки id пользователя-автора и время создания
 	note.UserID = user.ID
+	note.CreatedAt = c.now()
+	note.UpdatedAt = note.CreatedAt
 
 	//Добавляем заметку в базу данных
 	noteID, err := c.db.AddNote(note)
 	if err != nil {
 		c.logger.Error(err)
-		return err
+		return convertError(err)
 	}
 
+	note.ID = noteID
+
 	c.logger.Debug(noteID)
 	return nil
 }
@@ -2,8 +2,20 @@ package core
 
 import (
 	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/diff"
 	"my_notes_project/internal/entities"
+	"my_notes_project/internal/mail"
+	"my_notes_project/internal/password"
+	"my_notes_project/internal/ratelimit"
+	"my_notes_project/internal/search"
+	"my_notes_project/internal/totp"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -12,18 +24,39 @@ import (
 type FakeDatabase struct {
 	notes      map[uint64]*entities.Note
 	users      map[uint64]*entities.User
+	sessions   map[string]*entities.Session
+	tokens     map[uint64]*entities.APIToken
+	attempts   map[string]*entities.LoginAttempt
+	resets     map[string]*entities.PasswordReset
+	totps      map[uint64]*entities.TOTP
+	recovery   map[uint64][]string
+	notebooks  map[uint64]*entities.Notebook
+	revisions  map[uint64][]*entities.Revision
 	nextUserID *uint64
 	nextNoteID *uint64
+	// Начинается с 1, нулевой id блокнота означает "не указан"
+	nextNotebookID *uint64
 }
 
 func NewFakeDatabase() *FakeDatabase {
 	var uid uint64 = 0
 	var nid uint64 = 0
+	var nbid uint64 = 1
 	return &FakeDatabase{
 		notes:      map[uint64]*entities.Note{},
 		users:      map[uint64]*entities.User{},
+		sessions:   map[string]*entities.Session{},
+		tokens:     map[uint64]*entities.APIToken{},
+		attempts:   map[string]*entities.LoginAttempt{},
+		resets:     map[string]*entities.PasswordReset{},
+		totps:      map[uint64]*entities.TOTP{},
+		recovery:   map[uint64][]string{},
+		notebooks:  map[uint64]*entities.Notebook{},
+		revisions:  map[uint64][]*entities.Revision{},
 		nextUserID: &uid,
 		nextNoteID: &nid,
+
+		nextNotebookID: &nbid,
 	}
 }
 
@@ -32,11 +65,33 @@ func (f FakeDatabase) AddUser(user *entities.User) (uint64, error) {
 		return 0, fmt.Errorf("empty username")
 	}
 
+	if u, _ := f.GetUserByName(user.Name); u != nil {
+		return 0, database.ErrAlreadyExists
+	}
+
 	user.ID = *f.nextUserID
 	f.users[user.ID] = user
 	*f.nextUserID += 1
 
-	return 0, nil
+	return user.ID, nil
+}
+
+func (f FakeDatabase) UpdateUser(user *entities.User) error {
+	if _, exists := f.users[user.ID]; !exists {
+		return fmt.Errorf("user doesn't exist")
+	}
+
+	// Пользователь из GetUserByName тот же объект, что и в карте, поэтому
+	// его собственный адрес уже изменен, ищем только среди остальных
+	for _, u := range f.users {
+		if u.ID != user.ID && user.Email != "" && strings.EqualFold(u.Email, user.Email) {
+			return database.ErrAlreadyExists
+		}
+	}
+
+	f.users[user.ID] = user
+
+	return nil
 }
 
 func (f FakeDatabase) AddNote(note *entities.Note) (uint64, error) {
@@ -45,511 +100,2576 @@ func (f FakeDatabase) AddNote(note *entities.Note) (uint64, error) {
 	}
 
 	note.ID = *f.nextNoteID
+	note.Version = 1
 	*f.nextNoteID += 1
 	f.notes[note.ID] = note
+	f.addRevision(note)
 
-	return 0, nil
+	return note.ID, nil
 }
 
 func (f FakeDatabase) RemoveNoteByID(id uint64) error {
 	delete(f.notes, id)
+	delete(f.revisions, id)
 
 	return nil
 }
 
-func (f FakeDatabase) UpdateNote(note *entities.Note) error {
-	f.notes[note.ID] = note
+func (f FakeDatabase) TrashNote(id uint64, at time.Time) error {
+	n, exists := f.notes[id]
+	if !exists || n.DeletedAt != nil {
+		return database.ErrNotFound
+	}
+
+	at = at.UTC()
+	n.DeletedAt = &at
 
 	return nil
 }
 
-func (f FakeDatabase) GetAllNotes() (map[uint64]*entities.Note, error) {
+func (f FakeDatabase) RestoreNote(id uint64) error {
+	n, exists := f.notes[id]
+	if !exists || n.DeletedAt == nil {
+		return database.ErrNotFound
+	}
 
-	return f.notes, nil
+	n.DeletedAt = nil
+
+	return nil
 }
 
-func (f FakeDatabase) GetUserByName(name string) (*entities.User, error) {
-	for _, u := range f.users {
-		if u.Name == name {
-			return u, nil
+func (f FakeDatabase) GetTrashByUserID(userID uint64) ([]*entities.Note, error) {
+	notes := []*entities.Note{}
+	for _, n := range f.notes {
+		if n.UserID == userID && n.DeletedAt != nil {
+			notes = append(notes, n)
 		}
 	}
 
-	return nil, nil
+	sort.Slice(notes, func(i, j int) bool {
+		if !notes[i].DeletedAt.Equal(*notes[j].DeletedAt) {
+			return notes[i].DeletedAt.After(*notes[j].DeletedAt)
+		}
+
+		return notes[i].ID > notes[j].ID
+	})
+
+	return notes, nil
 }
 
-func (f FakeDatabase) GetNotesByUserName(userName string) (map[uint64]*entities.Note, error) {
-	var user *entities.User
-	if u, err := f.GetUserByName(userName); u == nil || err != nil {
-		return nil, err
-	} else {
-		user = u
+func (f FakeDatabase) EmptyTrash(userID uint64) (int, error) {
+	count := 0
+	for id, n := range f.notes {
+		if n.UserID == userID && n.DeletedAt != nil {
+			f.RemoveNoteByID(id)
+			count++
+		}
 	}
 
-	notes := map[uint64]*entities.Note{}
+	return count, nil
+}
 
-	for _, note := range f.notes {
-		if note.UserID == user.ID {
-			notes[note.ID] = note
+func (f FakeDatabase) PurgeTrash(before time.Time) (int, error) {
+	count := 0
+	for id, n := range f.notes {
+		if n.DeletedAt != nil && n.DeletedAt.Before(before) {
+			f.RemoveNoteByID(id)
+			count++
 		}
 	}
 
-	return notes, nil
-
+	return count, nil
 }
 
-func TestRegisterUser(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
-	core := NewTheCore(db, log)
-
-	expectedUser0 := entities.User{
-		Name:     "Ivan",
-		Password: "123",
+func (f FakeDatabase) UpdateNote(note *entities.Note) error {
+	n, exists := f.notes[note.ID]
+	if !exists {
+		return database.ErrNotFound
 	}
 
-	assert.Equal(t, 0, len(db.users))
-	assert.Equal(t, 0, len(db.notes))
+	if note.Version != 0 && note.Version != n.Version {
+		return database.ErrVersionMismatch
+	}
 
-	err := core.RegisterUser(expectedUser0.Name, expectedUser0.Password, expectedUser0.Password)
+	note.Version = n.Version + 1
+	f.notes[note.ID] = note
+	f.addRevision(note)
 
-	assert.Nil(t, err)
-	assert.Equal(t, 1, len(db.users))
-	assert.Equal(t, 0, len(db.notes))
-	assert.Equal(t, *db.users[0], expectedUser0)
+	return nil
+}
 
-	expectedUser1 := entities.User{
-		Name:     "Nikolay",
-		Password: "321",
+func (f FakeDatabase) addRevision(note *entities.Note) {
+	revision := &entities.Revision{
+		NoteID:    note.ID,
+		Number:    len(f.revisions[note.ID]) + 1,
+		Title:     note.Title,
+		Content:   note.Content,
+		AuthorID:  note.UserID,
+		CreatedAt: note.UpdatedAt,
 	}
 
-	err = core.RegisterUser(expectedUser1.Name, expectedUser1.Password, expectedUser1.Password)
+	if u, exists := f.users[note.UserID]; exists {
+		revision.Author = u.Name
+	}
 
-	assert.Nil(t, err)
-	assert.Equal(t, 2, len(db.users))
-	assert.Equal(t, 0, len(db.notes))
-	assert.Equal(t, *db.users[0], expectedUser0)
-	assert.Equal(t, *db.users[1], expectedUser1)
+	f.revisions[note.ID] = append(f.revisions[note.ID], revision)
 }
 
-func TestNotEqualPasswords(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
-	core := NewTheCore(db, log)
+func (f FakeDatabase) GetRevisionsByNoteID(noteID uint64) ([]*entities.Revision, error) {
+	revisions := []*entities.Revision{}
+	for i := len(f.revisions[noteID]) - 1; i >= 0; i-- {
+		revisions = append(revisions, f.revisions[noteID][i])
+	}
 
-	err := core.RegisterUser("Ivan", "123", "321")
+	return revisions, nil
+}
 
-	assert.NotNil(t, err)
-	assert.Equal(t, db, NewFakeDatabase())
+func (f FakeDatabase) GetRevision(noteID uint64, number int) (*entities.Revision, error) {
+	revisions := f.revisions[noteID]
+	if number < 1 || number > len(revisions) {
+		return nil, database.ErrNotFound
+	}
+
+	return revisions[number-1], nil
 }
 
-func TestInvalidUserName(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
-	core := NewTheCore(db, log)
+func (f FakeDatabase) GetAllNotes() (map[uint64]*entities.Note, error) {
 
-	err := core.RegisterUser("", "123", "123")
+	return f.notes, nil
+}
 
-	assert.NotNil(t, err)
-	assert.Equal(t, db, NewFakeDatabase())
+func (f FakeDatabase) GetNoteByID(id uint64) (*entities.Note, error) {
+	if n, exists := f.notes[id]; exists {
+		return n, nil
+	}
+
+	return nil, database.ErrNotFound
 }
 
-func TestExistenceNote(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
-	core := NewTheCore(db, log)
+func (f FakeDatabase) ListNotes(userID uint64, q entities.NoteQuery) ([]*entities.Note, error) {
+	// Тот же порядок и те же условия, что и в SQLite
+	less := func(a, b *entities.Note) bool {
+		switch {
+		case q.Sort == entities.NoteSortTitle && a.Title != b.Title:
+			return a.Title < b.Title
+		case q.Sort == entities.NoteSortUpdated && !a.UpdatedAt.Equal(b.UpdatedAt):
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		case q.Sort == entities.NoteSortCreated && !a.CreatedAt.Equal(b.CreatedAt):
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
 
-	u := entities.User{
-		ID:       0,
-		Name:     "Sasha",
-		Password: "&86398",
-	}
-	n := entities.Note{
-		ID:      0,
-		Title:   "Testify",
-		Content: "В testify есть два основных пакета с проверками — assert и require",
-		UserID:  u.ID,
-	}
-	note := entities.Note{
-		ID:      1,
-		Title:   "Testify",
-		Content: "В testify есть два основных пакета с проверками — assert и require",
-		UserID:  u.ID,
-	}
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
+		return a.ID < b.ID
 	}
 
-	db.notes = map[uint64]*entities.Note{
-		n.ID: &n,
+	notes := []*entities.Note{}
+	for _, n := range f.notes {
+		if n.UserID != userID || n.DeletedAt != nil || !strings.Contains(strings.ToLower(n.Title), strings.ToLower(q.Title)) {
+			continue
+		}
+
+		found := 0
+		for _, tag := range q.Tags {
+			if containsString(n.Tags, tag) {
+				found++
+			}
+		}
+		if len(q.Tags) > 0 && ((q.AnyTag && found == 0) || (!q.AnyTag && found < len(q.Tags))) {
+			continue
+		}
+
+		if q.After != nil {
+			after := &entities.Note{ID: q.After.ID, Title: q.After.Title}
+			if q.After.Time != nil {
+				after.CreatedAt = *q.After.Time
+				after.UpdatedAt = *q.After.Time
+			}
+			if (!q.Desc && !less(after, n)) || (q.Desc && !less(n, after)) {
+				continue
+			}
+		}
+
+		notes = append(notes, n)
 	}
 
-	err := core.UpdateNoteByUserName(u.Name, &note)
+	sort.Slice(notes, func(i, j int) bool {
+		if q.Desc {
+			return less(notes[j], notes[i])
+		}
 
-	assert.NotNil(t, err)
+		return less(notes[i], notes[j])
+	})
+
+	if len(notes) > q.Limit {
+		notes = notes[:q.Limit]
+	}
 
+	return notes, nil
 }
 
-func TestUpdateNoteByUserName(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
+func (f FakeDatabase) SearchNotes(userID uint64, terms []search.Term, limit int) ([]*entities.NoteMatch, error) {
+	// Как поиск без полнотекстового индекса, но упорядоченный по id
+	matches := []*entities.NoteMatch{}
+	for _, n := range f.notes {
+		if n.UserID != userID || n.DeletedAt != nil {
+			continue
+		}
 
-	u := entities.User{
-		ID:       3,
-		Name:     "Ivan",
-		Password: "123",
-	}
-	n := entities.Note{
-		ID:      1,
-		Title:   "Beach",
-		Content: "nice beach and ocean",
-		UserID:  u.ID,
+		if snippet, _, ok := search.Match(terms, n.Title, n.Content); ok {
+			matches = append(matches, &entities.NoteMatch{Note: n, Snippet: snippet})
+		}
 	}
 
-	expectedNote := entities.Note{
-		ID:      n.ID,
-		Title:   "Linux Modules",
-		Content: "Linux is the best OS",
-		UserID:  u.ID,
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Note.ID < matches[j].Note.ID
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
 	}
 
-	ns := map[uint64]*entities.Note{
-		0: {
-			ID:      0,
-			Title:   "Tree",
-			Content: "One,two",
-			UserID:  u.ID,
-		},
-		n.ID: &n,
+	return matches, nil
+}
+
+func (f FakeDatabase) SetNoteTags(note *entities.Note) error {
+	n, exists := f.notes[note.ID]
+	if !exists {
+		return database.ErrNotFound
 	}
 
-	db.notes = ns
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
+	n.Tags = append([]string(nil), note.Tags...)
+
+	return nil
+}
+
+func (f FakeDatabase) GetTagsByUserID(userID uint64) ([]*entities.Tag, error) {
+	// Метки хранятся только на заметках, как в SQLite неиспользуемых меток нет
+	counts := map[string]int{}
+	for _, n := range f.notes {
+		if n.UserID != userID {
+			continue
+		}
+
+		// Заметки из корзины метку не удаляют, но и не считаются
+		count := 1
+		if n.DeletedAt != nil {
+			count = 0
+		}
+
+		for _, tag := range n.Tags {
+			counts[tag] += count
+		}
 	}
 
-	core := NewTheCore(db, log)
+	tags := []*entities.Tag{}
+	for name, count := range counts {
+		tags = append(tags, &entities.Tag{UserID: userID, Name: name, Notes: count})
+	}
 
-	assert.Equal(t, &n, db.notes[n.ID])
-	err := core.UpdateNoteByUserName(u.Name, &expectedNote)
-	assert.Nil(t, err)
-	assert.Equal(t, &expectedNote, db.notes[expectedNote.ID])
-	core.UpdateNoteByUserName(u.Name, &expectedNote)
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
 
+	return tags, nil
 }
 
-func TestEmptyTitleAndContent(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
-	core := NewTheCore(db, log)
+func (f FakeDatabase) replaceTag(userID uint64, from, to string) bool {
+	found := false
+	for _, n := range f.notes {
+		if n.UserID != userID || !containsString(n.Tags, from) {
+			continue
+		}
 
-	u := entities.User{
-		ID:       3,
-		Name:     "Ivan",
-		Password: "123",
-	}
+		found = true
+		tags := []string{}
+		for _, tag := range n.Tags {
+			if tag != from && tag != to {
+				tags = append(tags, tag)
+			}
+		}
+		if to != "" {
+			tags = append(tags, to)
+		}
 
-	n := entities.Note{
-		ID:      1,
-		Title:   "Beach",
-		Content: "nice beach and ocean",
-		UserID:  u.ID,
+		sort.Strings(tags)
+		n.Tags = tags
 	}
 
-	db.notes = map[uint64]*entities.Note{
-		n.ID: &n,
+	return found
+}
+
+func (f FakeDatabase) RenameTag(userID uint64, from, to string) error {
+	tags, _ := f.GetTagsByUserID(userID)
+	for _, tag := range tags {
+		if tag.Name == to {
+			return database.ErrAlreadyExists
+		}
 	}
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
+
+	if !f.replaceTag(userID, from, to) {
+		return database.ErrNotFound
 	}
 
-	note := entities.Note{
-		ID:      n.ID,
-		Title:   n.Title,
-		Content: "",
-		UserID:  u.ID,
+	return nil
+}
+
+func (f FakeDatabase) MergeTags(userID uint64, from, into string) error {
+	if !f.replaceTag(userID, from, into) {
+		return database.ErrNotFound
 	}
 
-	err := core.UpdateNoteByUserName(u.Name, &note)
+	return nil
+}
 
-	assert.NotNil(t, err)
+func (f FakeDatabase) RemoveTag(userID uint64, name string) error {
+	if !f.replaceTag(userID, name, "") {
+		return database.ErrNotFound
+	}
 
-	note = entities.Note{
-		ID:      n.ID,
-		Title:   "",
-		Content: n.Content,
-		UserID:  u.ID,
+	return nil
+}
+
+func (f FakeDatabase) AddNotebook(notebook *entities.Notebook) (uint64, error) {
+	if err := f.checkNotebookName(notebook); err != nil {
+		return 0, err
 	}
 
-	err = core.UpdateNoteByUserName(u.Name, &note)
+	notebook.ID = *f.nextNotebookID
+	*f.nextNotebookID += 1
+	f.notebooks[notebook.ID] = notebook
 
-	assert.NotNil(t, err)
+	return notebook.ID, nil
 }
 
-func TestIsValidUserCredentials(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
+func (f FakeDatabase) checkNotebookName(notebook *entities.Notebook) error {
+	for _, nb := range f.notebooks {
+		if nb.ID != notebook.ID && nb.UserID == notebook.UserID && strings.EqualFold(nb.Name, notebook.Name) &&
+			reflect.DeepEqual(nb.ParentID, notebook.ParentID) {
+			return database.ErrAlreadyExists
+		}
+	}
 
-	u := entities.User{
-		ID:       3,
-		Name:     "Ivan",
-		Password: "123",
+	return nil
+}
+
+func (f FakeDatabase) GetNotebookByID(id uint64) (*entities.Notebook, error) {
+	nb, exists := f.notebooks[id]
+	if !exists {
+		return nil, database.ErrNotFound
 	}
 
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
+	copied := *nb
+	copied.Notes = 0
+	for _, n := range f.notes {
+		if n.NotebookID == id && n.DeletedAt == nil {
+			copied.Notes++
+		}
 	}
 
-	core := NewTheCore(db, log)
-	isValid, err := core.IsValidUserCredentials(u.Name, u.Password)
+	return &copied, nil
+}
 
-	assert.Nil(t, err)
-	assert.True(t, isValid)
+func (f FakeDatabase) GetNotebooksByUserID(userID uint64) ([]*entities.Notebook, error) {
+	notebooks := []*entities.Notebook{}
+	for id, nb := range f.notebooks {
+		if nb.UserID == userID {
+			copied, _ := f.GetNotebookByID(id)
+			notebooks = append(notebooks, copied)
+		}
+	}
 
+	sort.Slice(notebooks, func(i, j int) bool {
+		if notebooks[i].Name != notebooks[j].Name {
+			return notebooks[i].Name < notebooks[j].Name
+		}
+
+		return notebooks[i].ID < notebooks[j].ID
+	})
+
+	return notebooks, nil
 }
 
-func TestGetAllNotes(t *testing.T) {
-	db := NewFakeDatabase()
-	log := logrus.New()
+func (f FakeDatabase) UpdateNotebook(notebook *entities.Notebook) error {
+	if _, exists := f.notebooks[notebook.ID]; !exists {
+		return database.ErrNotFound
+	}
 
-	u := entities.User{
-		ID:       3,
+	if err := f.checkNotebookName(notebook); err != nil {
+		return err
+	}
+
+	f.notebooks[notebook.ID] = notebook
+
+	return nil
+}
+
+func (f FakeDatabase) RemoveNotebookByID(id uint64, inbox *entities.Notebook, at time.Time) error {
+	// Как в SQLite: заметки дерева в корзину и в inbox, блокноты дерева удаляются
+	if _, exists := f.notebooks[id]; !exists {
+		return database.ErrNotFound
+	}
+
+	tree := map[uint64]bool{id: true}
+	for grown := true; grown; {
+		grown = false
+		for nbID, nb := range f.notebooks {
+			if nb.ParentID != nil && tree[*nb.ParentID] && !tree[nbID] {
+				tree[nbID] = true
+				grown = true
+			}
+		}
+	}
+
+	for nbID := range tree {
+		delete(f.notebooks, nbID)
+	}
+
+	if inbox.ID == 0 {
+		if _, err := f.AddNotebook(inbox); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range f.notes {
+		if tree[n.NotebookID] {
+			if n.DeletedAt == nil {
+				deletedAt := at.UTC()
+				n.DeletedAt = &deletedAt
+			}
+			n.NotebookID = inbox.ID
+		}
+	}
+
+	return nil
+}
+
+func (f FakeDatabase) MoveNote(noteID, notebookID uint64) error {
+	n, exists := f.notes[noteID]
+	if !exists {
+		return database.ErrNotFound
+	}
+
+	n.NotebookID = notebookID
+
+	return nil
+}
+
+func (f FakeDatabase) GetUserByName(name string) (*entities.User, error) {
+	// Сравниваем по тому же ключу, что и SQLite
+	for _, u := range f.users {
+		if database.UsernameKey(u.Name) == database.UsernameKey(name) {
+			return u, nil
+		}
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) GetUserByEmail(email string) (*entities.User, error) {
+	for _, u := range f.users {
+		if email != "" && strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) AddPasswordReset(reset *entities.PasswordReset) error {
+	f.resets[reset.ID] = reset
+
+	return nil
+}
+
+func (f FakeDatabase) GetPasswordReset(id string) (*entities.PasswordReset, error) {
+	if r, exists := f.resets[id]; exists {
+		return r, nil
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) RemovePasswordResetByID(id string) error {
+	if _, exists := f.resets[id]; !exists {
+		return database.ErrNotFound
+	}
+
+	delete(f.resets, id)
+
+	return nil
+}
+
+func (f FakeDatabase) RemovePasswordResetsByUserID(userID uint64) error {
+	for id, r := range f.resets {
+		if r.UserID == userID {
+			delete(f.resets, id)
+		}
+	}
+
+	return nil
+}
+
+func (f FakeDatabase) GetTOTP(userID uint64) (*entities.TOTP, error) {
+	if t, exists := f.totps[userID]; exists {
+		return t, nil
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) SaveTOTP(totp *entities.TOTP) error {
+	f.totps[totp.UserID] = totp
+
+	return nil
+}
+
+func (f FakeDatabase) AdvanceTOTPCounter(userID, counter uint64) error {
+	t, exists := f.totps[userID]
+	if !exists || t.LastCounter >= counter {
+		return database.ErrNotFound
+	}
+
+	t.LastCounter = counter
+
+	return nil
+}
+
+func (f FakeDatabase) RemoveTOTP(userID uint64) error {
+	delete(f.totps, userID)
+	delete(f.recovery, userID)
+
+	return nil
+}
+
+func (f FakeDatabase) ReplaceRecoveryCodes(userID uint64, hashes []string) error {
+	f.recovery[userID] = append([]string{}, hashes...)
+
+	return nil
+}
+
+func (f FakeDatabase) UseRecoveryCode(userID uint64, hash string) error {
+	for i, h := range f.recovery[userID] {
+		if h == hash {
+			f.recovery[userID] = append(f.recovery[userID][:i], f.recovery[userID][i+1:]...)
+			return nil
+		}
+	}
+
+	return database.ErrNotFound
+}
+
+func (f FakeDatabase) CountRecoveryCodes(userID uint64) (int, error) {
+	return len(f.recovery[userID]), nil
+}
+
+func (f FakeDatabase) GetUserByID(id uint64) (*entities.User, error) {
+	if u, exists := f.users[id]; exists {
+		return u, nil
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) AddSession(session *entities.Session) error {
+	f.sessions[session.ID] = session
+
+	return nil
+}
+
+func (f FakeDatabase) GetSessionByID(id string) (*entities.Session, error) {
+	if s, exists := f.sessions[id]; exists {
+		return s, nil
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) RemoveSessionByID(id string) error {
+	if _, exists := f.sessions[id]; !exists {
+		return database.ErrNotFound
+	}
+
+	delete(f.sessions, id)
+
+	return nil
+}
+
+func (f FakeDatabase) RemoveSessionsByUserID(userID uint64) error {
+	for id, s := range f.sessions {
+		if s.UserID == userID {
+			delete(f.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func (f FakeDatabase) RemoveSessionsByUserIDExcept(userID uint64, keepID string) error {
+	for id, s := range f.sessions {
+		if s.UserID == userID && id != keepID {
+			delete(f.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func (f FakeDatabase) UpdateUserLastLogin(id uint64, at time.Time) error {
+	if u, exists := f.users[id]; exists {
+		u.LastLoginAt = &at
+		return nil
+	}
+
+	return database.ErrNotFound
+}
+
+func (f FakeDatabase) RemoveUserByID(id uint64) error {
+	if _, exists := f.users[id]; !exists {
+		return database.ErrNotFound
+	}
+
+	// Как ON DELETE CASCADE в SQLite
+	delete(f.users, id)
+	for noteID, n := range f.notes {
+		if n.UserID == id {
+			delete(f.notes, noteID)
+		}
+	}
+	for tokenID, t := range f.tokens {
+		if t.UserID == id {
+			delete(f.tokens, tokenID)
+		}
+	}
+
+	return f.RemoveSessionsByUserID(id)
+}
+
+func (f FakeDatabase) AddAPIToken(token *entities.APIToken) (uint64, error) {
+	token.ID = 1
+	for id := range f.tokens {
+		if id >= token.ID {
+			token.ID = id + 1
+		}
+	}
+
+	f.tokens[token.ID] = token
+
+	return token.ID, nil
+}
+
+func (f FakeDatabase) GetAPITokenByHash(hash string) (*entities.APIToken, error) {
+	for _, t := range f.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) GetAPITokensByUserID(userID uint64) ([]*entities.APIToken, error) {
+	tokens := []*entities.APIToken{}
+	for _, t := range f.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID < tokens[j].ID
+	})
+
+	return tokens, nil
+}
+
+func (f FakeDatabase) RemoveAPITokenByID(id uint64) error {
+	if _, exists := f.tokens[id]; !exists {
+		return database.ErrNotFound
+	}
+
+	delete(f.tokens, id)
+
+	return nil
+}
+
+func (f FakeDatabase) UpdateAPITokenLastUsed(id uint64, at time.Time) error {
+	if t, exists := f.tokens[id]; exists {
+		t.LastUsedAt = &at
+		return nil
+	}
+
+	return database.ErrNotFound
+}
+
+func (f FakeDatabase) GetLoginAttempt(key string) (*entities.LoginAttempt, error) {
+	if a, exists := f.attempts[key]; exists {
+		return a, nil
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (f FakeDatabase) SaveLoginAttempt(attempt *entities.LoginAttempt) error {
+	f.attempts[attempt.Key] = attempt
+
+	return nil
+}
+
+func (f FakeDatabase) RemoveLoginAttempt(key string) error {
+	delete(f.attempts, key)
+
+	return nil
+}
+
+func (f FakeDatabase) GetNotesByUserName(userName string) (map[uint64]*entities.Note, error) {
+	var user *entities.User
+	if u, err := f.GetUserByName(userName); u == nil || err != nil {
+		return nil, err
+	} else {
+		user = u
+	}
+
+	notes := map[uint64]*entities.Note{}
+
+	for _, note := range f.notes {
+		if note.UserID == user.ID && note.DeletedAt == nil {
+			notes[note.ID] = note
+		}
+	}
+
+	return notes, nil
+
+}
+
+func TestRegisterUser(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	expectedUser0 := entities.User{
 		Name:     "Ivan",
-		Password: "123",
+		Password: "Ocean-breeze-7",
 	}
 
-	ns := map[uint64]*entities.Note{
-		0: {
-			ID:      0,
-			Title:   "Tree",
-			Content: "One,two",
-			UserID:  u.ID,
-		},
+	assert.Equal(t, 0, len(db.users))
+	assert.Equal(t, 0, len(db.notes))
+
+	err := core.RegisterUser(expectedUser0.Name, expectedUser0.Password, expectedUser0.Password)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.users))
+	assert.Equal(t, 0, len(db.notes))
+	assertUser(t, expectedUser0, db.users[0])
+
+	expectedUser1 := entities.User{
+		Name:     "Nikolay",
+		Password: "Mountain-air-9",
 	}
 
-	db.notes = ns
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
+	err = core.RegisterUser(expectedUser1.Name, expectedUser1.Password, expectedUser1.Password)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(db.users))
+	assert.Equal(t, 0, len(db.notes))
+	assertUser(t, expectedUser0, db.users[0])
+	assertUser(t, expectedUser1, db.users[1])
+	assert.NotEqual(t, db.users[0].Password, db.users[1].Password)
+}
+
+func TestRegisterUserIgnoresCaseInAnyAlphabet(t *testing.T) {
+	// Поддельная база сравнивает имена так же, как SQLite, проверяем и на настоящей
+	db, err := database.NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	if !assert.Nil(t, err) {
+		return
 	}
+	defer db.CloseSQLiteDatabase()
+
+	core := NewTheCore(db, logrus.New())
+
+	assert.Nil(t, core.RegisterUser("Иван", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.ErrorIs(t, core.RegisterUser("иван", "Ocean-breeze-7", "Ocean-breeze-7"), ErrConflict)
+	assert.ErrorIs(t, core.RegisterUser("ИВАН", "Ocean-breeze-7", "Ocean-breeze-7"), ErrConflict)
+
+	valid, err := core.IsValidUserCredentials("иВАН", "Ocean-breeze-7")
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
+
+func assertUser(t *testing.T, expected entities.User, actual *entities.User) {
+	// Пароль хранится только в виде хеша
+	assert.Equal(t, expected.Name, actual.Name)
+	assert.NotEqual(t, expected.Password, actual.Password)
+	assert.True(t, password.IsHashed(actual.Password))
+
+	isValid, err := password.Verify(actual.Password, expected.Password)
+	assert.Nil(t, err)
+	assert.True(t, isValid)
+}
+
+func TestNotEqualPasswords(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	err := core.RegisterUser("Ivan", "Ocean-breeze-7", "Mountain-air-9")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, db, NewFakeDatabase())
+}
+
+func TestInvalidUserName(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	err := core.RegisterUser("", "Ocean-breeze-7", "Ocean-breeze-7")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, db, NewFakeDatabase())
+}
+
+func TestExistenceNote(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	u := entities.User{
+		ID:       0,
+		Name:     "Sasha",
+		Password: "&86398",
+	}
+	n := entities.Note{
+		ID:      0,
+		Title:   "Testify",
+		Content: "В testify есть два основных пакета с проверками — assert и require",
+		UserID:  u.ID,
+	}
+	note := entities.Note{
+		ID:      1,
+		Title:   "Testify",
+		Content: "В testify есть два основных пакета с проверками — assert и require",
+		UserID:  u.ID,
+	}
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+
+	db.notes = map[uint64]*entities.Note{
+		n.ID: &n,
+	}
+
+	err := core.UpdateNoteByUserName(u.Name, &note)
+
+	assert.NotNil(t, err)
+
+}
+
+func TestUpdateNoteByUserName(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	u := entities.User{
+		ID:       3,
+		Name:     "Ivan",
+		Password: "123",
+	}
+	n := entities.Note{
+		ID:      1,
+		Title:   "Beach",
+		Content: "nice beach and ocean",
+		UserID:  u.ID,
+	}
+
+	expectedNote := entities.Note{
+		ID:      n.ID,
+		Title:   "Linux Modules",
+		Content: "Linux is the best OS",
+		UserID:  u.ID,
+	}
+
+	ns := map[uint64]*entities.Note{
+		0: {
+			ID:      0,
+			Title:   "Tree",
+			Content: "One,two",
+			UserID:  u.ID,
+		},
+		n.ID: &n,
+	}
+
+	db.notes = ns
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+
+	core := NewTheCore(db, log)
+
+	assert.Equal(t, &n, db.notes[n.ID])
+	err := core.UpdateNoteByUserName(u.Name, &expectedNote)
+	assert.Nil(t, err)
+	assert.Equal(t, &expectedNote, db.notes[expectedNote.ID])
+	core.UpdateNoteByUserName(u.Name, &expectedNote)
+
+}
+
+func TestEmptyTitleAndContent(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	u := entities.User{
+		ID:       3,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	n := entities.Note{
+		ID:      1,
+		Title:   "Beach",
+		Content: "nice beach and ocean",
+		UserID:  u.ID,
+	}
+
+	db.notes = map[uint64]*entities.Note{
+		n.ID: &n,
+	}
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+
+	note := entities.Note{
+		ID:      n.ID,
+		Title:   n.Title,
+		Content: "",
+		UserID:  u.ID,
+	}
+
+	err := core.UpdateNoteByUserName(u.Name, &note)
+
+	assert.NotNil(t, err)
+
+	note = entities.Note{
+		ID:      n.ID,
+		Title:   "",
+		Content: n.Content,
+		UserID:  u.ID,
+	}
+
+	err = core.UpdateNoteByUserName(u.Name, &note)
+
+	assert.NotNil(t, err)
+}
+
+func TestIsValidUserCredentials(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	err := core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7")
+	assert.Nil(t, err)
+
+	isValid, err := core.IsValidUserCredentials("Ivan", "Ocean-breeze-7")
+	assert.Nil(t, err)
+	assert.True(t, isValid)
+
+	isValid, err = core.IsValidUserCredentials("Ivan", "1234")
+	assert.Nil(t, err)
+	assert.False(t, isValid)
+}
+
+func TestIsValidUserCredentialsRehashesLegacyPassword(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	u := entities.User{
+		ID:       3,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+
+	core := NewTheCore(db, log)
+
+	// Неверный пароль не должен менять запись
+	isValid, err := core.IsValidUserCredentials(u.Name, "321")
+	assert.Nil(t, err)
+	assert.False(t, isValid)
+	assert.Equal(t, "123", db.users[u.ID].Password)
+
+	isValid, err = core.IsValidUserCredentials(u.Name, "123")
+	assert.Nil(t, err)
+	assert.True(t, isValid)
+	assert.True(t, password.IsHashed(db.users[u.ID].Password))
+
+	// После перехеширования вход продолжает работать
+	isValid, err = core.IsValidUserCredentials(u.Name, "123")
+	assert.Nil(t, err)
+	assert.True(t, isValid)
+}
+
+func TestGetAllNotes(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	u := entities.User{
+		ID:       3,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	ns := map[uint64]*entities.Note{
+		0: {
+			ID:      0,
+			Title:   "Tree",
+			Content: "One,two",
+			UserID:  u.ID,
+		},
+	}
+
+	db.notes = ns
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+	core := NewTheCore(db, log)
+	res, err := core.GetAllNotes()
+
+	assert.Nil(t, err)
+	assert.Equal(t, ns, res)
+}
+
+func TestRemoveNoteByUserNameTrashes(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	u := entities.User{
+		ID:       3,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	n := entities.Note{
+		ID:      1,
+		Title:   "Beach",
+		Content: "nice beach and ocean",
+		UserID:  u.ID,
+	}
+
+	n1 := entities.Note{
+		ID:      2,
+		Title:   "Hills",
+		Content: "green hills",
+		UserID:  u.ID,
+	}
+
+	db.notes = map[uint64]*entities.Note{
+		n.ID:  &n,
+		n1.ID: &n1,
+	}
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+
+	// Заметка не удаляется, а переносится в корзину, остальные заметки не трогаем
+	err := core.RemoveNoteByUserName(u.Name, n.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(db.notes))
+	assert.NotNil(t, db.notes[n.ID].DeletedAt)
+	assert.Nil(t, db.notes[n1.ID].DeletedAt)
+}
+
+func TestAddNoteToUserByName(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	u := entities.User{
+		ID:       0,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	n := entities.Note{
+		ID:      1,
+		Title:   "Beach",
+		Content: "nice beach and ocean",
+		UserID:  u.ID,
+	}
+
+	ns := map[uint64]*entities.Note{
+		0: {
+			ID:      0,
+			Title:   "Tree",
+			Content: "One,two",
+			UserID:  u.ID,
+		},
+	}
+
+	db.notes = ns
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+	*db.nextNoteID = 1
+	*db.nextUserID = 1
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	core := NewTheCore(db, log, WithClock(func() time.Time { return now }))
+
+	expectedNotes := map[uint64]*entities.Note{
+		0: {
+			ID:      0,
+			Title:   "Tree",
+			Content: "One,two",
+			UserID:  u.ID,
+		},
+		1: {
+			ID:      1,
+			Title:   "Beach",
+			Content: "nice beach and ocean",
+			UserID:  u.ID,
+			// Блокнот по умолчанию создается вместе с первой заметкой
+			NotebookID: 1,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			Version:    1,
+		},
+	}
+
+	err := core.AddNoteToUserByName(u.Name, &n)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedNotes, db.notes)
+}
+
+func TestGetNotesByUserName(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	u := entities.User{
+		ID:       0,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	u1 := entities.User{
+		ID:       1,
+		Name:     "Igor",
+		Password: "01876",
+	}
+
+	ns := map[uint64]*entities.Note{
+		0: {
+			ID:      0,
+			Title:   "Tree",
+			Content: "One,two",
+			UserID:  u.ID,
+		},
+		1: {
+			ID:      1,
+			Title:   "Beach",
+			Content: "nice beach and ocean",
+			UserID:  u.ID,
+		},
+		2: {
+			ID:      2,
+			Title:   "Algorithm",
+			Content: "Binary search, selection sort",
+			UserID:  u1.ID,
+		},
+		3: {
+			ID:      3,
+			Title:   "Algorithm",
+			Content: "Recursion, hash tables",
+			UserID:  u1.ID,
+		},
+	}
+
+	db.notes = ns
+	db.users = map[uint64]*entities.User{
+		u.ID:  &u,
+		u1.ID: &u1,
+	}
+	*db.nextNoteID = 4
+	*db.nextUserID = 2
+	core := NewTheCore(db, log)
+
+	expectedNotes := map[uint64]*entities.Note{
+		0: {
+			ID:      0,
+			Title:   "Tree",
+			Content: "One,two",
+			UserID:  u.ID,
+		},
+		1: {
+			ID:      1,
+			Title:   "Beach",
+			Content: "nice beach and ocean",
+			UserID:  u.ID,
+		},
+	}
+
+	expectedNotes1 := map[uint64]*entities.Note{
+		2: {
+			ID:      2,
+			Title:   "Algorithm",
+			Content: "Binary search, selection sort",
+			UserID:  u1.ID,
+		},
+		3: {
+			ID:      3,
+			Title:   "Algorithm",
+			Content: "Recursion, hash tables",
+			UserID:  u1.ID,
+		},
+	}
+	res, err := core.GetNotesByUserName(u.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedNotes, res)
+	res1, err := core.GetNotesByUserName(u1.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedNotes1, res1)
+
+}
+
+func TestAddNoteToUserByNameEmptyTitleAndContent(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	u := entities.User{
+		ID:       0,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	db.users = map[uint64]*entities.User{
+		u.ID: &u,
+	}
+
+	core := NewTheCore(db, log)
+
+	note := entities.Note{
+		ID:      0,
+		Title:   "Member of society",
+		Content: "",
+		UserID:  u.ID,
+	}
+
+	err := core.AddNoteToUserByName(u.Name, &note)
+
+	assert.NotNil(t, err)
+
+	note = entities.Note{
+		ID:      1,
+		Title:   "",
+		Content: "В testify есть два основных пакета с проверками — assert и require",
+		UserID:  u.ID,
+	}
+
+	err = core.AddNoteToUserByName(u.Name, &note)
+
+	assert.NotNil(t, err)
+}
+
+func TestSession(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	err := core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7")
+	assert.Nil(t, err)
+
+	token, session, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+	assert.True(t, session.ExpiresAt.After(time.Now()))
+
+	// В базе данных не хранится сам идентификатор сессии
+	assert.Equal(t, 1, len(db.sessions))
+	assert.NotContains(t, db.sessions, token)
+
+	user, err := core.GetUserBySession(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ivan", user.Name)
+
+	_, err = core.GetUserBySession(token + "x")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	err = core.RevokeSession(token)
+	assert.Nil(t, err)
+	assert.Empty(t, db.sessions)
+
+	_, err = core.GetUserBySession(token)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestExpiredSession(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log, WithSessionTTL(-time.Minute))
+
+	err := core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7")
+	assert.Nil(t, err)
+
+	token, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+
+	_, err = core.GetUserBySession(token)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Empty(t, db.sessions)
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	token1, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	token2, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	token3, _, err := core.CreateSession("Igor")
+	assert.Nil(t, err)
+
+	err = core.RevokeAllSessions("Ivan")
+	assert.Nil(t, err)
+
+	_, err = core.GetUserBySession(token1)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = core.GetUserBySession(token2)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	// Сессии других пользователей не затрагиваются
+	user, err := core.GetUserBySession(token3)
+	assert.Nil(t, err)
+	assert.Equal(t, "Igor", user.Name)
+}
+
+func TestRemoveNoteByUserName(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	u := entities.User{
+		ID:       0,
+		Name:     "Ivan",
+		Password: "123",
+	}
+
+	u1 := entities.User{
+		ID:       1,
+		Name:     "Igor",
+		Password: "01876",
+	}
+
+	n := entities.Note{
+		ID:      1,
+		Title:   "Beach",
+		Content: "nice beach and ocean",
+		UserID:  u.ID,
+	}
+
+	db.notes = map[uint64]*entities.Note{
+		n.ID: &n,
+	}
+	db.users = map[uint64]*entities.User{
+		u.ID:  &u,
+		u1.ID: &u1,
+	}
+
+	// Чужую заметку удалить нельзя
+	err := core.RemoveNoteByUserName(u1.Name, n.ID)
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.Equal(t, 1, len(db.notes))
+
+	err = core.RemoveNoteByUserName(u.Name, 42)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = core.RemoveNoteByUserName(u.Name, n.ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, db.notes[n.ID].DeletedAt)
+
+	// Заметка уже в корзине
+	err = core.RemoveNoteByUserName(u.Name, n.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGetNoteByID(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	n := &entities.Note{Title: "Beach", Content: "nice beach and ocean"}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", n))
+
+	note, err := core.GetNoteByID("Ivan", n.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Beach", note.Title)
+
+	// Чужую заметку получить и изменить нельзя
+	_, err = core.GetNoteByID("Igor", n.ID)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	err = core.UpdateNoteByUserName("Igor", &entities.Note{ID: n.ID, Title: "Hills", Content: "forest"})
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.Equal(t, "Beach", db.notes[n.ID].Title)
+
+	_, err = core.GetNoteByID("Ivan", 42)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = core.GetNoteByID("Nobody", n.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestListNotes(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	for _, title := range []string{"Delta", "Alpha", "Charlie", "Bravo", "Alpha"} {
+		assert.Nil(t, core.AddNoteToUserByName("Ivan", &entities.Note{Title: title, Content: "text"}))
+	}
+	assert.Nil(t, core.AddNoteToUserByName("Igor", &entities.Note{Title: "Echo", Content: "text"}))
+
+	// Обходим все страницы по курсору
+	titles := func(q entities.NoteQuery) []string {
+		result := []string{}
+		for {
+			page, err := core.ListNotes("Ivan", q)
+			if !assert.Nil(t, err) {
+				return result
+			}
+
+			for _, n := range page.Notes {
+				result = append(result, n.Title)
+			}
+
+			if page.Next == nil {
+				return result
+			}
+
+			q.After = page.Next
+		}
+	}
+
+	assert.Equal(t, []string{"Delta", "Alpha", "Charlie", "Bravo", "Alpha"},
+		titles(entities.NoteQuery{Limit: 2}))
+	assert.Equal(t, []string{"Alpha", "Bravo", "Charlie", "Alpha", "Delta"},
+		titles(entities.NoteQuery{Limit: 2, Desc: true}))
+	assert.Equal(t, []string{"Alpha", "Alpha", "Bravo", "Charlie", "Delta"},
+		titles(entities.NoteQuery{Limit: 2, Sort: entities.NoteSortTitle}))
+	assert.Equal(t, []string{"Delta", "Charlie", "Bravo", "Alpha", "Alpha"},
+		titles(entities.NoteQuery{Limit: 3, Sort: entities.NoteSortTitle, Desc: true}))
+	assert.Equal(t, []string{"Alpha", "Charlie", "Alpha"},
+		titles(entities.NoteQuery{Title: "ha"}))
+
+	// Последняя страница без курсора
+	page, err := core.ListNotes("Ivan", entities.NoteQuery{Limit: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(page.Notes))
+	assert.Nil(t, page.Next)
+
+	_, err = core.ListNotes("Ivan", entities.NoteQuery{Limit: MaxNotePageSize + 1, Sort: "size"})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, 2, len(verr.Fields))
+}
+
+func TestTimestamps(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+
+	// Часы идут вперед на минуту при каждом обращении
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	core := NewTheCore(db, log, WithClock(clock))
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	user, err := core.GetUserByName("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC), user.CreatedAt)
+	assert.Nil(t, user.LastLoginAt)
+
+	// Время входа отмечается только после второго шага, если он нужен
+	_, session, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	if assert.NotNil(t, user.LastLoginAt) {
+		assert.Equal(t, session.CreatedAt, *user.LastLoginAt)
+	}
+
+	first := &entities.Note{Title: "Beach", Content: "ocean"}
+	second := &entities.Note{Title: "Hills", Content: "forest"}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", first))
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", second))
+	assert.Equal(t, first.CreatedAt, first.UpdatedAt)
+	assert.True(t, second.CreatedAt.After(first.CreatedAt))
+
+	created := first.CreatedAt
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: first.ID, Title: "Beach", Content: "sand"}))
+
+	note, err := core.GetNoteByID("Ivan", first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, created, note.CreatedAt)
+	assert.True(t, note.UpdatedAt.After(second.UpdatedAt))
+
+	// Недавно измененная заметка идет первой
+	page, err := core.ListNotes("Ivan", entities.NoteQuery{Sort: entities.NoteSortUpdated, Desc: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{first.ID, second.ID}, []uint64{page.Notes[0].ID, page.Notes[1].ID})
+
+	_, err = core.ListNotes("Ivan", entities.NoteQuery{Sort: entities.NoteSortUpdated, After: &entities.NoteCursor{ID: 1, Title: "Beach"}})
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+func TestSearchNotes(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", &entities.Note{Title: "Beach", Content: "nice ocean view"}))
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", &entities.Note{Title: "Hills", Content: "forest and river"}))
+	assert.Nil(t, core.AddNoteToUserByName("Igor", &entities.Note{Title: "Ocean", Content: "waves"}))
+
+	// Чужие заметки в результаты не попадают
+	matches, err := core.SearchNotes("Ivan", "ocea*")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(matches)) {
+		assert.Equal(t, "Beach", matches[0].Note.Title)
+		assert.Equal(t, "nice "+search.HighlightStart+"ocean"+search.HighlightEnd+" view", matches[0].Snippet)
+	}
+
+	matches, err = core.SearchNotes("Ivan", `"river forest"`)
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+
+	_, err = core.SearchNotes("Ivan", ` "" * `)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = core.SearchNotes("Ivan", strings.Repeat("word ", maxSearchTerms+1))
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+func TestTags(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	// Метки приводятся к нормальной форме, повторы отбрасываются
+	beach := &entities.Note{Title: "Beach", Content: "ocean", Tags: []string{" #Travel ", "summer  Trip", "travel", ""}}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", beach))
+	assert.Equal(t, []string{"summer trip", "travel"}, beach.Tags)
+
+	hills := &entities.Note{Title: "Hills", Content: "forest", Tags: []string{"travel", "hiking"}}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", hills))
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", &entities.Note{Title: "Plain", Content: "no tags"}))
+	assert.Nil(t, core.AddNoteToUserByName("Igor", &entities.Note{Title: "Igor", Content: "other", Tags: []string{"travel"}}))
+
+	titles := func(tags []string, anyTag bool) []string {
+		page, err := core.ListNotes("Ivan", entities.NoteQuery{Tags: tags, AnyTag: anyTag})
+		assert.Nil(t, err)
+
+		result := []string{}
+		for _, n := range page.Notes {
+			result = append(result, n.Title)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"Beach", "Hills"}, titles([]string{"Travel"}, false))
+	assert.Equal(t, []string{"Hills"}, titles([]string{"travel", "hiking"}, false))
+	assert.Equal(t, []string{"Beach", "Hills"}, titles([]string{"summer trip", "hiking"}, true))
+	assert.Empty(t, titles([]string{"summer trip", "hiking"}, false))
+
+	// Без списка меток изменение заметки оставляет прежние метки, пустой список снимает все
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: hills.ID, Title: "Hills", Content: "river"}))
+	assert.Equal(t, []string{"hiking", "travel"}, db.notes[hills.ID].Tags)
+
+	note, err := core.AddNoteTags("Ivan", hills.ID, []string{"Rivers"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hiking", "rivers", "travel"}, note.Tags)
+
+	note, err = core.RemoveNoteTags("Ivan", hills.ID, []string{"hiking", "missing"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rivers", "travel"}, note.Tags)
+
+	_, err = core.AddNoteTags("Igor", hills.ID, []string{"mine"})
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = core.AddNoteTags("Ivan", hills.ID, []string{"a,b"})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = core.AddNoteTags("Ivan", hills.ID, []string{strings.Repeat("x", MaxTagLength+1)})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// Переименование в занятое имя отклоняется, для этого есть объединение
+	assert.ErrorIs(t, core.RenameTag("Ivan", "rivers", "Travel"), ErrConflict)
+	assert.ErrorIs(t, core.RenameTag("Ivan", "missing", "other"), ErrNotFound)
+	assert.Nil(t, core.RenameTag("Ivan", "rivers", "Water"))
+
+	assert.Nil(t, core.MergeTags("Ivan", "summer trip", "travel"))
+	assert.ErrorIs(t, core.MergeTags("Ivan", "travel", "#Travel"), ErrValidation)
+
+	tags, err := core.GetTags("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, []*entities.Tag{
+		{UserID: beach.UserID, Name: "travel", Notes: 2},
+		{UserID: beach.UserID, Name: "water", Notes: 1},
+	}, tags)
+
+	// Метки другого пользователя не затронуты
+	assert.Nil(t, core.DeleteTag("Ivan", "travel"))
+	assert.Equal(t, []string{"Hills"}, titles([]string{"water"}, false))
+	assert.Empty(t, titles([]string{"travel"}, false))
+
+	tags, err = core.GetTags("Igor")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tags))
+
+	_, err = core.ListNotes("Ivan", entities.NoteQuery{Tags: []string{"a,b"}})
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+func TestNotebooks(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	// Заметка без блокнота попадает в блокнот по умолчанию, он создается при необходимости
+	inboxNote := &entities.Note{Title: "First", Content: "text"}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", inboxNote))
+	inbox, err := core.GetNotebook("Ivan", inboxNote.NotebookID)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultNotebookName, inbox.Name)
+
+	work, err := core.CreateNotebook("Ivan", " Work ", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Work", work.Name)
+
+	projects, err := core.CreateNotebook("Ivan", "Projects", &work.ID)
+	assert.Nil(t, err)
+	archive, err := core.CreateNotebook("Ivan", "Archive", &projects.ID)
+	assert.Nil(t, err)
+
+	// Имена уникальны среди соседей без учета регистра
+	_, err = core.CreateNotebook("Ivan", "projects", &work.ID)
+	assert.ErrorIs(t, err, ErrConflict)
+	topProjects, err := core.CreateNotebook("Ivan", "Projects", nil)
+	assert.Nil(t, err)
+	_, err = core.CreateNotebook("Ivan", "  ", nil)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// Чужой блокнот нельзя ни указать родителем, ни использовать для заметки
+	_, err = core.CreateNotebook("Igor", "Mine", &work.ID)
+	assert.ErrorIs(t, err, ErrValidation)
+	assert.ErrorIs(t, core.AddNoteToUserByName("Igor", &entities.Note{Title: "T", Content: "C", NotebookID: work.ID}), ErrValidation)
+	assert.ErrorIs(t, core.RenameNotebook("Igor", work.ID, "Stolen"), ErrForbidden)
+
+	plan := &entities.Note{Title: "Plan", Content: "text", NotebookID: archive.ID}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", plan))
+
+	// Блокнот нельзя вложить в самого себя или в свой потомок
+	assert.ErrorIs(t, core.MoveNotebook("Ivan", work.ID, &work.ID), ErrValidation)
+	assert.ErrorIs(t, core.MoveNotebook("Ivan", work.ID, &archive.ID), ErrValidation)
+	assert.Nil(t, core.MoveNotebook("Ivan", archive.ID, nil))
+	assert.Nil(t, core.MoveNotebook("Ivan", work.ID, &archive.ID))
+
+	tree, err := core.GetNotebookTree("Ivan")
+	assert.Nil(t, err)
+	names := []string{}
+	var walk func([]*entities.NotebookNode)
+	walk = func(nodes []*entities.NotebookNode) {
+		for _, node := range nodes {
+			names = append(names, fmt.Sprintf("%d:%s:%d", node.Depth, node.Notebook.Name, node.Notebook.Notes))
+			walk(node.Children)
+		}
+	}
+	walk(tree)
+	assert.Equal(t, []string{"0:Archive:1", "1:Work:0", "2:Projects:0", "0:Inbox:1", "0:Projects:0"}, names)
+
+	// Глубина вложенности ограничена
+	parent := projects
+	for depth := 3; depth < MaxNotebookDepth; depth++ {
+		parent, err = core.CreateNotebook("Ivan", fmt.Sprintf("Level %d", depth), &parent.ID)
+		assert.Nil(t, err)
+	}
+	_, err = core.CreateNotebook("Ivan", "Too deep", &parent.ID)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// Перенос заметки и переименование
+	assert.Nil(t, core.MoveNote("Ivan", plan.ID, inbox.ID))
+	assert.Equal(t, inbox.ID, db.notes[plan.ID].NotebookID)
+	assert.ErrorIs(t, core.MoveNote("Igor", plan.ID, inbox.ID), ErrForbidden)
+	assert.Nil(t, core.RenameNotebook("Ivan", archive.ID, "Old"))
+
+	// Изменение заметки без блокнота оставляет ее на месте
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: plan.ID, Title: "Plan", Content: "new"}))
+	assert.Equal(t, inbox.ID, db.notes[plan.ID].NotebookID)
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: plan.ID, Title: "Plan", Content: "new", NotebookID: projects.ID}))
+
+	// Удаление блокнота удаляет вложенные блокноты, а заметки отправляет в корзину
+	assert.Nil(t, core.DeleteNotebook("Ivan", archive.ID))
+	_, err = core.GetNotebook("Ivan", projects.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = core.GetNoteByID("Ivan", plan.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = core.GetNoteByID("Ivan", inboxNote.ID)
+	assert.Nil(t, err)
+
+	trash, err := core.GetTrash("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(trash))
+	assert.Equal(t, plan.ID, trash[0].ID)
+	assert.Equal(t, inbox.ID, trash[0].NotebookID)
+
+	// Восстановленная заметка оказывается в блокноте по умолчанию
+	assert.Nil(t, core.RestoreNote("Ivan", plan.ID))
+	restored, err := core.GetNoteByID("Ivan", plan.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, inbox.ID, restored.NotebookID)
+
+	// Вместо последнего блокнота верхнего уровня создается новый блокнот по умолчанию
+	assert.Nil(t, core.DeleteNotebook("Ivan", topProjects.ID))
+	assert.Nil(t, core.DeleteNotebook("Ivan", inbox.ID))
+	notebooks, err := core.GetNotebooks("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notebooks))
+	assert.Equal(t, DefaultNotebookName, notebooks[0].Name)
+	assert.NotEqual(t, inbox.ID, notebooks[0].ID)
+
+	trash, err = core.GetTrash("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(trash))
+	for _, n := range trash {
+		assert.Equal(t, notebooks[0].ID, n.NotebookID)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	core := NewTheCore(db, log, WithClock(func() time.Time { return now }))
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	note := &entities.Note{Title: "Plan", Content: "one\ntwo\nthree", Tags: []string{"work"}}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", note))
+
+	// Каждое изменение сохраняется новой правкой
+	now = now.Add(time.Hour)
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: note.ID, Title: "Plan", Content: "one\n2\nthree"}))
+	now = now.Add(time.Hour)
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: note.ID, Title: "Plan v3", Content: "one\n2\nthree\nfour"}))
+
+	revisions, err := core.GetNoteRevisions("Ivan", note.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(revisions))
+	assert.Equal(t, 3, revisions[0].Number)
+	assert.Equal(t, "Plan v3", revisions[0].Title)
+	assert.Equal(t, "Ivan", revisions[0].Author)
+	assert.Equal(t, now, revisions[0].CreatedAt)
+	assert.Equal(t, "one\ntwo\nthree", revisions[2].Content)
+
+	// Без номеров сравнивается последняя правка с предыдущей
+	d, err := core.DiffNoteRevisions("Ivan", note.ID, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, d.From.Number)
+	assert.Equal(t, 3, d.To.Number)
+	assert.Equal(t, diff.Lines("one\n2\nthree", "one\n2\nthree\nfour"), d.Lines)
+
+	d, err = core.DiffNoteRevisions("Ivan", note.ID, 3, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []diff.Line{
+		{Op: diff.Equal, Text: "one", Old: 1, New: 1},
+		{Op: diff.Delete, Text: "2", Old: 2},
+		{Op: diff.Insert, Text: "two", New: 2},
+		{Op: diff.Equal, Text: "three", Old: 3, New: 3},
+		{Op: diff.Delete, Text: "four", Old: 4},
+	}, d.Lines)
+
+	_, err = core.DiffNoteRevisions("Ivan", note.ID, 1, 7)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = core.DiffNoteRevisions("Ivan", note.ID, -1, 2)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// Чужую историю нельзя ни посмотреть, ни восстановить
+	_, err = core.GetNoteRevisions("Igor", note.ID)
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = core.RestoreNoteRevision("Igor", note.ID, 1)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	// Восстановление создает новую правку, старые остаются как были, метки не меняются
+	restored, err := core.RestoreNoteRevision("Ivan", note.ID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Plan", restored.Title)
+	assert.Equal(t, "one\ntwo\nthree", restored.Content)
+	assert.Equal(t, []string{"work"}, restored.Tags)
+
+	revisions, err = core.GetNoteRevisions("Ivan", note.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(revisions))
+	assert.Equal(t, "one\ntwo\nthree", revisions[0].Content)
+	assert.Equal(t, "Plan v3", revisions[1].Title)
+
+	_, err = core.RestoreNoteRevision("Ivan", note.ID, 9)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNoteVersionConflict(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	note := &entities.Note{Title: "Plan", Content: "one"}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", note))
+	assert.Equal(t, 1, note.Version)
+
+	// Две вкладки открыли первую версию, первое изменение проходит
+	first := &entities.Note{ID: note.ID, Title: "Plan", Content: "from the first tab", Version: 1}
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", first))
+	assert.Equal(t, 2, first.Version)
+
+	// Второе изменение основано на устаревшей версии и отклоняется вместе с текущим состоянием
+	err := core.UpdateNoteByUserName("Ivan", &entities.Note{ID: note.ID, Title: "Plan", Content: "from the second tab", Version: 1})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	var conflict *VersionConflictError
+	if assert.ErrorAs(t, err, &conflict) {
+		assert.Equal(t, 1, conflict.Version)
+		assert.Equal(t, 2, conflict.Current.Version)
+		assert.Equal(t, "from the first tab", conflict.Current.Content)
+	}
+
+	stored, err := core.GetNoteByID("Ivan", note.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "from the first tab", stored.Content)
+
+	// С текущей версией изменение проходит, без версии изменение не проверяется
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: note.ID, Title: "Plan", Content: "merged", Version: 2}))
+	assert.Nil(t, core.UpdateNoteByUserName("Ivan", &entities.Note{ID: note.ID, Title: "Plan", Content: "forced"}))
+
+	stored, err = core.GetNoteByID("Ivan", note.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, stored.Version)
+
+	err = core.UpdateNoteByUserName("Ivan", &entities.Note{ID: note.ID, Title: "Plan", Content: "x", Version: -1})
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+func TestTrash(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	core := NewTheCore(db, log, WithClock(func() time.Time { return now }), WithTrashRetention(7*24*time.Hour))
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	beach := &entities.Note{Title: "Beach", Content: "nice beach and ocean", Tags: []string{"travel"}}
+	hills := &entities.Note{Title: "Hills", Content: "green hills"}
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", beach))
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", hills))
+
+	assert.Nil(t, core.RemoveNoteByUserName("Ivan", beach.ID))
+
+	// Заметка из корзины пропадает из списков, поиска и прямого доступа
+	notes, err := core.GetNotesByUserName("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+	assert.NotNil(t, notes[hills.ID])
+
+	matches, err := core.SearchNotes("Ivan", "ocean")
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+
+	_, err = core.GetNoteByID("Ivan", beach.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	tags, err := core.GetTags("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tags))
+	assert.Equal(t, 0, tags[0].Notes)
+
+	trash, err := core.GetTrash("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(trash))
+	assert.Equal(t, beach.ID, trash[0].ID)
+	assert.Equal(t, now, *trash[0].DeletedAt)
+
+	// Чужую корзину не трогаем, заметку не из корзины не восстанавливаем
+	assert.ErrorIs(t, core.RestoreNote("Igor", beach.ID), ErrForbidden)
+	assert.ErrorIs(t, core.RestoreNote("Ivan", hills.ID), ErrNotFound)
+	assert.ErrorIs(t, core.RestoreNote("Ivan", 42), ErrNotFound)
+
+	assert.Nil(t, core.RestoreNote("Ivan", beach.ID))
+	note, err := core.GetNoteByID("Ivan", beach.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, note.DeletedAt)
+	assert.Equal(t, []string{"travel"}, note.Tags)
+
+	// Очистка корзины удаляет заметки окончательно
+	assert.Nil(t, core.RemoveNoteByUserName("Ivan", beach.ID))
+	n, err := core.EmptyTrash("Igor")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = core.EmptyTrash("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, db.notes[beach.ID])
+	assert.Empty(t, db.revisions[beach.ID])
+
+	// Заметки старше срока хранения удаляются при очистке по расписанию
+	assert.Nil(t, core.RemoveNoteByUserName("Ivan", hills.ID))
+	now = now.Add(7 * 24 * time.Hour)
+	n, err = core.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	now = now.Add(time.Second)
+	n, err = core.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, db.notes)
+
+	// Без срока хранения корзина очищается только вручную
+	keep := NewTheCore(db, log, WithClock(func() time.Time { return now }), WithTrashRetention(0))
+	other := &entities.Note{Title: "Other", Content: "text"}
+	assert.Nil(t, keep.AddNoteToUserByName("Ivan", other))
+	assert.Nil(t, keep.RemoveNoteByUserName("Ivan", other.ID))
+	now = now.Add(365 * 24 * time.Hour)
+	n, err = keep.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.NotNil(t, db.notes[other.ID])
+}
+
+func TestAPIToken(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+
+	plain, token, err := core.CreateAPIToken("Ivan", "ci", []string{ScopeNotesRead}, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, plain, token.Hash)
+	assert.Equal(t, []string{ScopeNotesRead}, token.Scopes)
+
+	user, found, err := core.GetUserByAPIToken(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ivan", user.Name)
+	assert.Equal(t, token.ID, found.ID)
+	assert.True(t, found.HasScope(ScopeNotesRead))
+	assert.False(t, found.HasScope(ScopeNotesWrite))
+	assert.NotNil(t, found.LastUsedAt)
+
+	_, _, err = core.GetUserByAPIToken(plain + "x")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	// Чужой токен отозвать нельзя
+	err = core.RevokeAPIToken("Igor", token.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	tokens, err := core.GetAPITokens("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tokens))
+
+	err = core.RevokeAPIToken("Ivan", token.ID)
+	assert.Nil(t, err)
+
+	_, _, err = core.GetUserByAPIToken(plain)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestAPITokenValidation(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	_, _, err := core.CreateAPIToken("Ivan", "", []string{ScopeNotesRead}, nil)
+	assert.NotNil(t, err)
+
+	_, _, err = core.CreateAPIToken("Ivan", "ci", nil, nil)
+	assert.NotNil(t, err)
+
+	_, _, err = core.CreateAPIToken("Ivan", "ci", []string{"admin"}, nil)
+	assert.NotNil(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, err = core.CreateAPIToken("Ivan", "ci", []string{ScopeNotesRead}, &past)
+	assert.NotNil(t, err)
+	assert.Empty(t, db.tokens)
+}
+
+func TestExpiredAPIToken(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	future := time.Now().Add(time.Hour)
+	plain, token, err := core.CreateAPIToken("Ivan", "ci", []string{ScopeNotesRead}, &future)
+	assert.Nil(t, err)
+
+	past := time.Now().Add(-time.Minute)
+	db.tokens[token.ID].ExpiresAt = &past
+
+	_, _, err = core.GetUserByAPIToken(plain)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestTypedErrors(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	err := core.RegisterUser("Ivan", "Ocean-breeze-7", "Mountain-air-9")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "password_repeat", verr.Fields[0].Field)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	// Пустые заголовок и содержимое возвращаются как две ошибки полей
+	err = core.AddNoteToUserByName("Ivan", &entities.Note{})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, 2, len(verr.Fields))
+
+	err = core.UpdateNoteByUserName("Ivan", &entities.Note{ID: 42, Title: "Beach", Content: "ocean"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = core.GetUserBySession("unknown")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestUsernamePolicy(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	for _, name := range []string{"", "Iv", "Ivan Petrov", "admin", "ADMIN", strings.Repeat("a", 33)} {
+		err := core.RegisterUser(name, "Ocean-breeze-7", "Ocean-breeze-7")
+		assert.ErrorIs(t, err, ErrValidation, name)
+	}
+	assert.Empty(t, db.users)
+
+	assert.Nil(t, core.RegisterUser("Иван_1.0", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	// Правила можно заменить опцией
+	core = NewTheCore(NewFakeDatabase(), log, WithUsernamePolicy(UsernamePolicy{MinLength: 1}))
+	assert.Nil(t, core.RegisterUser("Iv an", "Ocean-breeze-7", "Ocean-breeze-7"))
+}
+
+func TestRegisterExistingUser(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	// Имя занято независимо от регистра
+	err := core.RegisterUser("ivan", "Mountain-air-9", "Mountain-air-9")
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, 1, len(db.users))
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	for _, pass := range []string{"Sh0rt!", "onlylowercase", "Password1", "qwerty123", "IvanIvan1", "ivanivan1"} {
+		err := core.RegisterUser("IvanIvan1", pass, pass)
+		assert.ErrorIs(t, err, ErrValidation, pass)
+	}
+	assert.Empty(t, db.users)
+
+	// Все нарушения возвращаются вместе с ошибкой повтора пароля
+	err := core.RegisterUser("Ivan", "short", "other")
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, 3, len(verr.Fields))
+	assert.Equal(t, "password_repeat", verr.Fields[2].Field)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "correct horse battery 9", "correct horse battery 9"))
+
+	core = NewTheCore(NewFakeDatabase(), log, WithPasswordPolicy(PasswordPolicy{MinLength: 3}))
+	assert.Nil(t, core.RegisterUser("Ivan", "qwerty", "qwerty"))
+}
+
+func TestAuthenticateLocksAccount(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	policy := ratelimit.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := WithClock(func() time.Time { return now })
+	core := NewTheCore(db, log, WithLoginPolicies(policy, ratelimit.DefaultIPPolicy), clock)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	for i := 0; i < 3; i++ {
+		_, err := core.Authenticate("Ivan", "wrong", "10.0.0.1")
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	}
+
+	// Даже верный пароль не принимается, пока действует блокировка, в том числе с другого адреса
+	_, err := core.Authenticate("ivan", "Ocean-breeze-7", "10.0.0.2")
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	var rerr *RateLimitError
+	assert.ErrorAs(t, err, &rerr)
+	assert.Equal(t, time.Minute, rerr.RetryAfter)
+
+	// Блокировка хранится в базе данных, новый экземпляр ядра ее видит
+	core = NewTheCore(db, log, WithLoginPolicies(policy, ratelimit.DefaultIPPolicy), clock)
+	now = now.Add(59 * time.Second)
+	_, err = core.Authenticate("Ivan", "Ocean-breeze-7", "10.0.0.2")
+	assert.ErrorAs(t, err, &rerr)
+	assert.Equal(t, time.Second, rerr.RetryAfter)
+
+	// После окончания блокировки успешный вход сбрасывает счетчик
+	now = now.Add(time.Second)
+
+	user, err := core.Authenticate("Ivan", "Ocean-breeze-7", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Ivan", user.Name)
+	assert.NotContains(t, db.attempts, "user:ivan")
+}
+
+func TestAuthenticateLocksIP(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	policy := ratelimit.Policy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	core := NewTheCore(db, log, WithLoginPolicies(ratelimit.DefaultAccountPolicy, policy))
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	// Перебор разных имен с одного адреса
+	for _, name := range []string{"Igor", "Olga", "Anna", "Petr"} {
+		_, err := core.Authenticate(name, "Ocean-breeze-7", "10.0.0.1")
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	}
+
+	_, err := core.Authenticate("Ivan", "Ocean-breeze-7", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	_, err = core.Authenticate("Ivan", "Ocean-breeze-7", "10.0.0.2")
+	assert.Nil(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+
+	current, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	other, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+
+	err = core.ChangePassword("Ivan", "wrong", "Mountain-air-9", "Mountain-air-9", current)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	err = core.ChangePassword("Ivan", "Ocean-breeze-7", "qwerty", "qwerty", current)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	err = core.ChangePassword("Ivan", "Ocean-breeze-7", "Mountain-air-9", "Mountain-air-9", current)
+	assert.Nil(t, err)
+
+	isValid, err := core.IsValidUserCredentials("Ivan", "Mountain-air-9")
+	assert.Nil(t, err)
+	assert.True(t, isValid)
+
+	// Текущая сессия остается, остальные завершаются
+	_, err = core.GetUserBySession(current)
+	assert.Nil(t, err)
+	_, err = core.GetUserBySession(other)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
 	core := NewTheCore(db, log)
-	res, err := core.GetAllNotes()
 
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+	assert.Nil(t, core.AddNoteToUserByName("Ivan", &entities.Note{Title: "Beach", Content: "ocean"}))
+	assert.Nil(t, core.AddNoteToUserByName("Igor", &entities.Note{Title: "Hills", Content: "forest"}))
+
+	token, _, err := core.CreateSession("Ivan")
 	assert.Nil(t, err)
-	assert.Equal(t, ns, res)
+
+	err = core.DeleteAccount("Ivan", "wrong")
+	assert.ErrorIs(t, err, ErrValidation)
+	assert.Equal(t, 2, len(db.users))
+
+	assert.Nil(t, core.DeleteAccount("Ivan", "Ocean-breeze-7"))
+
+	_, err = core.GetUserByName("Ivan")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = core.GetUserBySession(token)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	// Заметки других пользователей остаются
+	assert.Equal(t, 1, len(db.notes))
+	notes, err := core.GetNotesByUserName("Igor")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+}
+
+type FakeMailer struct {
+	messages []mail.Message
+}
+
+func (m *FakeMailer) Send(msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+
+	return nil
 }
 
-func TestRemoveNoteByID(t *testing.T) {
+func resetTokenFromMail(t *testing.T, msg mail.Message) string {
+	// Достаем токен из ссылки в письме
+	_, rest, found := strings.Cut(msg.Body, "/password/reset?token=")
+	assert.True(t, found)
+
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+func TestPasswordReset(t *testing.T) {
 	db := NewFakeDatabase()
 	log := logrus.New()
-	core := NewTheCore(db, log)
+	mailer := &FakeMailer{}
+	core := NewTheCore(db, log, WithMailer(mailer), WithPublicURL("https://notes.example.com/"))
 
-	u := entities.User{
-		ID:       3,
-		Name:     "Ivan",
-		Password: "123",
-	}
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.ErrorIs(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "not an email"), ErrValidation)
+	assert.Nil(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "ivan@example.com"))
 
-	n := entities.Note{
-		ID:      1,
-		Title:   "Beach",
-		Content: "nice beach and ocean",
-		UserID:  u.ID,
-	}
+	session, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
 
-	db.notes = map[uint64]*entities.Note{
-		n.ID: &n,
-	}
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
-	}
+	// Для неизвестного адреса ошибки нет, но и письма тоже
+	assert.Nil(t, core.RequestPasswordReset("igor@example.com"))
+	assert.Empty(t, mailer.messages)
+
+	assert.Nil(t, core.RequestPasswordReset("IVAN@example.com"))
+	assert.Equal(t, 1, len(mailer.messages))
+	assert.Equal(t, "ivan@example.com", mailer.messages[0].To)
+	assert.Contains(t, mailer.messages[0].Body, "https://notes.example.com/password/reset?token=")
+
+	token := resetTokenFromMail(t, mailer.messages[0])
+	assert.NotContains(t, db.resets, token)
 
-	err := core.RemoveNoteByID(n.ID)
+	err = core.ResetPassword(token+"x", "Mountain-air-9", "Mountain-air-9")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	err = core.ResetPassword(token, "qwerty", "qwerty")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	assert.Nil(t, core.ResetPassword(token, "Mountain-air-9", "Mountain-air-9"))
+
+	isValid, err := core.IsValidUserCredentials("Ivan", "Mountain-air-9")
 	assert.Nil(t, err)
-	assert.Empty(t, db.notes)
+	assert.True(t, isValid)
 
+	// Токен одноразовый, старые сессии завершены
+	err = core.ResetPassword(token, "Another-pass-5", "Another-pass-5")
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = core.GetUserBySession(session)
+	assert.ErrorIs(t, err, ErrUnauthorized)
 }
 
-func TestAddNoteToUserByName(t *testing.T) {
+func TestExpiredPasswordReset(t *testing.T) {
 	db := NewFakeDatabase()
 	log := logrus.New()
+	mailer := &FakeMailer{}
+	core := NewTheCore(db, log, WithMailer(mailer))
 
-	u := entities.User{
-		ID:       0,
-		Name:     "Ivan",
-		Password: "123",
-	}
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "ivan@example.com"))
+	assert.Nil(t, core.RequestPasswordReset("ivan@example.com"))
 
-	n := entities.Note{
-		ID:      1,
-		Title:   "Beach",
-		Content: "nice beach and ocean",
-		UserID:  u.ID,
+	for _, r := range db.resets {
+		r.ExpiresAt = time.Now().Add(-time.Minute)
 	}
 
-	ns := map[uint64]*entities.Note{
-		0: {
-			ID:      0,
-			Title:   "Tree",
-			Content: "One,two",
-			UserID:  u.ID,
-		},
-	}
+	err := core.ResetPassword(resetTokenFromMail(t, mailer.messages[0]), "Mountain-air-9", "Mountain-air-9")
+	assert.ErrorIs(t, err, ErrValidation)
+}
 
-	db.notes = ns
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
-	}
-	*db.nextNoteID = 1
-	*db.nextUserID = 1
+// База, отдающая токен восстановления, прочитанный до параллельного запроса
+type staleResetDatabase struct {
+	*FakeDatabase
+	stale entities.PasswordReset
+}
 
-	core := NewTheCore(db, log)
+func (s staleResetDatabase) GetPasswordReset(id string) (*entities.PasswordReset, error) {
+	copied := s.stale
+	return &copied, nil
+}
 
-	expectedNotes := map[uint64]*entities.Note{
-		0: {
-			ID:      0,
-			Title:   "Tree",
-			Content: "One,two",
-			UserID:  u.ID,
-		},
-		1: {
-			ID:      1,
-			Title:   "Beach",
-			Content: "nice beach and ocean",
-			UserID:  u.ID,
-		},
-	}
+func TestPasswordResetConcurrentUse(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	mailer := &FakeMailer{}
+	core := NewTheCore(db, log, WithMailer(mailer))
 
-	err := core.AddNoteToUserByName(u.Name, &n)
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "ivan@example.com"))
+	assert.Nil(t, core.RequestPasswordReset("ivan@example.com"))
+	token := resetTokenFromMail(t, mailer.messages[0])
+
+	// Оба запроса нашли токен до того, как любой из них сменил пароль
+	racing := NewTheCore(staleResetDatabase{FakeDatabase: db, stale: *db.resets[hashToken(token)]}, log)
 
+	assert.Nil(t, racing.ResetPassword(token, "Mountain-air-9", "Mountain-air-9"))
+	err := racing.ResetPassword(token, "Another-pass-5", "Another-pass-5")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	isValid, err := core.IsValidUserCredentials("Ivan", "Mountain-air-9")
 	assert.Nil(t, err)
-	assert.Equal(t, expectedNotes, db.notes)
+	assert.True(t, isValid)
 }
 
-func TestGetNotesByUserName(t *testing.T) {
+func TestPasswordResetRequestsLimited(t *testing.T) {
 	db := NewFakeDatabase()
 	log := logrus.New()
+	mailer := &FakeMailer{}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	core := NewTheCore(db, log, WithMailer(mailer), WithClock(func() time.Time { return now }))
 
-	u := entities.User{
-		ID:       0,
-		Name:     "Ivan",
-		Password: "123",
-	}
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "ivan@example.com"))
 
-	u1 := entities.User{
-		ID:       1,
-		Name:     "Igor",
-		Password: "01876",
+	// Несколько писем подряд можно, дальше адрес ждет, и регистр адреса не помогает
+	for i := 0; i <= DefaultResetPolicy.FreeAttempts; i++ {
+		assert.Nil(t, core.RequestPasswordReset("ivan@example.com"))
 	}
+	err := core.RequestPasswordReset("IVAN@example.com")
+	assert.ErrorIs(t, err, ErrRateLimited)
 
-	ns := map[uint64]*entities.Note{
-		0: {
-			ID:      0,
-			Title:   "Tree",
-			Content: "One,two",
-			UserID:  u.ID,
-		},
-		1: {
-			ID:      1,
-			Title:   "Beach",
-			Content: "nice beach and ocean",
-			UserID:  u.ID,
-		},
-		2: {
-			ID:      2,
-			Title:   "Algorithm",
-			Content: "Binary search, selection sort",
-			UserID:  u1.ID,
-		},
-		3: {
-			ID:      3,
-			Title:   "Algorithm",
-			Content: "Recursion, hash tables",
-			UserID:  u1.ID,
-		},
+	var rerr *RateLimitError
+	if assert.ErrorAs(t, err, &rerr) {
+		assert.Equal(t, DefaultResetPolicy.BaseDelay, rerr.RetryAfter)
 	}
+	assert.Equal(t, DefaultResetPolicy.FreeAttempts+1, len(mailer.messages))
 
-	db.notes = ns
-	db.users = map[uint64]*entities.User{
-		u.ID:  &u,
-		u1.ID: &u1,
+	// Неизвестный адрес ограничивается так же, чтобы по ответу нельзя было его отличить
+	for i := 0; i <= DefaultResetPolicy.FreeAttempts; i++ {
+		assert.Nil(t, core.RequestPasswordReset("igor@example.com"))
 	}
-	*db.nextNoteID = 4
-	*db.nextUserID = 2
+	assert.ErrorIs(t, core.RequestPasswordReset("igor@example.com"), ErrRateLimited)
+
+	now = now.Add(DefaultResetPolicy.BaseDelay)
+	assert.Nil(t, core.RequestPasswordReset("ivan@example.com"))
+	assert.Equal(t, DefaultResetPolicy.FreeAttempts+2, len(mailer.messages))
+}
+
+func TestUpdateEmailConflict(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
 	core := NewTheCore(db, log)
 
-	expectedNotes := map[uint64]*entities.Note{
-		0: {
-			ID:      0,
-			Title:   "Tree",
-			Content: "One,two",
-			UserID:  u.ID,
-		},
-		1: {
-			ID:      1,
-			Title:   "Beach",
-			Content: "nice beach and ocean",
-			UserID:  u.ID,
-		},
-	}
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.RegisterUser("Igor", "Mountain-air-9", "Mountain-air-9"))
+	assert.Nil(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "ivan@example.com"))
 
-	expectedNotes1 := map[uint64]*entities.Note{
-		2: {
-			ID:      2,
-			Title:   "Algorithm",
-			Content: "Binary search, selection sort",
-			UserID:  u1.ID,
-		},
-		3: {
-			ID:      3,
-			Title:   "Algorithm",
-			Content: "Recursion, hash tables",
-			UserID:  u1.ID,
-		},
-	}
-	res, err := core.GetNotesByUserName(u.Name)
+	err := core.UpdateEmail("Igor", "Mountain-air-9", "Ivan@Example.com")
+	assert.ErrorIs(t, err, ErrConflict)
+}
+
+func TestUpdateEmailRequiresPassword(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	assert.Nil(t, core.UpdateEmail("Ivan", "Ocean-breeze-7", "ivan@example.com"))
+
+	// Без верного пароля адрес для сброса пароля не меняется
+	err := core.UpdateEmail("Ivan", "wrong", "attacker@example.com")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "password", verr.Fields[0].Field)
+
+	user, err := core.GetUserByName("Ivan")
 	assert.Nil(t, err)
-	assert.Equal(t, expectedNotes, res)
-	res1, err := core.GetNotesByUserName(u1.Name)
+	assert.Equal(t, "ivan@example.com", user.Email)
+	assert.Equal(t, 1, db.attempts["user:ivan"].Failures)
+}
+
+func enrollTOTP(t *testing.T, core *TheCore, username string) (string, []string) {
+	// Включаем второй фактор и возвращаем секрет и коды восстановления
+	secret, uri, err := core.BeginTOTPEnrollment(username)
 	assert.Nil(t, err)
-	assert.Equal(t, expectedNotes1, res1)
+	assert.Contains(t, uri, "secret="+secret)
 
+	_, err = core.ConfirmTOTPEnrollment(username, "000000x")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	assert.Nil(t, err)
+
+	codes, err := core.ConfirmTOTPEnrollment(username, code)
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount, len(codes))
+
+	return secret, codes
 }
 
-func TestAddNoteToUserByNameEmptyTitleAndContent(t *testing.T) {
+func TestTOTPLogin(t *testing.T) {
 	db := NewFakeDatabase()
 	log := logrus.New()
+	core := NewTheCore(db, log)
 
-	u := entities.User{
-		ID:       0,
-		Name:     "Ivan",
-		Password: "123",
-	}
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	secret, _ := enrollTOTP(t, core, "Ivan")
 
-	db.users = map[uint64]*entities.User{
-		u.ID: &u,
-	}
+	enabled, left, err := core.GetTOTPStatus("Ivan")
+	assert.Nil(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, recoveryCodeCount, left)
+
+	_, _, err = core.BeginTOTPEnrollment("Ivan")
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// После пароля сессия ждет второй фактор и не дает доступа
+	pending, session, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	assert.True(t, session.MFAPending)
+	_, err = core.GetUserBySession(pending)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	_, _, err = core.CompleteMFA(pending, "123")
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// Код из окна, уже использованного при включении, повторно не принимается
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	assert.Nil(t, err)
+	_, _, err = core.CompleteMFA(pending, code)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	next, err := totp.Code(secret, totp.Counter(time.Now())+1)
+	assert.Nil(t, err)
+	token, session, err := core.CompleteMFA(pending, next)
+	assert.Nil(t, err)
+	assert.False(t, session.MFAPending)
+
+	user, err := core.GetUserBySession(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ivan", user.Name)
+
+	// Промежуточная сессия больше не действует
+	_, _, err = core.CompleteMFA(pending, next)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+// База, отдающая настройки второго фактора, прочитанные до параллельного входа
+type staleTOTPDatabase struct {
+	*FakeDatabase
+	stale entities.TOTP
+}
+
+func (s staleTOTPDatabase) GetTOTP(userID uint64) (*entities.TOTP, error) {
+	copied := s.stale
+	return &copied, nil
+}
 
+func TestTOTPConcurrentReplay(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
 	core := NewTheCore(db, log)
 
-	note := entities.Note{
-		ID:      0,
-		Title:   "Member of society",
-		Content: "",
-		UserID:  u.ID,
-	}
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	secret, _ := enrollTOTP(t, core, "Ivan")
 
-	err := core.AddNoteToUserByName(u.Name, &note)
+	// Оба входа прочитали счетчик до того, как любой из них его сдвинул
+	racing := NewTheCore(staleTOTPDatabase{FakeDatabase: db, stale: *db.totps[0]}, log)
 
-	assert.NotNil(t, err)
+	first, _, err := racing.CreateSession("Ivan")
+	assert.Nil(t, err)
+	second, _, err := racing.CreateSession("Ivan")
+	assert.Nil(t, err)
 
-	note = entities.Note{
-		ID:      1,
-		Title:   "",
-		Content: "В testify есть два основных пакета с проверками — assert и require",
-		UserID:  u.ID,
-	}
+	next, err := totp.Code(secret, totp.Counter(time.Now())+1)
+	assert.Nil(t, err)
 
-	err = core.AddNoteToUserByName(u.Name, &note)
+	_, _, err = racing.CompleteMFA(first, next)
+	assert.Nil(t, err)
+	_, _, err = racing.CompleteMFA(second, next)
+	assert.ErrorIs(t, err, ErrValidation)
+}
 
-	assert.NotNil(t, err)
+func TestTOTPRecoveryCode(t *testing.T) {
+	db := NewFakeDatabase()
+	log := logrus.New()
+	core := NewTheCore(db, log)
+
+	assert.Nil(t, core.RegisterUser("Ivan", "Ocean-breeze-7", "Ocean-breeze-7"))
+	_, codes := enrollTOTP(t, core, "Ivan")
+	assert.Equal(t, recoveryCodeCount, len(codes))
+	assert.Len(t, codes[0], 11)
+
+	pending, _, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+
+	// Код восстановления принимается без учета регистра и дефиса, но только один раз
+	_, _, err = core.CompleteMFA(pending, strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")))
+	assert.Nil(t, err)
+
+	pending, _, err = core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	_, _, err = core.CompleteMFA(pending, codes[0])
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, left, err := core.GetTOTPStatus("Ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount-1, left)
+
+	// После отключения вход снова в один шаг
+	assert.ErrorIs(t, core.DisableTOTP("Ivan", "wrong"), ErrValidation)
+	assert.Nil(t, core.DisableTOTP("Ivan", "Ocean-breeze-7"))
+
+	token, session, err := core.CreateSession("Ivan")
+	assert.Nil(t, err)
+	assert.False(t, session.MFAPending)
+	_, err = core.GetUserBySession(token)
+	assert.Nil(t, err)
 }
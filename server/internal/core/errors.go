@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"strings"
+	"time"
+)
+
+var (
+	// Пользователь не аутентифицирован или сессия недействительна
+	ErrUnauthorized = errors.New("unauthorized")
+	// Запрошенная запись не существует
+	ErrNotFound = errors.New("not found")
+	// Запись принадлежит другому пользователю
+	ErrForbidden = errors.New("forbidden")
+	// Запись конфликтует с уже существующей
+	ErrConflict = errors.New("conflict")
+	// Входные данные не прошли проверку, подробности в ValidationError
+	ErrValidation = errors.New("validation failed")
+	// Слишком много неудачных попыток, подробности в RateLimitError
+	ErrRateLimited = errors.New("too many attempts")
+)
+
+// Ошибка в конкретном поле входных данных
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Ошибка проверки входных данных со списком полей, errors.Is(err, ErrValidation) == true
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func NewValidationError(field, message string) *ValidationError {
+	return &ValidationError{
+		Fields: []FieldError{{Field: field, Message: message}},
+	}
+}
+
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Возвращает nil, если ни одно поле не добавлено
+func (e *ValidationError) OrNil() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+
+	return ErrValidation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Попытка отклонена до окончания блокировки, errors.Is(err, ErrRateLimited) == true
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Заметку изменили после того, как ее прочитали, errors.Is(err, ErrConflict) == true.
+// В Current сохраненное состояние, чтобы показать его рядом с отклоненным
+type VersionConflictError struct {
+	Current *entities.Note
+	// Версия, на основе которой делалось отклоненное изменение
+	Version int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s: note %d was changed since version %d, current version is %d",
+		ErrConflict, e.Current.ID, e.Version, e.Current.Version)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+func convertError(err error) error {
+	// Ошибки хранилища превращаем в ошибки ядра
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, database.ErrAlreadyExists):
+		return ErrConflict
+	}
+
+	return err
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/ratelimit"
+	"strings"
+	"time"
+)
+
+// Правила блокировки входа по имени пользователя и по IP адресу клиента
+func WithLoginPolicies(account, ip ratelimit.Policy) Option {
+	return func(c *TheCore) {
+		c.accountPolicy = account
+		c.ipPolicy = ip
+	}
+}
+
+func (c TheCore) Authenticate(username, pass, ip string) (*entities.User, error) {
+	// Пока действует блокировка, пароль даже не проверяем
+	account := strings.ToLower(username)
+	wait, err := c.reserveLogin(account, ip)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	} else if wait > 0 {
+		c.logger.Infof("login attempt for %s from %s rejected, locked for %s", username, ip, wait.Round(time.Second))
+		return nil, &RateLimitError{RetryAfter: wait}
+	}
+
+	isValid, err := c.IsValidUserCredentials(username, pass)
+	if err != nil {
+		c.releaseLogin(account, ip)
+		return nil, err
+	}
+
+	if !isValid {
+		// Считаем ошибку и для учетной записи, и для адреса
+		if err = c.accountLimiter.Fail(account); err != nil {
+			c.logger.Error(err)
+		}
+		if err = c.ipLimiter.Fail(ip); err != nil {
+			c.logger.Error(err)
+		}
+
+		return nil, fmt.Errorf("%w: invalid credentials", ErrUnauthorized)
+	}
+
+	c.releaseLogin(account, ip)
+
+	// Счетчик адреса не сбрасываем, иначе вход в свой аккаунт обнулял бы перебор чужих
+	if err = c.accountLimiter.Reset(account); err != nil {
+		c.logger.Error(err)
+	}
+
+	return c.GetUserByName(username)
+}
+
+func (c TheCore) reserveLogin(account, ip string) (time.Duration, error) {
+	// Попытку резервируем сразу в обоих счетчиках, иначе параллельные запросы
+	// проходили бы проверку раньше, чем засчитаны ошибки предыдущих
+	accountWait, err := c.accountLimiter.Reserve(account)
+	if err != nil {
+		return 0, err
+	}
+
+	if accountWait > 0 {
+		// Ждать нужно до конца самой длинной из блокировок
+		ipWait, err := c.ipLimiter.Check(ip)
+		if err != nil {
+			return 0, err
+		}
+
+		if ipWait > accountWait {
+			return ipWait, nil
+		}
+
+		return accountWait, nil
+	}
+
+	ipWait, err := c.ipLimiter.Reserve(ip)
+	if err != nil || ipWait > 0 {
+		c.accountLimiter.Release(account)
+		return ipWait, err
+	}
+
+	return 0, nil
+}
+
+func (c TheCore) releaseLogin(account, ip string) {
+	c.accountLimiter.Release(account)
+	c.ipLimiter.Release(ip)
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/totp"
+	"strings"
+	"time"
+)
+
+const (
+	// Сколько времени есть на ввод кода после пароля
+	MFAPendingTTL = 5 * time.Minute
+	// Название сервиса в приложении-аутентификаторе
+	totpIssuer = "Notes"
+	// Допуск на расхождение часов: одно окно в каждую сторону
+	totpSkew = 1
+	// Количество одноразовых кодов восстановления
+	recoveryCodeCount = 10
+)
+
+func (c TheCore) BeginTOTPEnrollment(username string) (string, string, error) {
+	// Новый секрет сохраняем выключенным, он заработает после подтверждения кодом
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return "", "", convertError(err)
+	}
+
+	existing, err := c.db.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		c.logger.Error(err)
+		return "", "", err
+	}
+
+	if existing != nil {
+		if existing.Enabled {
+			return "", "", fmt.Errorf("%w: two-factor authentication is already enabled", ErrConflict)
+		}
+
+		// Повторное открытие страницы показывает тот же секрет, который уже мог быть отсканирован
+		return existing.Secret, totp.URI(totpIssuer, user.Name, existing.Secret), nil
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.logger.Error(err)
+		return "", "", err
+	}
+
+	err = c.db.SaveTOTP(&entities.TOTP{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: c.now(),
+	})
+	if err != nil {
+		c.logger.Error(err)
+		return "", "", convertError(err)
+	}
+
+	return secret, totp.URI(totpIssuer, user.Name, secret), nil
+}
+
+func (c TheCore) ConfirmTOTPEnrollment(username, code string) ([]string, error) {
+	// Включаем второй фактор, только если пользователь смог ввести код из приложения
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	t, err := c.db.GetTOTP(user.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, NewValidationError("code", "enrollment has not been started")
+	} else if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	if t.Enabled {
+		return nil, fmt.Errorf("%w: two-factor authentication is already enabled", ErrConflict)
+	}
+
+	counter, ok := totp.Validate(t.Secret, code, c.now(), totpSkew)
+	if !ok {
+		return nil, NewValidationError("code", "is incorrect")
+	}
+
+	t.Enabled = true
+	t.LastCounter = counter
+	if err = c.db.SaveTOTP(t); err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	codes, err := c.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger.Infof("two-factor authentication enabled for user %d", user.ID)
+	return codes, nil
+}
+
+func (c TheCore) DisableTOTP(username, pass string) error {
+	// Отключение второго фактора требует пароль
+	user, err := c.reauthenticate(username, pass, "password")
+	if err != nil {
+		return err
+	}
+
+	if err = c.db.RemoveTOTP(user.ID); err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	c.logger.Infof("two-factor authentication disabled for user %d", user.ID)
+	return nil
+}
+
+func (c TheCore) GetTOTPStatus(username string) (bool, int, error) {
+	// Включен ли второй фактор и сколько кодов восстановления осталось
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return false, 0, convertError(err)
+	}
+
+	enabled, err := c.totpEnabled(user.ID)
+	if err != nil || !enabled {
+		return false, 0, err
+	}
+
+	count, err := c.db.CountRecoveryCodes(user.ID)
+	return true, count, err
+}
+
+func (c TheCore) CompleteMFA(pendingToken, code string) (string, *entities.Session, error) {
+	// Второй шаг входа: код из приложения или код восстановления
+	session, err := c.getSession(pendingToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !session.MFAPending {
+		return "", nil, ErrUnauthorized
+	}
+
+	user, err := c.sessionUser(session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Подбор кода ограничивается той же блокировкой, что и подбор пароля
+	account := strings.ToLower(user.Name)
+	wait, err := c.accountLimiter.Reserve(account)
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, err
+	} else if wait > 0 {
+		return "", nil, &RateLimitError{RetryAfter: wait}
+	}
+
+	ok, err := c.verifySecondFactor(user.ID, code)
+	if err != nil {
+		c.accountLimiter.Release(account)
+		return "", nil, err
+	}
+
+	if !ok {
+		if err = c.accountLimiter.Fail(account); err != nil {
+			c.logger.Error(err)
+		}
+
+		return "", nil, NewValidationError("code", "is incorrect")
+	}
+
+	c.accountLimiter.Release(account)
+
+	// Промежуточную сессию меняем на полноценную с новым идентификатором
+	if err = c.db.RemoveSessionByID(session.ID); err != nil {
+		c.logger.Error(err)
+		return "", nil, convertError(err)
+	}
+
+	return c.addSession(user.ID, false)
+}
+
+func (c TheCore) verifySecondFactor(userID uint64, code string) (bool, error) {
+	// Сначала пробуем код из приложения, потом код восстановления
+	t, err := c.db.GetTOTP(userID)
+	if err != nil {
+		c.logger.Error(err)
+		return false, convertError(err)
+	}
+
+	// Код из уже использованного окна не принимаем, чтобы его нельзя было повторить.
+	// Окно сдвигает база, если его не успел занять параллельный вход с тем же кодом
+	if counter, ok := totp.Validate(t.Secret, code, c.now(), totpSkew); ok && counter > t.LastCounter {
+		err = c.db.AdvanceTOTPCounter(userID, counter)
+		if errors.Is(err, database.ErrNotFound) {
+			return false, nil
+		} else if err != nil {
+			c.logger.Error(err)
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	err = c.db.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		c.logger.Error(err)
+		return false, err
+	}
+
+	c.logger.Infof("recovery code used by user %d", userID)
+	return true, nil
+}
+
+func (c TheCore) totpEnabled(userID uint64) (bool, error) {
+	t, err := c.db.GetTOTP(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return t.Enabled, nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (c TheCore) newRecoveryCodes(userID uint64) ([]string, error) {
+	// Коды показываем один раз, в базе храним хеши
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			c.logger.Error(err)
+			return nil, err
+		}
+
+		// Шесть байт дают десять символов base32, разбиваем их на две группы для удобства чтения
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+
+	if err := c.db.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	// Регистр, пробелы и дефисы при вводе не важны
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,386 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Блокнот, в который попадают заметки без явно указанного блокнота
+	DefaultNotebookName = "Inbox"
+	// Наибольшая длина имени блокнота в символах
+	MaxNotebookNameLength = 64
+	// Сколько уровней вложенности допускается, считая верхний
+	MaxNotebookDepth = 8
+)
+
+func validateNotebookName(name string) (string, error) {
+	// Имя без лишних пробелов по краям, пустое и слишком длинное не допускаем
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", NewValidationError("name", "must not be empty")
+	case utf8.RuneCountInString(name) > MaxNotebookNameLength:
+		return "", NewValidationError("name", fmt.Sprintf("must be at most %d characters long", MaxNotebookNameLength))
+	}
+
+	return name, nil
+}
+
+func (c TheCore) GetNotebooks(username string) ([]*entities.Notebook, error) {
+	// Все блокноты пользователя списком по имени
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	notebooks, err := c.db.GetNotebooksByUserID(user.ID)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	return notebooks, nil
+}
+
+func (c TheCore) GetNotebookTree(username string) ([]*entities.NotebookNode, error) {
+	// Блокноты верхнего уровня с вложенными, на каждом уровне по имени
+	notebooks, err := c.GetNotebooks(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildNotebookTree(notebooks), nil
+}
+
+func buildNotebookTree(notebooks []*entities.Notebook) []*entities.NotebookNode {
+	nodes := make(map[uint64]*entities.NotebookNode, len(notebooks))
+	for _, nb := range notebooks {
+		nodes[nb.ID] = &entities.NotebookNode{Notebook: nb}
+	}
+
+	roots := []*entities.NotebookNode{}
+	for _, nb := range notebooks {
+		node := nodes[nb.ID]
+		if parent, ok := parentNode(nodes, nb); ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	var setDepth func([]*entities.NotebookNode, int)
+	setDepth = func(level []*entities.NotebookNode, depth int) {
+		for _, node := range level {
+			node.Depth = depth
+			setDepth(node.Children, depth+1)
+		}
+	}
+	setDepth(roots, 0)
+
+	return roots
+}
+
+func parentNode(nodes map[uint64]*entities.NotebookNode, nb *entities.Notebook) (*entities.NotebookNode, bool) {
+	if nb.ParentID == nil {
+		return nil, false
+	}
+
+	parent, ok := nodes[*nb.ParentID]
+	return parent, ok
+}
+
+func (c TheCore) GetNotebook(username string, id uint64) (*entities.Notebook, error) {
+	// Блокнот доступен только владельцу
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	notebook, err := c.db.GetNotebookByID(id)
+	if err != nil {
+		c.logger.Errorf("notebook %d: %v", id, err)
+		return nil, convertError(err)
+	}
+
+	if notebook.UserID != user.ID {
+		c.logger.Errorf("user %d is not allowed to access notebook %d", user.ID, id)
+		return nil, ErrForbidden
+	}
+
+	return notebook, nil
+}
+
+func (c TheCore) CreateNotebook(username, name string, parentID *uint64) (*entities.Notebook, error) {
+	name, err := validateNotebookName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	// Родитель должен принадлежать пользователю, а глубина не превышать предел
+	if parentID != nil {
+		notebooks, err := c.db.GetNotebooksByUserID(user.ID)
+		if err != nil {
+			c.logger.Error(err)
+			return nil, convertError(err)
+		}
+
+		tree := newNotebookIndex(notebooks)
+		if _, ok := tree[*parentID]; !ok {
+			return nil, NewValidationError("parent_id", "notebook does not exist")
+		}
+
+		if tree.depth(*parentID)+1 >= MaxNotebookDepth {
+			return nil, NewValidationError("parent_id", fmt.Sprintf("notebooks can be nested at most %d levels deep", MaxNotebookDepth))
+		}
+	}
+
+	notebook := &entities.Notebook{
+		UserID:    user.ID,
+		ParentID:  parentID,
+		Name:      name,
+		CreatedAt: c.now(),
+	}
+
+	if _, err = c.db.AddNotebook(notebook); err != nil {
+		return nil, c.notebookError(err, name)
+	}
+
+	return notebook, nil
+}
+
+func (c TheCore) RenameNotebook(username string, id uint64, name string) error {
+	name, err := validateNotebookName(name)
+	if err != nil {
+		return err
+	}
+
+	notebook, err := c.GetNotebook(username, id)
+	if err != nil {
+		return err
+	}
+
+	notebook.Name = name
+	if err = c.db.UpdateNotebook(notebook); err != nil {
+		return c.notebookError(err, name)
+	}
+
+	return nil
+}
+
+func (c TheCore) MoveNotebook(username string, id uint64, parentID *uint64) error {
+	// Переносим блокнот со всем содержимым, nil переносит на верхний уровень
+	notebook, err := c.GetNotebook(username, id)
+	if err != nil {
+		return err
+	}
+
+	if parentID != nil {
+		notebooks, err := c.db.GetNotebooksByUserID(notebook.UserID)
+		if err != nil {
+			c.logger.Error(err)
+			return convertError(err)
+		}
+
+		tree := newNotebookIndex(notebooks)
+		if _, ok := tree[*parentID]; !ok {
+			return NewValidationError("parent_id", "notebook does not exist")
+		}
+
+		// Блокнот нельзя вложить в самого себя или в свой вложенный блокнот, иначе получится цикл
+		if tree.isAncestor(id, *parentID) {
+			return NewValidationError("parent_id", "cannot move a notebook into itself or its descendant")
+		}
+
+		if tree.depth(*parentID)+1+tree.height(id) >= MaxNotebookDepth {
+			return NewValidationError("parent_id", fmt.Sprintf("notebooks can be nested at most %d levels deep", MaxNotebookDepth))
+		}
+	}
+
+	notebook.ParentID = parentID
+	if err = c.db.UpdateNotebook(notebook); err != nil {
+		return c.notebookError(err, notebook.Name)
+	}
+
+	return nil
+}
+
+func (c TheCore) DeleteNotebook(username string, id uint64) error {
+	// Удаляем блокнот вместе с вложенными блокнотами, их заметки попадают в корзину
+	notebook, err := c.GetNotebook(username, id)
+	if err != nil {
+		return err
+	}
+
+	// Заметки переносим в блокнот по умолчанию вне удаляемого дерева. Вложенный блокнот
+	// не бывает верхнего уровня, поэтому достаточно не брать сам удаляемый
+	notebooks, err := c.db.GetNotebooksByUserID(notebook.UserID)
+	if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	inbox := &entities.Notebook{UserID: notebook.UserID}
+	for _, nb := range notebooks {
+		if nb.ParentID == nil && nb.ID != id && (inbox.ID == 0 || nb.ID < inbox.ID) {
+			inbox.ID = nb.ID
+		}
+	}
+
+	// Удаляется последний блокнот верхнего уровня, вместо него база создаст новый
+	if inbox.ID == 0 {
+		inbox.Name = DefaultNotebookName
+		inbox.CreatedAt = c.now()
+	}
+
+	if err = c.db.RemoveNotebookByID(id, inbox, c.now()); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+func (c TheCore) MoveNote(username string, noteID, notebookID uint64) error {
+	// Переносим заметку в другой блокнот того же пользователя
+	note, err := c.GetNoteByID(username, noteID)
+	if err != nil {
+		return err
+	}
+
+	if notebookID, err = c.noteNotebook(note.UserID, notebookID); err != nil {
+		return err
+	}
+
+	if err = c.db.MoveNote(noteID, notebookID); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+func (c TheCore) noteNotebook(userID, notebookID uint64) (uint64, error) {
+	// Блокнот для заметки: указанный, если он принадлежит пользователю, иначе блокнот по умолчанию
+	if notebookID == 0 {
+		return c.defaultNotebook(userID)
+	}
+
+	notebook, err := c.db.GetNotebookByID(notebookID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && notebook.UserID != userID) {
+		return 0, NewValidationError("notebook_id", "notebook does not exist")
+	} else if err != nil {
+		c.logger.Error(err)
+		return 0, convertError(err)
+	}
+
+	return notebook.ID, nil
+}
+
+func (c TheCore) defaultNotebook(userID uint64) (uint64, error) {
+	// Первый созданный блокнот верхнего уровня, если блокнотов нет, создаем его
+	notebooks, err := c.db.GetNotebooksByUserID(userID)
+	if err != nil {
+		c.logger.Error(err)
+		return 0, convertError(err)
+	}
+
+	var id uint64
+	for _, nb := range notebooks {
+		if nb.ParentID == nil && (id == 0 || nb.ID < id) {
+			id = nb.ID
+		}
+	}
+
+	if id != 0 {
+		return id, nil
+	}
+
+	id, err = c.db.AddNotebook(&entities.Notebook{
+		UserID:    userID,
+		Name:      DefaultNotebookName,
+		CreatedAt: c.now(),
+	})
+	if err != nil {
+		c.logger.Error(err)
+		return 0, convertError(err)
+	}
+
+	return id, nil
+}
+
+func (c TheCore) notebookError(err error, name string) error {
+	// Имя блокнота уникально среди соседей
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return fmt.Errorf("%w: notebook %s already exists here", ErrConflict, name)
+	}
+
+	c.logger.Error(err)
+	return convertError(err)
+}
+
+// Блокноты пользователя по id для проверок вложенности
+type notebookIndex map[uint64]*entities.Notebook
+
+func newNotebookIndex(notebooks []*entities.Notebook) notebookIndex {
+	index := make(notebookIndex, len(notebooks))
+	for _, nb := range notebooks {
+		index[nb.ID] = nb
+	}
+
+	return index
+}
+
+func (idx notebookIndex) depth(id uint64) int {
+	// Число предков блокнота, у блокнота верхнего уровня 0
+	depth := 0
+	for nb := idx[id]; nb != nil && nb.ParentID != nil && depth < len(idx); nb = idx[*nb.ParentID] {
+		depth++
+	}
+
+	return depth
+}
+
+func (idx notebookIndex) isAncestor(ancestor, id uint64) bool {
+	// Лежит ли блокнот id внутри ancestor или совпадает с ним
+	for steps := 0; steps <= len(idx); steps++ {
+		if id == ancestor {
+			return true
+		}
+
+		nb := idx[id]
+		if nb == nil || nb.ParentID == nil {
+			return false
+		}
+
+		id = *nb.ParentID
+	}
+
+	return false
+}
+
+func (idx notebookIndex) height(id uint64) int {
+	// Число уровней под блокнотом, у блокнота без вложенных 0
+	height := 0
+	for _, nb := range idx {
+		if nb.ID != id && idx.isAncestor(id, nb.ID) {
+			if h := idx.depth(nb.ID) - idx.depth(id); h > height {
+				height = h
+			}
+		}
+	}
+
+	return height
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/password"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Правила для паролей новых пользователей
+type PasswordPolicy struct {
+	MinLength int
+	// Ограничивает время хеширования слишком длинных паролей
+	MaxLength int
+	// Сколько разных классов символов нужно: строчные, заглавные, цифры, прочие
+	MinClasses int
+	// Запрещать пароли из списка часто используемых
+	RejectCommon bool
+	// Запрещать пароль, совпадающий с именем пользователя
+	RejectUsername bool
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:      8,
+	MaxLength:      256,
+	MinClasses:     2,
+	RejectCommon:   true,
+	RejectUsername: true,
+}
+
+// Правила для паролей новых пользователей
+func WithPasswordPolicy(p PasswordPolicy) Option {
+	return func(c *TheCore) {
+		c.passwordPolicy = p
+	}
+}
+
+func (p PasswordPolicy) Validate(username, pass string) error {
+	// Собираем все нарушения, чтобы показать их пользователю разом
+	verr := &ValidationError{}
+
+	length := utf8.RuneCountInString(pass)
+	if p.MinLength > 0 && length < p.MinLength {
+		verr.Add("password", fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		verr.Add("password", fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+
+	if classes := characterClasses(pass); classes < p.MinClasses {
+		verr.Add("password", fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses))
+	}
+
+	if p.RejectCommon && password.IsCommon(pass) {
+		verr.Add("password", "is too common")
+	}
+
+	if p.RejectUsername && strings.EqualFold(pass, username) {
+		verr.Add("password", "must not match the username")
+	}
+
+	return verr.OrNil()
+}
+
+func (c TheCore) validateNewPassword(username, pass, repeatedPassword string) error {
+	// Правила пароля и совпадение с повтором проверяем вместе, чтобы показать все ошибки сразу
+	verr := &ValidationError{}
+	var perr *ValidationError
+	if errors.As(c.passwordPolicy.Validate(username, pass), &perr) {
+		verr.Fields = append(verr.Fields, perr.Fields...)
+	}
+
+	if pass != repeatedPassword {
+		verr.Add("password_repeat", "passwords do not match")
+	}
+
+	return verr.OrNil()
+}
+
+func characterClasses(pass string) int {
+	// Считаем, сколько классов символов встречается в пароле
+	var lower, upper, digit, other bool
+	for _, r := range pass {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			classes++
+		}
+	}
+
+	return classes
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/mail"
+	"my_notes_project/internal/password"
+	"my_notes_project/internal/ratelimit"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Время жизни ссылки для восстановления пароля
+const DefaultResetTTL = time.Hour
+
+// На один адрес три письма подряд, дальше перерыв от 5 минут до часа
+var DefaultResetPolicy = ratelimit.Policy{
+	FreeAttempts: 3,
+	BaseDelay:    5 * time.Minute,
+	MaxDelay:     time.Hour,
+	Window:       time.Hour,
+}
+
+// Ограничение частоты писем для восстановления пароля на один адрес
+func WithResetPolicy(p ratelimit.Policy) Option {
+	return func(c *TheCore) {
+		c.resetPolicy = p
+	}
+}
+
+// Отправка писем со ссылками для восстановления пароля
+func WithMailer(m mail.Mailer) Option {
+	return func(c *TheCore) {
+		c.mailer = m
+	}
+}
+
+// Адрес сайта, из которого собираются ссылки в письмах
+func WithPublicURL(u string) Option {
+	return func(c *TheCore) {
+		c.publicURL = strings.TrimRight(u, "/")
+	}
+}
+
+// Адрес должен быть голым, без имени и угловых скобок
+func ValidateEmail(email string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return NewValidationError("email", "is not a valid address")
+	}
+
+	return nil
+}
+
+func (c TheCore) UpdateEmail(username, pass, email string) error {
+	// Пустой адрес отвязывает почту от учетной записи
+	email = strings.TrimSpace(email)
+	if email != "" {
+		if err := ValidateEmail(email); err != nil {
+			return err
+		}
+	}
+
+	// По этому адресу можно сбросить пароль, поэтому угнанной сессии его менять нельзя
+	user, err := c.reauthenticate(username, pass, "password")
+	if err != nil {
+		return err
+	}
+
+	user.Email = email
+	err = c.db.UpdateUser(user)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return fmt.Errorf("%w: email is used by another account", ErrConflict)
+	} else if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	c.logger.Infof("email of user %d updated", user.ID)
+	return nil
+}
+
+func (c TheCore) RequestPasswordReset(email string) error {
+	// Каждый запрос засчитывается адресу, иначе на него можно слать письма без конца.
+	// Известные и неизвестные адреса ограничиваются одинаково
+	email = strings.TrimSpace(email)
+	key := strings.ToLower(email)
+	wait, err := c.resetLimiter.Reserve(key)
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	} else if wait > 0 {
+		c.logger.Infof("password reset request rejected, locked for %s", wait.Round(time.Second))
+		return &RateLimitError{RetryAfter: wait}
+	}
+
+	if err = c.resetLimiter.Fail(key); err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	// Ответ не зависит от того, есть ли такой адрес, чтобы по нему нельзя было искать пользователей
+	user, err := c.db.GetUserByEmail(email)
+	if errors.Is(err, database.ErrNotFound) {
+		c.logger.Infof("password reset requested for unknown email")
+		return nil
+	} else if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	// Токен уходит только в письмо, в базе храним его хеш
+	token, err := newToken()
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	now := c.now()
+	reset := &entities.PasswordReset{
+		ID:        hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(DefaultResetTTL),
+	}
+
+	if err = c.db.AddPasswordReset(reset); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	link := c.publicURL + "/password/reset?token=" + url.QueryEscape(token)
+	err = c.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Восстановление пароля",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %s и сработает один раз. Если вы не запрашивали восстановление, просто проигнорируйте письмо.\n",
+			user.Name, link, DefaultResetTTL),
+	})
+	if err != nil {
+		// Ошибку отправки не показываем, иначе по ней можно узнать, что адрес зарегистрирован
+		c.logger.Errorf("password reset mail for user %d: %v", user.ID, err)
+		return nil
+	}
+
+	c.logger.Infof("password reset requested for user %d", user.ID)
+	return nil
+}
+
+func (c TheCore) ResetPassword(token, newPass, repeatedPassword string) error {
+	// Ищем действующий токен по хешу
+	reset, err := c.db.GetPasswordReset(hashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return NewValidationError("token", "is invalid or expired")
+	} else if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	if !c.now().Before(reset.ExpiresAt) {
+		return NewValidationError("token", "is invalid or expired")
+	}
+
+	user, err := c.db.GetUserByID(reset.UserID)
+	if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	//Новый пароль проверяем по тем же правилам, что и при регистрации
+	if err = c.validateNewPassword(user.Name, newPass, repeatedPassword); err != nil {
+		return err
+	}
+
+	hash, err := password.Hash(newPass, c.passwordParams)
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	// Токен одноразовый: забираем его до смены пароля, из двух одновременных запросов
+	// с одним токеном пароль сменит только один
+	err = c.db.RemovePasswordResetByID(reset.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return NewValidationError("token", "is invalid or expired")
+	} else if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	user.Password = hash
+	if err = c.db.UpdateUser(user); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	// Другие ссылки больше не нужны, а старые сессии могли принадлежать тому, кто узнал прежний пароль
+	if err = c.db.RemovePasswordResetsByUserID(user.ID); err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	if err = c.db.RemoveSessionsByUserID(user.ID); err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	if err = c.accountLimiter.Reset(strings.ToLower(user.Name)); err != nil {
+		c.logger.Error(err)
+	}
+
+	c.logger.Infof("password of user %d reset", user.ID)
+	return nil
+}
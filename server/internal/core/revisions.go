@@ -0,0 +1,117 @@
+package core
+
+import (
+	"my_notes_project/internal/diff"
+	"my_notes_project/internal/entities"
+)
+
+// Построчное сравнение двух правок заметки
+type RevisionDiff struct {
+	From  *entities.Revision
+	To    *entities.Revision
+	Lines []diff.Line
+}
+
+func (c TheCore) GetNoteRevisions(username string, id uint64) ([]*entities.Revision, error) {
+	// Историю заметки видит только ее автор
+	if _, err := c.GetNoteByID(username, id); err != nil {
+		return nil, err
+	}
+
+	revisions, err := c.db.GetRevisionsByNoteID(id)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	return revisions, nil
+}
+
+func (c TheCore) GetNoteRevision(username string, id uint64, number int) (*entities.Revision, error) {
+	if _, err := c.GetNoteByID(username, id); err != nil {
+		return nil, err
+	}
+
+	return c.revision(id, number)
+}
+
+func (c TheCore) DiffNoteRevisions(username string, id uint64, from, to int) (*RevisionDiff, error) {
+	// Без номеров сравниваем последнюю правку с предыдущей
+	verr := &ValidationError{}
+	if from < 0 {
+		verr.Add("from", "must be a positive revision number")
+	}
+	if to < 0 {
+		verr.Add("to", "must be a positive revision number")
+	}
+
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	revisions, err := c.GetNoteRevisions(username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if to == 0 {
+		to = revisions[0].Number
+	}
+	if from == 0 {
+		from = to - 1
+		if from < 1 {
+			from = 1
+		}
+	}
+
+	d := &RevisionDiff{}
+	if d.From, err = c.revision(id, from); err != nil {
+		return nil, err
+	}
+	if d.To, err = c.revision(id, to); err != nil {
+		return nil, err
+	}
+
+	d.Lines = diff.Lines(d.From.Content, d.To.Content)
+
+	return d, nil
+}
+
+func (c TheCore) RestoreNoteRevision(username string, id uint64, number int) (*entities.Note, error) {
+	// Старая правка не меняется, ее заголовок и содержимое становятся новой правкой.
+	// Метки и блокнот заметки остаются текущими
+	if _, err := c.GetNoteByID(username, id); err != nil {
+		return nil, err
+	}
+
+	revision, err := c.revision(id, number)
+	if err != nil {
+		return nil, err
+	}
+
+	note := &entities.Note{
+		ID:      id,
+		Title:   revision.Title,
+		Content: revision.Content,
+	}
+
+	if err = c.UpdateNoteByUserName(username, note); err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+func (c TheCore) revision(id uint64, number int) (*entities.Revision, error) {
+	revision, err := c.db.GetRevision(id, number)
+	if err != nil {
+		c.logger.Errorf("note %d revision %d: %v", id, number, err)
+		return nil, convertError(err)
+	}
+
+	return revision, nil
+}
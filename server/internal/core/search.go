@@ -0,0 +1,40 @@
+package core
+
+import (
+	"fmt"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/search"
+)
+
+const (
+	// Сколько лучших совпадений возвращает поиск
+	SearchLimit = 50
+	// Ограничение на сложность запроса
+	maxSearchTerms = 16
+)
+
+func (c TheCore) SearchNotes(username, query string) ([]*entities.NoteMatch, error) {
+	// Разбираем запрос на слова и фразы, синтаксис FTS5 пользователю недоступен
+	terms := search.Parse(query)
+	if len(terms) == 0 {
+		return nil, NewValidationError("q", "must contain at least one word")
+	}
+
+	if len(terms) > maxSearchTerms {
+		return nil, NewValidationError("q", fmt.Sprintf("must contain at most %d words or phrases", maxSearchTerms))
+	}
+
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	matches, err := c.db.SearchNotes(user.ID, terms, SearchLimit)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"time"
+)
+
+// Время жизни сессии по умолчанию
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+// Время жизни сессии
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(c *TheCore) {
+		c.sessionTTL = ttl
+	}
+}
+
+func (c TheCore) CreateSession(username string) (string, *entities.Session, error) {
+	// Получаем пользователя, для которого создаем сессию
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, convertError(err)
+	}
+
+	// С включенным вторым фактором сессия начинает действовать только после кода
+	pending, err := c.totpEnabled(user.ID)
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, err
+	}
+
+	return c.addSession(user.ID, pending)
+}
+
+func (c TheCore) addSession(userID uint64, pending bool) (string, *entities.Session, error) {
+	// Идентификатор сессии получает только пользователь, в базе храним его хеш
+	token, err := newToken()
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, err
+	}
+
+	ttl := c.sessionTTL
+	if pending {
+		ttl = MFAPendingTTL
+	}
+
+	now := c.now()
+	session := &entities.Session{
+		ID:         hashToken(token),
+		UserID:     userID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+		MFAPending: pending,
+	}
+
+	if err = c.db.AddSession(session); err != nil {
+		c.logger.Error(err)
+		return "", nil, err
+	}
+
+	// Вход завершен, когда выдана полноценная сессия, ошибка записи времени входа его не отменяет
+	if !pending {
+		if err = c.db.UpdateUserLastLogin(userID, now); err != nil {
+			c.logger.Error(err)
+		}
+	}
+
+	return token, session, nil
+}
+
+func (c TheCore) GetUserBySession(token string) (*entities.User, error) {
+	// Сессия, ожидающая второй фактор, доступа не дает
+	session, err := c.getSession(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.MFAPending {
+		return nil, ErrUnauthorized
+	}
+
+	return c.sessionUser(session)
+}
+
+func (c TheCore) getSession(token string) (*entities.Session, error) {
+	// Ищем сессию по хешу идентификатора
+	session, err := c.db.GetSessionByID(hashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrUnauthorized
+	} else if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	// Просроченную сессию сразу удаляем
+	if !c.now().Before(session.ExpiresAt) {
+		if err = c.db.RemoveSessionByID(session.ID); err != nil {
+			c.logger.Error(err)
+		}
+		return nil, ErrUnauthorized
+	}
+
+	return session, nil
+}
+
+func (c TheCore) sessionUser(session *entities.Session) (*entities.User, error) {
+	// Пользователь сессии, удаленный пользователь считается выходом
+	user, err := c.db.GetUserByID(session.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrUnauthorized
+	} else if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (c TheCore) RevokeSession(token string) error {
+	// Удаляем сессию на стороне сервера
+	err := c.db.RemoveSessionByID(hashToken(token))
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		c.logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func (c TheCore) RevokeAllSessions(username string) error {
+	// Завершаем сессии пользователя на всех устройствах
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	if err = c.db.RemoveSessionsByUserID(user.ID); err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	c.logger.Infof("all sessions of user %d revoked", user.ID)
+	return nil
+}
+
+func newToken() (string, error) {
+	// Случайный идентификатор длиной 256 бит
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
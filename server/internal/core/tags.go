@@ -0,0 +1,219 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Наибольшая длина имени метки в символах
+	MaxTagLength = 32
+	// Сколько меток можно поставить на одну заметку и указать в фильтре
+	MaxNoteTags = 20
+)
+
+func normalizeTag(name string) string {
+	// Метки сравниваются без учета регистра и лишних пробелов, #Work и work одна метка
+	name = strings.TrimLeft(strings.TrimSpace(name), "#")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func validateTag(verr *ValidationError, field, tag string) {
+	// Запятая разделяет метки в формах и строке запроса, поэтому в имени недопустима
+	switch {
+	case tag == "":
+		verr.Add(field, "must not be empty")
+	case utf8.RuneCountInString(tag) > MaxTagLength:
+		verr.Add(field, fmt.Sprintf("%q is longer than %d characters", tag, MaxTagLength))
+	case strings.Contains(tag, ","):
+		verr.Add(field, fmt.Sprintf("%q must not contain commas", tag))
+	}
+}
+
+func normalizeTags(verr *ValidationError, field string, names []string) []string {
+	// Приводим метки к нормальной форме, пустые и повторы отбрасываем.
+	// nil остается nil: для изменения заметки это значит "метки не менять"
+	if names == nil {
+		return nil
+	}
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		tag := normalizeTag(name)
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		validateTag(verr, field, tag)
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	if len(tags) > MaxNoteTags {
+		verr.Add(field, fmt.Sprintf("must contain at most %d tags", MaxNoteTags))
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+func (c TheCore) GetTags(username string) ([]*entities.Tag, error) {
+	// Метки пользователя с числом заметок
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	tags, err := c.db.GetTagsByUserID(user.ID)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	return tags, nil
+}
+
+func (c TheCore) AddNoteTags(username string, id uint64, names []string) (*entities.Note, error) {
+	// Добавляем метки к уже стоящим на заметке
+	return c.changeNoteTags(username, id, names, func(current []string, tags []string) []string {
+		return append(current, tags...)
+	})
+}
+
+func (c TheCore) RemoveNoteTags(username string, id uint64, names []string) (*entities.Note, error) {
+	// Снимаем метки с заметки, метки, которых на ней нет, пропускаем
+	return c.changeNoteTags(username, id, names, func(current []string, tags []string) []string {
+		kept := []string{}
+		for _, tag := range current {
+			if !containsString(tags, tag) {
+				kept = append(kept, tag)
+			}
+		}
+
+		return kept
+	})
+}
+
+func (c TheCore) changeNoteTags(username string, id uint64, names []string, change func([]string, []string) []string) (*entities.Note, error) {
+	verr := &ValidationError{}
+	tags := normalizeTags(verr, "tags", names)
+	if len(tags) == 0 {
+		verr.Add("tags", "must contain at least one tag")
+	}
+
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	// Метки меняет только автор заметки
+	note, err := c.GetNoteByID(username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	verr = &ValidationError{}
+	note.Tags = normalizeTags(verr, "tags", change(note.Tags, tags))
+	if err = verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	if err = c.db.SetNoteTags(note); err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	return note, nil
+}
+
+func (c TheCore) RenameTag(username, from, to string) error {
+	// Новое имя проверяем так же, как при добавлении метки
+	from, to = normalizeTag(from), normalizeTag(to)
+
+	verr := &ValidationError{}
+	validateTag(verr, "name", to)
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	if from == to {
+		return nil
+	}
+
+	// Занятое имя не перезаписываем, для объединения меток есть MergeTags
+	err = c.db.RenameTag(user.ID, from, to)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return fmt.Errorf("%w: tag %s already exists, merge the tags instead", ErrConflict, to)
+	} else if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+func (c TheCore) MergeTags(username, from, into string) error {
+	// Заметки с меткой from получают метку into, метка from исчезает
+	from, into = normalizeTag(from), normalizeTag(into)
+
+	verr := &ValidationError{}
+	validateTag(verr, "into", into)
+	if from == into {
+		verr.Add("into", "must differ from the merged tag")
+	}
+
+	if err := verr.OrNil(); err != nil {
+		return err
+	}
+
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	if err = c.db.MergeTags(user.ID, from, into); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+func (c TheCore) DeleteTag(username, name string) error {
+	// Метка снимается со всех заметок, сами заметки остаются
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	if err = c.db.RemoveTag(user.ID, normalizeTag(name)); err != nil {
+		c.logger.Error(err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
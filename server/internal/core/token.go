@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"strings"
+	"time"
+)
+
+// Области доступа персональных токенов
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+)
+
+// Префикс, по которому токен легко узнать в логах и конфигурации
+const apiTokenPrefix = "mnp_"
+
+var Scopes = []string{ScopeNotesRead, ScopeNotesWrite}
+
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c TheCore) CreateAPIToken(username, name string, scopes []string, expiresAt *time.Time) (string, *entities.APIToken, error) {
+	// Проверяем название, области доступа и срок действия
+	verr := &ValidationError{}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		verr.Add("name", "must not be empty")
+	}
+
+	if len(scopes) == 0 {
+		verr.Add("scopes", "at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			verr.Add("scopes", fmt.Sprintf("unknown scope %q", scope))
+		}
+	}
+
+	now := c.now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		verr.Add("expires_at", "must be in the future")
+	}
+
+	if err := verr.OrNil(); err != nil {
+		return "", nil, err
+	}
+
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, convertError(err)
+	}
+
+	// Пользователь получает токен один раз, в базе храним только хеш
+	secret, err := newToken()
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, err
+	}
+
+	plain := apiTokenPrefix + secret
+	token := &entities.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		Hash:      hashToken(plain),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	id, err := c.db.AddAPIToken(token)
+	if err != nil {
+		c.logger.Error(err)
+		return "", nil, convertError(err)
+	}
+
+	token.ID = id
+
+	c.logger.Infof("api token %d created for user %d", token.ID, user.ID)
+	return plain, token, nil
+}
+
+func (c TheCore) GetAPITokens(username string) ([]*entities.APIToken, error) {
+	// Получаем токены пользователя
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	tokens, err := c.db.GetAPITokensByUserID(user.ID)
+	return tokens, convertError(err)
+}
+
+func (c TheCore) RevokeAPIToken(username string, id uint64) error {
+	// Отозвать можно только свой токен
+	tokens, err := c.GetAPITokens(username)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.ID == id {
+			c.logger.Infof("api token %d revoked", id)
+			return convertError(c.db.RemoveAPITokenByID(id))
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (c TheCore) GetUserByAPIToken(plain string) (*entities.User, *entities.APIToken, error) {
+	// Ищем токен по хешу
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil, nil, ErrUnauthorized
+	}
+
+	token, err := c.db.GetAPITokenByHash(hashToken(plain))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, ErrUnauthorized
+	} else if err != nil {
+		c.logger.Error(err)
+		return nil, nil, err
+	}
+
+	// Просроченный токен не принимаем, но и не удаляем, чтобы пользователь видел его в списке
+	now := c.now()
+	if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+		return nil, nil, ErrUnauthorized
+	}
+
+	user, err := c.db.GetUserByID(token.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, ErrUnauthorized
+	} else if err != nil {
+		c.logger.Error(err)
+		return nil, nil, err
+	}
+
+	if err = c.db.UpdateAPITokenLastUsed(token.ID, now); err != nil {
+		c.logger.Error(err)
+	}
+
+	return user, token, nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"my_notes_project/internal/entities"
+	"time"
+)
+
+// Сколько заметка лежит в корзине до окончательного удаления по умолчанию
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// Срок хранения заметок в корзине, нулевой срок хранит их до очистки вручную
+func WithTrashRetention(retention time.Duration) Option {
+	return func(c *TheCore) {
+		c.trashRetention = retention
+	}
+}
+
+func (c TheCore) GetTrash(username string) ([]*entities.Note, error) {
+	// Получаем пользователя, чью корзину показываем
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	notes, err := c.db.GetTrashByUserID(user.ID)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, convertError(err)
+	}
+
+	return notes, nil
+}
+
+func (c TheCore) RestoreNote(username string, id uint64) error {
+	// Вернуть из корзины можно только свою заметку
+	note, err := c.ownNote(username, id)
+	if err != nil {
+		return err
+	}
+
+	if note.DeletedAt == nil {
+		return ErrNotFound
+	}
+
+	if err = c.db.RestoreNote(id); err != nil {
+		c.logger.Errorf("note %d: %v", id, err)
+		return convertError(err)
+	}
+
+	return nil
+}
+
+func (c TheCore) EmptyTrash(username string) (int, error) {
+	// Окончательно удаляем все заметки из корзины пользователя
+	user, err := c.db.GetUserByName(username)
+	if err != nil {
+		c.logger.Error(err)
+		return 0, convertError(err)
+	}
+
+	n, err := c.db.EmptyTrash(user.ID)
+	if err != nil {
+		c.logger.Error(err)
+		return 0, convertError(err)
+	}
+
+	return n, nil
+}
+
+// Окончательно удаляет заметки, пролежавшие в корзине дольше срока хранения
+func (c TheCore) PurgeTrash() (int, error) {
+	if c.trashRetention <= 0 {
+		return 0, nil
+	}
+
+	n, err := c.db.PurgeTrash(c.now().Add(-c.trashRetention))
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	return n, nil
+}
+
+// Очищает корзину сразу и затем каждые interval, пока не отменен ctx
+func (c TheCore) RunTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := c.PurgeTrash()
+		if err != nil {
+			c.logger.Errorf("purge trash: %v", err)
+		} else if n > 0 {
+			c.logger.Infof("purged %d notes from trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
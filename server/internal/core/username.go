@@ -0,0 +1,58 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Правила для имен пользователей
+type UsernamePolicy struct {
+	MinLength int
+	MaxLength int
+	// Допустимые символы, имя должно совпадать с выражением целиком
+	Pattern *regexp.Regexp
+	// Имена, которые нельзя занять, сравниваются без учета регистра
+	Reserved []string
+}
+
+// Буквы любого алфавита, цифры, точка, дефис и подчеркивание
+var DefaultUsernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.-]+$`)
+
+var DefaultUsernamePolicy = UsernamePolicy{
+	MinLength: 3,
+	MaxLength: 32,
+	Pattern:   DefaultUsernamePattern,
+	Reserved:  []string{"admin", "administrator", "root", "system", "api", "static", "support"},
+}
+
+// Правила для имен новых пользователей
+func WithUsernamePolicy(p UsernamePolicy) Option {
+	return func(c *TheCore) {
+		c.usernamePolicy = p
+	}
+}
+
+func (p UsernamePolicy) Validate(name string) error {
+	// Длину считаем в символах, а не в байтах
+	length := utf8.RuneCountInString(name)
+	switch {
+	case length == 0:
+		return NewValidationError("username", "must not be empty")
+	case p.MinLength > 0 && length < p.MinLength:
+		return NewValidationError("username", fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	case p.MaxLength > 0 && length > p.MaxLength:
+		return NewValidationError("username", fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	case p.Pattern != nil && !p.Pattern.MatchString(name):
+		return NewValidationError("username", "contains characters that are not allowed")
+	}
+
+	for _, reserved := range p.Reserved {
+		if strings.EqualFold(name, reserved) {
+			return NewValidationError("username", "is reserved")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"database/sql"
+	"my_notes_project/internal/entities"
+)
+
+func (s *SQLiteDatabase) GetLoginAttempt(key string) (*entities.LoginAttempt, error) {
+	// Получаем счетчик неудачных попыток входа
+	attempt := &entities.LoginAttempt{}
+
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow(`SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = ?`, key).
+		Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	attempt.LockedUntil = timePtr(lockedUntil)
+
+	return attempt, nil
+}
+
+func (s *SQLiteDatabase) SaveLoginAttempt(attempt *entities.LoginAttempt) error {
+	// Создаем или обновляем счетчик, блокировка переживает перезапуск сервера
+	_, err := s.db.Exec(`
+		INSERT INTO login_attempts (key, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure_at = excluded.last_failure_at,
+			locked_until = excluded.locked_until`,
+		attempt.Key, attempt.Failures, attempt.LastFailureAt.UTC(), nullTime(attempt.LockedUntil))
+
+	return err
+}
+
+func (s *SQLiteDatabase) RemoveLoginAttempt(key string) error {
+	// Сбрасываем счетчик после успешного входа
+	_, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = ?`, key)
+
+	return err
+}
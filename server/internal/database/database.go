@@ -0,0 +1,77 @@
+package database
+
+import (
+	"errors"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/search"
+	"time"
+)
+
+var (
+	// Запись не найдена в базе данных
+	ErrNotFound = errors.New("not found")
+	// Запись нарушает ограничение уникальности
+	ErrAlreadyExists = errors.New("already exists")
+	// Запись успели изменить после того, как ее прочитали
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+type DBRepository interface {
+	AddUser(*entities.User) (uint64, error)
+	UpdateUser(*entities.User) error
+	AddNote(*entities.Note) (uint64, error)
+	RemoveNoteByID(uint64) error
+	UpdateNote(*entities.Note) error
+	GetAllNotes() (map[uint64]*entities.Note, error)
+	GetNoteByID(uint64) (*entities.Note, error)
+	GetUserByName(string) (*entities.User, error)
+	GetUserByID(uint64) (*entities.User, error)
+	GetUserByEmail(string) (*entities.User, error)
+	UpdateUserLastLogin(uint64, time.Time) error
+	RemoveUserByID(uint64) error
+	GetNotesByUserName(string) (map[uint64]*entities.Note, error)
+	ListNotes(uint64, entities.NoteQuery) ([]*entities.Note, error)
+	SearchNotes(uint64, []search.Term, int) ([]*entities.NoteMatch, error)
+	SetNoteTags(*entities.Note) error
+	GetTagsByUserID(uint64) ([]*entities.Tag, error)
+	RenameTag(uint64, string, string) error
+	MergeTags(uint64, string, string) error
+	RemoveTag(uint64, string) error
+	AddNotebook(*entities.Notebook) (uint64, error)
+	GetNotebookByID(uint64) (*entities.Notebook, error)
+	GetNotebooksByUserID(uint64) ([]*entities.Notebook, error)
+	UpdateNotebook(*entities.Notebook) error
+	RemoveNotebookByID(uint64, *entities.Notebook, time.Time) error
+	MoveNote(uint64, uint64) error
+	GetRevisionsByNoteID(uint64) ([]*entities.Revision, error)
+	TrashNote(uint64, time.Time) error
+	RestoreNote(uint64) error
+	GetTrashByUserID(uint64) ([]*entities.Note, error)
+	EmptyTrash(uint64) (int, error)
+	PurgeTrash(time.Time) (int, error)
+	GetRevision(uint64, int) (*entities.Revision, error)
+	AddSession(*entities.Session) error
+	GetSessionByID(string) (*entities.Session, error)
+	RemoveSessionByID(string) error
+	RemoveSessionsByUserID(uint64) error
+	RemoveSessionsByUserIDExcept(uint64, string) error
+	AddAPIToken(*entities.APIToken) (uint64, error)
+	GetAPITokenByHash(string) (*entities.APIToken, error)
+	GetAPITokensByUserID(uint64) ([]*entities.APIToken, error)
+	RemoveAPITokenByID(uint64) error
+	UpdateAPITokenLastUsed(uint64, time.Time) error
+	GetLoginAttempt(string) (*entities.LoginAttempt, error)
+	SaveLoginAttempt(*entities.LoginAttempt) error
+	RemoveLoginAttempt(string) error
+	AddPasswordReset(*entities.PasswordReset) error
+	GetPasswordReset(string) (*entities.PasswordReset, error)
+	RemovePasswordResetByID(string) error
+	RemovePasswordResetsByUserID(uint64) error
+	GetTOTP(uint64) (*entities.TOTP, error)
+	SaveTOTP(*entities.TOTP) error
+	AdvanceTOTPCounter(uint64, uint64) error
+	RemoveTOTP(uint64) error
+	ReplaceRecoveryCodes(uint64, []string) error
+	UseRecoveryCode(uint64, string) error
+	CountRecoveryCodes(uint64) (int, error)
+}
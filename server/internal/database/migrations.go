@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// База данных создана более новой версией программы
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// В базе есть имена пользователей, различающиеся только регистром
+var ErrDuplicateUsernames = errors.New("usernames differ only by case")
+
+// Проверки перед миграциями, которые иначе упали бы с непонятной ошибкой SQLite
+var migrationChecks = map[int]func(tx *sql.Tx) error{
+	4:  checkCaseDuplicateUsernames,
+	14: checkCaseDuplicateUsernames,
+}
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	applied_at TEXT    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// Миграция схемы, файл migrations/<версия>_<название>.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Состояние схемы базы данных относительно миграций программы
+type MigrationStatus struct {
+	Current int
+	Latest  int
+	Pending []Migration
+}
+
+func (s MigrationStatus) TooNew() bool {
+	return s.Current > s.Latest
+}
+
+func loadMigrations() ([]Migration, error) {
+	// Читаем встроенные файлы миграций
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		// Разбираем имя файла на версию и название
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("bad migration file name: %s", entry.Name())
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration version: %s", entry.Name())
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(data),
+		})
+	}
+
+	// Миграции применяются строго по возрастанию версий
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version: %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+func (s *SQLiteDatabase) SchemaVersion() (int, error) {
+	// Если таблицы версий нет, то миграции еще не применялись
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).
+		Scan(&count)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	// Текущая версия - максимальная из примененных миграций
+	var version int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+
+	return version, err
+}
+
+func (s *SQLiteDatabase) MigrationStatus() (*MigrationStatus, error) {
+	// Сравниваем версию базы данных с миграциями программы
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Current: current}
+	for _, m := range migrations {
+		status.Latest = m.Version
+		if m.Version > current {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+
+	return status, nil
+}
+
+func (s *SQLiteDatabase) Migrate() error {
+	// Получаем список миграций, которые еще не применены
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	// Не трогаем базу данных, о схеме которой программа ничего не знает
+	if status.TooNew() {
+		return fmt.Errorf("%w: database version %d, latest known %d", ErrSchemaTooNew, status.Current, status.Latest)
+	}
+
+	if len(status.Pending) == 0 {
+		return nil
+	}
+
+	// Создаем таблицу версий, если ее еще нет
+	if _, err = s.db.Exec(migrationsTable); err != nil {
+		return err
+	}
+
+	for _, m := range status.Pending {
+		if err = s.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+
+		s.logger.Infof("applied migration %04d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func (s *SQLiteDatabase) applyMigration(m Migration) error {
+	// Каждая миграция применяется в отдельной транзакции вместе с записью версии
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if check, exists := migrationChecks[m.Version]; exists {
+		if err = check(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(m.SQL); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func checkCaseDuplicateUsernames(tx *sql.Tx) error {
+	// Уникальный индекс без учета регистра не создать, пока такие имена есть.
+	// Выбрать, кого переименовать, может только администратор, поэтому перечисляем их
+	rows, err := tx.Query(`SELECT id, name FROM users ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Группы в порядке первого пользователя в каждой
+	keys := []string{}
+	groups := map[string][]string{}
+	for rows.Next() {
+		var id uint64
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			return err
+		}
+
+		key := UsernameKey(name)
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], fmt.Sprintf("%s (id %d)", name, id))
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	duplicates := []string{}
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			duplicates = append(duplicates, strings.Join(groups[key], ", "))
+		}
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%w: %s; rename all but one user in each group and run the migration again",
+			ErrDuplicateUsernames, strings.Join(duplicates, "; "))
+	}
+
+	return nil
+}
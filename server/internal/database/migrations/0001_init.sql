@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS users (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	name     TEXT    NOT NULL UNIQUE,
+	password TEXT    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notes (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	title   TEXT    NOT NULL,
+	content TEXT    NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS notes_user_id_idx ON notes(user_id);
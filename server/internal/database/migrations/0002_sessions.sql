@@ -0,0 +1,8 @@
+CREATE TABLE sessions (
+	id         TEXT      PRIMARY KEY,
+	user_id    INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE api_tokens (
+	id           INTEGER   PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name         TEXT      NOT NULL,
+	hash         TEXT      NOT NULL UNIQUE,
+	scopes       TEXT      NOT NULL,
+	created_at   TIMESTAMP NOT NULL,
+	expires_at   TIMESTAMP,
+	last_used_at TIMESTAMP
+);
+
+CREATE INDEX api_tokens_user_id_idx ON api_tokens(user_id);
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX users_name_nocase_idx ON users(name COLLATE NOCASE);
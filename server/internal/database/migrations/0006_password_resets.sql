@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN email TEXT;
+
+CREATE UNIQUE INDEX users_email_idx ON users(email COLLATE NOCASE) WHERE email IS NOT NULL;
+
+CREATE TABLE password_resets (
+	id         TEXT      PRIMARY KEY,
+	user_id    INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets(user_id);
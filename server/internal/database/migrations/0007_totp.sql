@@ -0,0 +1,17 @@
+CREATE TABLE totp (
+	user_id      INTEGER   PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	secret       TEXT      NOT NULL,
+	enabled      BOOLEAN   NOT NULL DEFAULT FALSE,
+	last_counter INTEGER   NOT NULL DEFAULT 0,
+	created_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE recovery_codes (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	hash    TEXT    NOT NULL
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes(user_id);
+
+ALTER TABLE sessions ADD COLUMN mfa_pending BOOLEAN NOT NULL DEFAULT FALSE;
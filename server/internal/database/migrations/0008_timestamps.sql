@@ -0,0 +1,11 @@
+ALTER TABLE notes ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE notes ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE users ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE users ADD COLUMN last_login_at TIMESTAMP;
+
+-- Время пишем в том же виде, что и драйвер, иначе сравнение с курсором страницы как текста ломается
+UPDATE notes SET created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'), updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+UPDATE users SET created_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+
+CREATE INDEX notes_user_created_idx ON notes(user_id, created_at);
+CREATE INDEX notes_user_updated_idx ON notes(user_id, updated_at);
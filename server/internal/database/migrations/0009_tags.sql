@@ -0,0 +1,21 @@
+CREATE TABLE tags (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name    TEXT    NOT NULL,
+	UNIQUE (user_id, name)
+);
+
+CREATE TABLE note_tags (
+	note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+	tag_id  INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (note_id, tag_id)
+);
+
+CREATE INDEX note_tags_tag_id_idx ON note_tags(tag_id);
+
+-- Метка, которая больше не стоит ни на одной заметке, удаляется
+CREATE TRIGGER note_tags_cleanup AFTER DELETE ON note_tags
+WHEN NOT EXISTS (SELECT 1 FROM note_tags WHERE tag_id = old.tag_id)
+BEGIN
+	DELETE FROM tags WHERE id = old.tag_id;
+END;
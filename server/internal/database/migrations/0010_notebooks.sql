@@ -0,0 +1,22 @@
+CREATE TABLE notebooks (
+	id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	parent_id  INTEGER   REFERENCES notebooks(id) ON DELETE CASCADE,
+	name       TEXT      NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX notebooks_parent_id_idx ON notebooks(parent_id);
+
+-- Имена блокнотов уникальны среди соседей, у блокнотов верхнего уровня parent_id NULL
+CREATE UNIQUE INDEX notebooks_name_idx ON notebooks(user_id, IFNULL(parent_id, 0), name COLLATE NOCASE);
+
+-- Удаление блокнота удаляет и его заметки. Столбец, добавленный ALTER TABLE со ссылкой,
+-- не может быть NOT NULL, поэтому блокнот у каждой заметки обеспечивает приложение
+ALTER TABLE notes ADD COLUMN notebook_id INTEGER REFERENCES notebooks(id) ON DELETE CASCADE;
+
+CREATE INDEX notes_notebook_id_idx ON notes(notebook_id);
+
+-- Существующие заметки переносим в блокнот по умолчанию
+INSERT INTO notebooks (user_id, name, created_at) SELECT id, 'Inbox', strftime('%Y-%m-%d %H:%M:%S+00:00', 'now') FROM users;
+UPDATE notes SET notebook_id = (SELECT nb.id FROM notebooks nb WHERE nb.user_id = notes.user_id);
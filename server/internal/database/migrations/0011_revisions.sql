@@ -0,0 +1,21 @@
+-- Состояние заметки после создания и после каждого изменения
+CREATE TABLE note_revisions (
+	id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+	note_id    INTEGER   NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+	number     INTEGER   NOT NULL,
+	title      TEXT      NOT NULL,
+	content    TEXT      NOT NULL,
+	author_id  INTEGER   NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (note_id, number)
+);
+
+-- Правки не меняются, восстановление старой правки создает новую
+CREATE TRIGGER note_revisions_immutable BEFORE UPDATE ON note_revisions
+BEGIN
+	SELECT RAISE(ABORT, 'note revisions are immutable');
+END;
+
+-- Текущее состояние существующих заметок становится их первой правкой
+INSERT INTO note_revisions (note_id, number, title, content, author_id, created_at)
+SELECT id, 1, title, content, user_id, updated_at FROM notes;
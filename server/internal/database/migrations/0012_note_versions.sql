@@ -0,0 +1,5 @@
+-- Номер версии заметки растет с каждым изменением и совпадает с номером ее последней правки
+ALTER TABLE notes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+UPDATE notes SET version = (SELECT MAX(r.number) FROM note_revisions r WHERE r.note_id = notes.id)
+WHERE EXISTS (SELECT 1 FROM note_revisions r WHERE r.note_id = notes.id);
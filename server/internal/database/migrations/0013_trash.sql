@@ -0,0 +1,4 @@
+-- Удаленная заметка попадает в корзину, окончательно ее удаляет очистка корзины
+ALTER TABLE notes ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX notes_deleted_at_idx ON notes(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Ключ имени без учета регистра в любом алфавите, его вычисляет функция username_key
+ALTER TABLE users ADD COLUMN name_key TEXT;
+UPDATE users SET name_key = username_key(name);
+CREATE UNIQUE INDEX users_name_key_idx ON users(name_key);
+DROP INDEX users_name_nocase_idx;
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"my_notes_project/internal/entities"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+	log := logrus.New()
+
+	db, err := NewSQLiteDatabase(path, log)
+	require.Nil(t, err)
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	status, err := db.MigrationStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].Version, status.Current)
+	assert.Empty(t, status.Pending)
+
+	// Повторный запуск не должен ничего применять
+	assert.Nil(t, db.Migrate())
+	assert.Nil(t, db.CloseSQLiteDatabase())
+
+	db, err = NewSQLiteDatabase(path, log)
+	require.Nil(t, err)
+	assert.Nil(t, db.CloseSQLiteDatabase())
+}
+
+func TestMigrationStatusOnEmptyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+
+	db, err := OpenSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	status, err := db.MigrationStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status.Current)
+	assert.Equal(t, migrations, status.Pending)
+
+	// Просмотр статуса не должен менять базу данных
+	version, err := db.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version)
+}
+
+func TestRefuseNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+	log := logrus.New()
+
+	db, err := NewSQLiteDatabase(path, log)
+	require.Nil(t, err)
+
+	_, err = db.db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, 1<<20, "future")
+	require.Nil(t, err)
+	require.Nil(t, db.CloseSQLiteDatabase())
+
+	_, err = NewSQLiteDatabase(path, log)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+func TestCaseDuplicateUsernames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+
+	db, err := OpenSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	// До индекса без учета регистра такие имена могли появиться
+	_, err = db.db.Exec(migrationsTable)
+	require.Nil(t, err)
+	for _, m := range migrations {
+		if m.Version >= 4 {
+			break
+		}
+		require.Nil(t, db.applyMigration(m))
+	}
+
+	_, err = db.db.Exec(`INSERT INTO users (id, name, password) VALUES
+		(1, 'Bob', 'x'), (2, 'Ivan', 'x'), (3, 'bob', 'x'), (4, 'IVAN', 'x'), (5, 'Igor', 'x')`)
+	require.Nil(t, err)
+
+	// Миграция не применяется и перечисляет конфликтующих пользователей
+	err = db.Migrate()
+	assert.ErrorIs(t, err, ErrDuplicateUsernames)
+	assert.Contains(t, err.Error(), "Bob (id 1), bob (id 3); Ivan (id 2), IVAN (id 4);")
+	assert.NotContains(t, err.Error(), "Igor")
+
+	version, err := db.SchemaVersion()
+	require.Nil(t, err)
+	assert.Equal(t, 3, version)
+
+	// После переименования миграции применяются
+	_, err = db.db.Exec(`UPDATE users SET name = name || '2' WHERE id IN (3, 4)`)
+	require.Nil(t, err)
+	require.Nil(t, db.Migrate())
+
+	user, err := db.GetUserByName("BOB2")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), user.ID)
+}
+
+func TestUnicodeCaseDuplicateUsernames(t *testing.T) {
+	db, err := OpenSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	// Индекс NOCASE пропускал имена, различающиеся регистром не в ASCII
+	_, err = db.db.Exec(migrationsTable)
+	require.Nil(t, err)
+	for _, m := range migrations {
+		if m.Version >= 14 {
+			break
+		}
+		require.Nil(t, db.applyMigration(m))
+	}
+
+	_, err = db.db.Exec(`INSERT INTO users (id, name, password) VALUES (1, 'Иван', 'x'), (2, 'Igor', 'x'), (3, 'иван', 'x')`)
+	require.Nil(t, err)
+
+	err = db.Migrate()
+	assert.ErrorIs(t, err, ErrDuplicateUsernames)
+	assert.Contains(t, err.Error(), "Иван (id 1), иван (id 3);")
+
+	_, err = db.db.Exec(`UPDATE users SET name = 'Иван2' WHERE id = 3`)
+	require.Nil(t, err)
+	require.Nil(t, db.Migrate())
+
+	user, err := db.GetUserByName("ИВАН")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), user.ID)
+}
+
+func TestTimestampsBackfill(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+
+	db, err := OpenSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	// Заметки, созданные до появления отметок времени, получают время миграции
+	_, err = db.db.Exec(migrationsTable)
+	require.Nil(t, err)
+	for _, m := range migrations {
+		if m.Version >= 8 {
+			break
+		}
+		require.Nil(t, db.applyMigration(m))
+	}
+
+	_, err = db.db.Exec(`INSERT INTO users (id, name, password) VALUES (1, 'Ivan', 'x')`)
+	require.Nil(t, err)
+	_, err = db.db.Exec(`INSERT INTO notes (id, title, content, user_id) VALUES (1, 'Beach', 'ocean', 1)`)
+	require.Nil(t, err)
+
+	require.Nil(t, db.Migrate())
+
+	note, err := db.GetNoteByID(1)
+	require.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), note.CreatedAt, time.Minute)
+	assert.Equal(t, note.CreatedAt, note.UpdatedAt)
+
+	// Текущее состояние заметки становится ее первой правкой
+	revisions, err := db.GetRevisionsByNoteID(1)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(revisions))
+	assert.Equal(t, 1, revisions[0].Number)
+	assert.Equal(t, "ocean", revisions[0].Content)
+	assert.Equal(t, note.UpdatedAt, revisions[0].CreatedAt)
+	assert.Equal(t, 1, note.Version)
+
+	user, err := db.GetUserByID(1)
+	require.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), user.CreatedAt, time.Minute)
+	assert.Nil(t, user.LastLoginAt)
+}
+
+func TestPagingBackfilledNotes(t *testing.T) {
+	// Заметки, созданные до отметок времени, получают одинаковое время и листаются только по id
+	db, err := OpenSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	_, err = db.db.Exec(migrationsTable)
+	require.Nil(t, err)
+	for _, m := range migrations {
+		if m.Version >= 8 {
+			break
+		}
+		require.Nil(t, db.applyMigration(m))
+	}
+
+	_, err = db.db.Exec(`INSERT INTO users (id, name, password) VALUES (1, 'Ivan', 'x')`)
+	require.Nil(t, err)
+	for id := 1; id <= 5; id++ {
+		_, err = db.db.Exec(`INSERT INTO notes (id, title, content, user_id) VALUES (?, 'Note', 'x', 1)`, id)
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, db.Migrate())
+
+	for _, q := range []entities.NoteQuery{
+		{Limit: 2, Sort: entities.NoteSortCreated},
+		{Limit: 2, Sort: entities.NoteSortCreated, Desc: true},
+		{Limit: 2, Sort: entities.NoteSortUpdated},
+		{Limit: 2, Sort: entities.NoteSortUpdated, Desc: true},
+	} {
+		ids := []uint64{}
+		for page := 0; page < 5; page++ {
+			notes, err := db.ListNotes(1, q)
+			require.Nil(t, err)
+			for _, n := range notes {
+				ids = append(ids, n.ID)
+			}
+
+			if len(notes) < q.Limit {
+				break
+			}
+			q.After = q.CursorAfter(notes[len(notes)-1])
+		}
+
+		expected := []uint64{1, 2, 3, 4, 5}
+		if q.Desc {
+			expected = []uint64{5, 4, 3, 2, 1}
+		}
+		assert.Equal(t, expected, ids, "%s desc=%v", q.Sort, q.Desc)
+	}
+}
+
+func TestNotebooksBackfill(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+
+	db, err := OpenSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+
+	// Заметки, созданные до появления блокнотов, попадают в блокнот по умолчанию своего автора
+	_, err = db.db.Exec(migrationsTable)
+	require.Nil(t, err)
+	for _, m := range migrations {
+		if m.Version >= 10 {
+			break
+		}
+		require.Nil(t, db.applyMigration(m))
+	}
+
+	_, err = db.db.Exec(`INSERT INTO users (id, name, password) VALUES (1, 'Ivan', 'x'), (2, 'Igor', 'x')`)
+	require.Nil(t, err)
+	_, err = db.db.Exec(`INSERT INTO notes (id, title, content, user_id) VALUES (1, 'Beach', 'ocean', 1), (2, 'Hills', 'forest', 2)`)
+	require.Nil(t, err)
+
+	require.Nil(t, db.Migrate())
+
+	for _, userID := range []uint64{1, 2} {
+		notebooks, err := db.GetNotebooksByUserID(userID)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(notebooks))
+		assert.Equal(t, "Inbox", notebooks[0].Name)
+		assert.Equal(t, 1, notebooks[0].Notes)
+
+		note, err := db.GetNoteByID(userID)
+		require.Nil(t, err)
+		assert.Equal(t, notebooks[0].ID, note.NotebookID)
+	}
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"my_notes_project/internal/entities"
+	"time"
+)
+
+func (s *SQLiteDatabase) AddNotebook(notebook *entities.Notebook) (uint64, error) {
+	// Добавляем блокнот и возвращаем его id, имя среди соседей должно быть свободно
+	res, err := s.db.Exec(`INSERT INTO notebooks (user_id, parent_id, name, created_at) VALUES (?, ?, ?, ?)`,
+		notebook.UserID, nullParentID(notebook.ParentID), notebook.Name, notebook.CreatedAt.UTC())
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	notebook.ID = uint64(id)
+
+	return notebook.ID, nil
+}
+
+func (s *SQLiteDatabase) GetNotebookByID(id uint64) (*entities.Notebook, error) {
+	// Получаем блокнот вместе с числом заметок в нем
+	notebook, err := scanNotebook(s.db.QueryRow(`
+		SELECT nb.id, nb.user_id, nb.parent_id, nb.name, nb.created_at,
+			(SELECT COUNT(*) FROM notes n WHERE n.notebook_id = nb.id AND n.deleted_at IS NULL)
+		FROM notebooks nb
+		WHERE nb.id = ?`, id))
+
+	return notebook, convertError(err)
+}
+
+func (s *SQLiteDatabase) GetNotebooksByUserID(userID uint64) ([]*entities.Notebook, error) {
+	// Все блокноты пользователя по имени, дерево из них строит ядро
+	rows, err := s.db.Query(`
+		SELECT nb.id, nb.user_id, nb.parent_id, nb.name, nb.created_at,
+			(SELECT COUNT(*) FROM notes n WHERE n.notebook_id = nb.id AND n.deleted_at IS NULL)
+		FROM notebooks nb
+		WHERE nb.user_id = ?
+		ORDER BY nb.name COLLATE NOCASE, nb.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notebooks := []*entities.Notebook{}
+	for rows.Next() {
+		notebook, err := scanNotebook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		notebooks = append(notebooks, notebook)
+	}
+
+	return notebooks, rows.Err()
+}
+
+func (s *SQLiteDatabase) UpdateNotebook(notebook *entities.Notebook) error {
+	// Меняем имя и родителя блокнота, так блокнот переименовывается и переносится
+	res, err := s.db.Exec(`UPDATE notebooks SET parent_id = ?, name = ? WHERE id = ?`,
+		nullParentID(notebook.ParentID), notebook.Name, notebook.ID)
+	if err != nil {
+		return convertError(err)
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) RemoveNotebookByID(id uint64, inbox *entities.Notebook, at time.Time) error {
+	// Заметки блокнота и вложенных блокнотов отправляем в корзину и переносим в inbox,
+	// затем удаляем блокнот вместе с вложенными. Блокнот inbox без id создаем
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Пока блокнот существует, заметки держим без блокнота, иначе каскад удалит и их
+	_, err = tx.Exec(`
+		WITH RECURSIVE tree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT nb.id FROM notebooks nb JOIN tree ON nb.parent_id = tree.id
+		)
+		UPDATE notes SET deleted_at = COALESCE(deleted_at, ?), notebook_id = NULL
+		WHERE notebook_id IN (SELECT id FROM tree)`, id, at.UTC())
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM notebooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if err = checkAffected(res); err != nil {
+		return err
+	}
+
+	// Новый блокнот по умолчанию может занять имя удаленного
+	if inbox.ID == 0 {
+		res, err = tx.Exec(`INSERT INTO notebooks (user_id, parent_id, name, created_at) VALUES (?, ?, ?, ?)`,
+			inbox.UserID, nullParentID(inbox.ParentID), inbox.Name, inbox.CreatedAt.UTC())
+		if err != nil {
+			return convertError(err)
+		}
+
+		newID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		inbox.ID = uint64(newID)
+	}
+
+	_, err = tx.Exec(`UPDATE notes SET notebook_id = ? WHERE user_id = ? AND notebook_id IS NULL`, inbox.ID, inbox.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) MoveNote(noteID, notebookID uint64) error {
+	// Переносим заметку в другой блокнот, время изменения не трогаем
+	res, err := s.db.Exec(`UPDATE notes SET notebook_id = ? WHERE id = ?`, notebookID, noteID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func scanNotebook(row rowScanner) (*entities.Notebook, error) {
+	notebook := &entities.Notebook{}
+
+	var parentID sql.NullInt64
+	err := row.Scan(&notebook.ID, &notebook.UserID, &parentID, &notebook.Name, &notebook.CreatedAt, &notebook.Notes)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentID.Valid {
+		id := uint64(parentID.Int64)
+		notebook.ParentID = &id
+	}
+
+	return notebook, nil
+}
+
+func nullParentID(id *uint64) sql.NullInt64 {
+	if id == nil {
+		return sql.NullInt64{}
+	}
+
+	return nullID(*id)
+}
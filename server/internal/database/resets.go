@@ -0,0 +1,42 @@
+package database
+
+import (
+	"my_notes_project/internal/entities"
+)
+
+func (s *SQLiteDatabase) AddPasswordReset(reset *entities.PasswordReset) error {
+	// Сохраняем токен восстановления пароля
+	_, err := s.db.Exec(`INSERT INTO password_resets (id, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		reset.ID, reset.UserID, reset.CreatedAt.UTC(), reset.ExpiresAt.UTC())
+
+	return convertError(err)
+}
+
+func (s *SQLiteDatabase) GetPasswordReset(id string) (*entities.PasswordReset, error) {
+	// Ищем токен по хешу
+	reset := &entities.PasswordReset{}
+	err := s.db.QueryRow(`SELECT id, user_id, created_at, expires_at FROM password_resets WHERE id = ?`, id).
+		Scan(&reset.ID, &reset.UserID, &reset.CreatedAt, &reset.ExpiresAt)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return reset, nil
+}
+
+func (s *SQLiteDatabase) RemovePasswordResetByID(id string) error {
+	// Забираем токен: из двух запросов с одним токеном удалить его сможет только один
+	res, err := s.db.Exec(`DELETE FROM password_resets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) RemovePasswordResetsByUserID(userID uint64) error {
+	// Удаляем все токены пользователя, токен действует один раз
+	_, err := s.db.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID)
+
+	return err
+}
@@ -0,0 +1,64 @@
+package database
+
+import "my_notes_project/internal/entities"
+
+func writeRevision(tx execer, noteID uint64) error {
+	// Копируем сохраненные заголовок и содержимое заметки следующей по номеру правкой.
+	// Заметку меняет только ее автор, поэтому автор правки тот же
+	_, err := tx.Exec(`
+		INSERT INTO note_revisions (note_id, number, title, content, author_id, created_at)
+		SELECT n.id, (SELECT IFNULL(MAX(r.number), 0) + 1 FROM note_revisions r WHERE r.note_id = n.id),
+			n.title, n.content, n.user_id, n.updated_at
+		FROM notes n
+		WHERE n.id = ?`, noteID)
+
+	return err
+}
+
+func (s *SQLiteDatabase) GetRevisionsByNoteID(noteID uint64) ([]*entities.Revision, error) {
+	// Правки заметки от последней к первой
+	rows, err := s.db.Query(`
+		SELECT r.id, r.note_id, r.number, r.title, r.content, r.author_id, u.name, r.created_at
+		FROM note_revisions r
+		JOIN users u ON u.id = r.author_id
+		WHERE r.note_id = ?
+		ORDER BY r.number DESC`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*entities.Revision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+func (s *SQLiteDatabase) GetRevision(noteID uint64, number int) (*entities.Revision, error) {
+	// Одна правка заметки по номеру
+	revision, err := scanRevision(s.db.QueryRow(`
+		SELECT r.id, r.note_id, r.number, r.title, r.content, r.author_id, u.name, r.created_at
+		FROM note_revisions r
+		JOIN users u ON u.id = r.author_id
+		WHERE r.note_id = ? AND r.number = ?`, noteID, number))
+
+	return revision, convertError(err)
+}
+
+func scanRevision(row rowScanner) (*entities.Revision, error) {
+	revision := &entities.Revision{}
+	err := row.Scan(&revision.ID, &revision.NoteID, &revision.Number, &revision.Title, &revision.Content,
+		&revision.AuthorID, &revision.Author, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
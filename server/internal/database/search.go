@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/search"
+	"sort"
+)
+
+// Полнотекстовый индекс по заметкам, обновляется триггерами.
+// Создается при открытии базы, а не миграцией, потому что модуль FTS5
+// есть не во всех сборках SQLite
+const searchIndexSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(title, content, content='notes', content_rowid='id');
+
+CREATE TRIGGER notes_fts_insert AFTER INSERT ON notes BEGIN
+	INSERT INTO notes_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TRIGGER notes_fts_delete AFTER DELETE ON notes BEGIN
+	INSERT INTO notes_fts (notes_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+END;
+
+CREATE TRIGGER notes_fts_update AFTER UPDATE OF title, content ON notes BEGIN
+	INSERT INTO notes_fts (notes_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+	INSERT INTO notes_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+INSERT INTO notes_fts (notes_fts) VALUES ('rebuild');
+`
+
+const dropSearchTriggers = `
+DROP TRIGGER IF EXISTS notes_fts_insert;
+DROP TRIGGER IF EXISTS notes_fts_delete;
+DROP TRIGGER IF EXISTS notes_fts_update;
+`
+
+func (s *SQLiteDatabase) initSearch() error {
+	// Проверяем, собран ли SQLite с FTS5 (go build -tags sqlite_fts5)
+	var enabled bool
+	if err := s.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return err
+	}
+
+	if !enabled {
+		// Триггеры, созданные сборкой с FTS5, без модуля ломают запись заметок
+		s.logger.Warn("SQLite is built without FTS5, search falls back to scanning notes")
+		_, err := s.db.Exec(dropSearchTriggers)
+		return err
+	}
+
+	// Индекс строим заново, только если триггеров не было и он мог отстать от заметок
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'notes_fts_insert')`).
+		Scan(&exists)
+	if err != nil || exists {
+		s.fts = true
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(searchIndexSchema); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	s.logger.Info("full-text search index built")
+	s.fts = true
+
+	return nil
+}
+
+func (s *SQLiteDatabase) SearchNotes(userID uint64, terms []search.Term, limit int) ([]*entities.NoteMatch, error) {
+	if !s.fts {
+		return s.scanSearchNotes(userID, terms, limit)
+	}
+
+	// Совпадения в заголовке весят больше, чем в тексте
+	rows, err := s.db.Query(`
+		SELECT n.id, n.title, n.content, n.user_id, n.notebook_id, n.created_at, n.updated_at, n.version,
+			snippet(notes_fts, -1, char(2), char(3), '…', 24)
+		FROM notes_fts
+		JOIN notes n ON n.id = notes_fts.rowid
+		WHERE notes_fts MATCH ? AND n.user_id = ? AND n.deleted_at IS NULL
+		ORDER BY bm25(notes_fts, 5.0, 1.0), n.id
+		LIMIT ?`, search.MatchExpression(terms), userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []*entities.NoteMatch{}
+	for rows.Next() {
+		match := &entities.NoteMatch{}
+		var notebookID sql.NullInt64
+		note := &entities.Note{}
+		err := rows.Scan(&note.ID, &note.Title, &note.Content, &note.UserID, &notebookID, &note.CreatedAt, &note.UpdatedAt, &note.Version, &match.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		note.NotebookID = uint64(notebookID.Int64)
+		match.Note = note
+		matches = append(matches, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return matches, s.loadMatchTags(matches)
+}
+
+func (s *SQLiteDatabase) scanSearchNotes(userID uint64, terms []search.Term, limit int) ([]*entities.NoteMatch, error) {
+	// Без индекса проверяем все заметки пользователя,
+	// выше те, у которых больше совпадений в заголовке, затем недавно измененные
+	rows, err := s.db.Query(`SELECT id, title, content, user_id, notebook_id, created_at, updated_at, version, deleted_at FROM notes WHERE user_id = ? AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := matchNotes(rows, terms, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, s.loadMatchTags(matches)
+}
+
+func (s *SQLiteDatabase) loadMatchTags(matches []*entities.NoteMatch) error {
+	notes := make([]*entities.Note, 0, len(matches))
+	for _, m := range matches {
+		notes = append(notes, m.Note)
+	}
+
+	return s.loadTags(notes)
+}
+
+func matchNotes(rows *sql.Rows, terms []search.Term, limit int) ([]*entities.NoteMatch, error) {
+	defer rows.Close()
+
+	type scored struct {
+		match   *entities.NoteMatch
+		inTitle int
+	}
+
+	found := []scored{}
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if snippet, inTitle, ok := search.Match(terms, note.Title, note.Content); ok {
+			found = append(found, scored{&entities.NoteMatch{Note: note, Snippet: snippet}, inTitle})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].inTitle != found[j].inTitle {
+			return found[i].inTitle > found[j].inTitle
+		}
+
+		return found[i].match.Note.UpdatedAt.After(found[j].match.Note.UpdatedAt)
+	})
+
+	matches := make([]*entities.NoteMatch, 0, len(found))
+	for i := 0; i < len(found) && i < limit; i++ {
+		matches = append(matches, found[i].match)
+	}
+
+	return matches, nil
+}
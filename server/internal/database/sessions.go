@@ -0,0 +1,49 @@
+package database
+
+import (
+	"my_notes_project/internal/entities"
+)
+
+func (s *SQLiteDatabase) AddSession(session *entities.Session) error {
+	// Сохраняем новую сессию
+	_, err := s.db.Exec(`INSERT INTO sessions (id, user_id, created_at, expires_at, mfa_pending) VALUES (?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.CreatedAt.UTC(), session.ExpiresAt.UTC(), session.MFAPending)
+
+	return convertError(err)
+}
+
+func (s *SQLiteDatabase) GetSessionByID(id string) (*entities.Session, error) {
+	// Ищем сессию по хешу идентификатора
+	session := &entities.Session{}
+	err := s.db.QueryRow(`SELECT id, user_id, created_at, expires_at, mfa_pending FROM sessions WHERE id = ?`, id).
+		Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &session.MFAPending)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return session, nil
+}
+
+func (s *SQLiteDatabase) RemoveSessionByID(id string) error {
+	// Удаляем одну сессию
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) RemoveSessionsByUserID(userID uint64) error {
+	// Удаляем все сессии пользователя
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+
+	return err
+}
+
+func (s *SQLiteDatabase) RemoveSessionsByUserIDExcept(userID uint64, keepID string) error {
+	// Удаляем сессии пользователя, кроме одной
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, keepID)
+
+	return err
+}
@@ -0,0 +1,470 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"my_notes_project/internal/entities"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+type SQLiteDatabase struct {
+	db     *sql.DB
+	logger *logrus.Logger
+	// Доступен ли полнотекстовый индекс notes_fts
+	fts bool
+}
+
+func NewSQLiteDatabase(path string, logger *logrus.Logger) (*SQLiteDatabase, error) {
+	// Открываем базу данных и приводим схему к последней версии
+	s, err := OpenSQLiteDatabase(path, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.Migrate(); err != nil {
+		s.CloseSQLiteDatabase()
+		return nil, err
+	}
+
+	if err = s.initSearch(); err != nil {
+		s.CloseSQLiteDatabase()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func OpenSQLiteDatabase(path string, logger *logrus.Logger) (*SQLiteDatabase, error) {
+	// Открываем базу данных с включенными внешними ключами, схему не трогаем
+	db, err := sql.Open(driverName, fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite не поддерживает параллельную запись, поэтому держим одно соединение
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	logger.Debugf("database opened: %s", path)
+
+	return &SQLiteDatabase{
+		db:     db,
+		logger: logger,
+	}, nil
+}
+
+func (s *SQLiteDatabase) CloseSQLiteDatabase() error {
+	// Закрываем соединение с базой данных
+	return s.db.Close()
+}
+
+func (s *SQLiteDatabase) AddUser(user *entities.User) (uint64, error) {
+	// Добавляем пользователя и возвращаем его id
+	res, err := s.db.Exec(`INSERT INTO users (name, name_key, password, email, created_at) VALUES (?, ?, ?, ?, ?)`,
+		user.Name, UsernameKey(user.Name), user.Password, nullString(user.Email), user.CreatedAt.UTC())
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(id), nil
+}
+
+func (s *SQLiteDatabase) UpdateUser(user *entities.User) error {
+	// Обновляем данные пользователя по id
+	res, err := s.db.Exec(`UPDATE users SET name = ?, name_key = ?, password = ?, email = ? WHERE id = ?`,
+		user.Name, UsernameKey(user.Name), user.Password, nullString(user.Email), user.ID)
+	if err != nil {
+		return convertError(err)
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) UpdateUserLastLogin(id uint64, at time.Time) error {
+	// Запоминаем время последнего входа
+	res, err := s.db.Exec(`UPDATE users SET last_login_at = ? WHERE id = ?`, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) RemoveUserByID(id uint64) error {
+	// Заметки, сессии и токены пользователя удаляются каскадно
+	res, err := s.db.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) AddNote(note *entities.Note) (uint64, error) {
+	// Добавляем заметку вместе с метками и первой правкой и возвращаем ее id
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO notes (title, content, user_id, notebook_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		note.Title, note.Content, note.UserID, nullID(note.NotebookID), note.CreatedAt.UTC(), note.UpdatedAt.UTC())
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	note.ID = uint64(id)
+
+	if len(note.Tags) > 0 {
+		if err = writeNoteTags(tx, note); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = writeRevision(tx, note.ID); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// Новая заметка начинается с первой версии
+	note.Version = 1
+
+	return note.ID, nil
+}
+
+func (s *SQLiteDatabase) RemoveNoteByID(id uint64) error {
+	// Удаляем заметку по id окончательно, в корзину ее переносит TrashNote
+	res, err := s.db.Exec(`DELETE FROM notes WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) UpdateNote(note *entities.Note) error {
+	// Обновляем заголовок, содержимое, блокнот, метки и время изменения заметки,
+	// новое состояние сохраняется следующей правкой. Если указана версия,
+	// заметка меняется только когда ее версия в базе та же
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE notes SET title = ?, content = ?, notebook_id = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND (? = 0 OR version = ?)`,
+		note.Title, note.Content, nullID(note.NotebookID), note.UpdatedAt.UTC(), note.ID, note.Version, note.Version)
+	if err != nil {
+		return err
+	}
+
+	err = checkAffected(res)
+	if errors.Is(err, ErrNotFound) && note.Version != 0 {
+		// Заметка может быть на месте, но уже с другой версией
+		var exists bool
+		if err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM notes WHERE id = ?)`, note.ID).Scan(&exists); err != nil {
+			return err
+		}
+
+		if exists {
+			return ErrVersionMismatch
+		}
+
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var version int
+	if err = tx.QueryRow(`SELECT version FROM notes WHERE id = ?`, note.ID).Scan(&version); err != nil {
+		return err
+	}
+
+	if err = writeNoteTags(tx, note); err != nil {
+		return err
+	}
+
+	if err = writeRevision(tx, note.ID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	note.Version = version
+
+	return nil
+}
+
+func (s *SQLiteDatabase) GetAllNotes() (map[uint64]*entities.Note, error) {
+	// Получаем все заметки
+	rows, err := s.db.Query(`SELECT id, title, content, user_id, notebook_id, created_at, updated_at, version, deleted_at FROM notes`)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := scanNotes(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, s.loadTagsMap(notes)
+}
+
+func (s *SQLiteDatabase) GetNoteByID(id uint64) (*entities.Note, error) {
+	// Получаем одну заметку по id
+	note, err := scanNote(s.db.QueryRow(`SELECT id, title, content, user_id, notebook_id, created_at, updated_at, version, deleted_at FROM notes WHERE id = ?`, id))
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return note, s.loadTags([]*entities.Note{note})
+}
+
+func (s *SQLiteDatabase) GetUserByName(name string) (*entities.User, error) {
+	// Ищем пользователя по имени без учета регистра
+	user, err := scanUser(s.db.QueryRow(`SELECT id, name, password, email, created_at, last_login_at FROM users WHERE name_key = ?`, UsernameKey(name)))
+	return user, convertError(err)
+}
+
+func (s *SQLiteDatabase) GetUserByID(id uint64) (*entities.User, error) {
+	// Ищем пользователя по id
+	user, err := scanUser(s.db.QueryRow(`SELECT id, name, password, email, created_at, last_login_at FROM users WHERE id = ?`, id))
+	return user, convertError(err)
+}
+
+func (s *SQLiteDatabase) GetUserByEmail(email string) (*entities.User, error) {
+	// Ищем пользователя по адресу почты без учета регистра
+	user, err := scanUser(s.db.QueryRow(`SELECT id, name, password, email, created_at, last_login_at FROM users WHERE email = ? COLLATE NOCASE`, email))
+	return user, convertError(err)
+}
+
+func scanUser(row rowScanner) (*entities.User, error) {
+	user := &entities.User{}
+
+	var email sql.NullString
+	var lastLogin sql.NullTime
+	if err := row.Scan(&user.ID, &user.Name, &user.Password, &email, &user.CreatedAt, &lastLogin); err != nil {
+		return nil, err
+	}
+
+	user.Email = email.String
+	if lastLogin.Valid {
+		user.LastLoginAt = &lastLogin.Time
+	}
+
+	return user, nil
+}
+
+func nullString(s string) sql.NullString {
+	// Пустую строку храним как NULL, чтобы не нарушать уникальность
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullID(id uint64) sql.NullInt64 {
+	// Нулевой id означает отсутствие ссылки
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+func (s *SQLiteDatabase) GetNotesByUserName(userName string) (map[uint64]*entities.Note, error) {
+	// Получаем заметки пользователя по его имени, кроме лежащих в корзине
+	rows, err := s.db.Query(`
+		SELECT n.id, n.title, n.content, n.user_id, n.notebook_id, n.created_at, n.updated_at, n.version, n.deleted_at
+		FROM notes n
+		JOIN users u ON u.id = n.user_id
+		WHERE u.name_key = ? AND n.deleted_at IS NULL`, UsernameKey(userName))
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := scanNotes(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, s.loadTagsMap(notes)
+}
+
+func (s *SQLiteDatabase) ListNotes(userID uint64, q entities.NoteQuery) ([]*entities.Note, error) {
+	// Выбираем страницу заметок пользователя, позиция задается курсором, а не смещением,
+	// чтобы добавление и удаление заметок не сдвигало страницы. Заметки из корзины не показываем
+	where := []string{"user_id = ?", "deleted_at IS NULL"}
+	args := []interface{}{userID}
+
+	if q.Title != "" {
+		where = append(where, `title LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q.Title)+"%")
+	}
+
+	if q.NotebookID != 0 {
+		where = append(where, "notebook_id = ?")
+		args = append(args, q.NotebookID)
+	}
+
+	// Для AND заметка должна найтись со всеми метками, для OR хотя бы с одной
+	if len(q.Tags) > 0 {
+		having := ""
+		if !q.AnyTag {
+			having = fmt.Sprintf(" HAVING COUNT(*) = %d", len(q.Tags))
+		}
+
+		where = append(where, fmt.Sprintf(`id IN (
+			SELECT nt.note_id FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+			WHERE t.user_id = ? AND t.name IN (%s)
+			GROUP BY nt.note_id%s)`, placeholders(len(q.Tags)), having))
+		args = append(args, userID)
+		for _, tag := range q.Tags {
+			args = append(args, tag)
+		}
+	}
+
+	cmp, order := ">", "ASC"
+	if q.Desc {
+		cmp, order = "<", "DESC"
+	}
+
+	// Одинаковые значения поля сортировки упорядочиваем по id, чтобы курсор был однозначным
+	column := "created_at"
+	switch q.Sort {
+	case entities.NoteSortUpdated:
+		column = "updated_at"
+	case entities.NoteSortTitle:
+		column = "title"
+	}
+	orderBy := fmt.Sprintf("%s %s, id %s", column, order, order)
+
+	if q.After != nil {
+		var value interface{} = q.After.Title
+		if q.Sort != entities.NoteSortTitle {
+			if q.After.Time == nil {
+				return nil, entities.ErrInvalidCursor
+			}
+
+			value = q.After.Time.UTC()
+		}
+
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, cmp, column, cmp))
+		args = append(args, value, value, q.After.ID)
+	}
+
+	query := fmt.Sprintf(`SELECT id, title, content, user_id, notebook_id, created_at, updated_at, version, deleted_at FROM notes WHERE %s ORDER BY %s LIMIT ?`,
+		strings.Join(where, " AND "), orderBy)
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*entities.Note{}
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		notes = append(notes, note)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notes, s.loadTags(notes)
+}
+
+func escapeLike(s string) string {
+	// Символы % и _ в запросе пользователя ищем буквально
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+func scanNotes(rows *sql.Rows) (map[uint64]*entities.Note, error) {
+	// Читаем заметки из результата запроса
+	defer rows.Close()
+
+	notes := map[uint64]*entities.Note{}
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		notes[note.ID] = note
+	}
+
+	return notes, rows.Err()
+}
+
+func scanNote(row rowScanner) (*entities.Note, error) {
+	note := &entities.Note{}
+
+	var notebookID sql.NullInt64
+	var deletedAt sql.NullTime
+	if err := row.Scan(&note.ID, &note.Title, &note.Content, &note.UserID, &notebookID, &note.CreatedAt, &note.UpdatedAt, &note.Version, &deletedAt); err != nil {
+		return nil, err
+	}
+
+	note.NotebookID = uint64(notebookID.Int64)
+	if deletedAt.Valid {
+		note.DeletedAt = &deletedAt.Time
+	}
+
+	return note, nil
+}
+
+func checkAffected(res sql.Result) error {
+	// Если ни одна строка не изменилась, значит записи не было
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func convertError(err error) error {
+	// Приводим ошибки драйвера к ошибкам пакета
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrAlreadyExists
+	}
+
+	return err
+}
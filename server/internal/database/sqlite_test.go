@@ -0,0 +1,809 @@
+package database
+
+import (
+	"fmt"
+	"my_notes_project/internal/entities"
+	"my_notes_project/internal/search"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserNameUniqueIgnoresCase(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	// NOCASE в SQLite складывает только ASCII, остальные алфавиты сравниваются по ключу
+	for _, tt := range []struct{ name, other, lookup string }{
+		{"Ivan", "ivan", "IVAN"},
+		{"Иван", "иван", "ИВАН"},
+		{"Émile", "émile", "ÉMILE"},
+		{"Zoë", "zoe\u0308", "ZOË"},
+	} {
+		_, err = db.AddUser(&entities.User{Name: tt.name, Password: "x"})
+		assert.Nil(t, err, tt.name)
+
+		_, err = db.AddUser(&entities.User{Name: tt.other, Password: "x"})
+		assert.ErrorIs(t, err, ErrAlreadyExists, tt.other)
+
+		user, err := db.GetUserByName(tt.lookup)
+		if assert.Nil(t, err, tt.lookup) {
+			assert.Equal(t, tt.name, user.Name)
+		}
+	}
+}
+
+func TestLoginAttempt(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	_, err = db.GetLoginAttempt("user:ivan")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.Nil(t, db.SaveLoginAttempt(&entities.LoginAttempt{Key: "user:ivan", Failures: 1, LastFailureAt: now}))
+
+	lockedUntil := now.Add(time.Minute)
+	assert.Nil(t, db.SaveLoginAttempt(&entities.LoginAttempt{
+		Key:           "user:ivan",
+		Failures:      2,
+		LastFailureAt: now,
+		LockedUntil:   &lockedUntil,
+	}))
+
+	attempt, err := db.GetLoginAttempt("user:ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempt.Failures)
+	assert.True(t, lockedUntil.Equal(*attempt.LockedUntil))
+
+	assert.Nil(t, db.RemoveLoginAttempt("user:ivan"))
+	_, err = db.GetLoginAttempt("user:ivan")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRemoveUserCascades(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+	igor, err := db.AddUser(&entities.User{Name: "Igor", Password: "x"})
+	require.Nil(t, err)
+
+	_, err = db.AddNote(&entities.Note{Title: "Beach", Content: "ocean", UserID: ivan})
+	require.Nil(t, err)
+	_, err = db.AddNote(&entities.Note{Title: "Hills", Content: "forest", UserID: igor})
+	require.Nil(t, err)
+
+	now := time.Now()
+	require.Nil(t, db.AddSession(&entities.Session{ID: "s1", UserID: ivan, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	assert.Nil(t, db.RemoveUserByID(ivan))
+	assert.ErrorIs(t, db.RemoveUserByID(ivan), ErrNotFound)
+
+	notes, err := db.GetAllNotes()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+
+	_, err = db.GetSessionByID("s1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUserEmail(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	// Пользователи без почты не конфликтуют друг с другом
+	_, err = db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	assert.Nil(t, err)
+	_, err = db.AddUser(&entities.User{Name: "Igor", Password: "x"})
+	assert.Nil(t, err)
+
+	_, err = db.GetUserByEmail("")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	ivan, err := db.GetUserByName("Ivan")
+	require.Nil(t, err)
+	ivan.Email = "ivan@example.com"
+	assert.Nil(t, db.UpdateUser(ivan))
+
+	igor, err := db.GetUserByName("Igor")
+	require.Nil(t, err)
+	igor.Email = "IVAN@example.com"
+	assert.ErrorIs(t, db.UpdateUser(igor), ErrAlreadyExists)
+
+	user, err := db.GetUserByEmail("Ivan@Example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "Ivan", user.Name)
+	assert.Equal(t, "ivan@example.com", user.Email)
+}
+
+func TestTOTPStorage(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	_, err = db.GetTOTP(ivan)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	assert.Nil(t, db.SaveTOTP(&entities.TOTP{UserID: ivan, Secret: "ABC", CreatedAt: now}))
+	assert.Nil(t, db.SaveTOTP(&entities.TOTP{UserID: ivan, Secret: "ABC", Enabled: true, LastCounter: 42, CreatedAt: now}))
+
+	totp, err := db.GetTOTP(ivan)
+	assert.Nil(t, err)
+	assert.True(t, totp.Enabled)
+	assert.Equal(t, uint64(42), totp.LastCounter)
+
+	// Окно сдвигается только вперед
+	assert.ErrorIs(t, db.AdvanceTOTPCounter(ivan, 42), ErrNotFound)
+	assert.ErrorIs(t, db.AdvanceTOTPCounter(ivan, 41), ErrNotFound)
+	assert.Nil(t, db.AdvanceTOTPCounter(ivan, 43))
+	totp, err = db.GetTOTP(ivan)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(43), totp.LastCounter)
+
+	// Код восстановления срабатывает только один раз
+	assert.Nil(t, db.ReplaceRecoveryCodes(ivan, []string{"h1", "h2"}))
+	assert.Nil(t, db.UseRecoveryCode(ivan, "h1"))
+	assert.ErrorIs(t, db.UseRecoveryCode(ivan, "h1"), ErrNotFound)
+
+	count, err := db.CountRecoveryCodes(ivan)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	require.Nil(t, db.AddSession(&entities.Session{ID: "s1", UserID: ivan, MFAPending: true, CreatedAt: now, ExpiresAt: now.Add(time.Minute)}))
+	session, err := db.GetSessionByID("s1")
+	assert.Nil(t, err)
+	assert.True(t, session.MFAPending)
+
+	assert.Nil(t, db.RemoveTOTP(ivan))
+	_, err = db.GetTOTP(ivan)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	count, err = db.CountRecoveryCodes(ivan)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestPasswordResets(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, id := range []string{"h1", "h2"} {
+		require.Nil(t, db.AddPasswordReset(&entities.PasswordReset{ID: id, UserID: ivan, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	}
+
+	reset, err := db.GetPasswordReset("h1")
+	assert.Nil(t, err)
+	assert.Equal(t, ivan, reset.UserID)
+	assert.True(t, now.Add(time.Hour).Equal(reset.ExpiresAt))
+
+	// Токен забирается один раз
+	assert.Nil(t, db.RemovePasswordResetByID("h1"))
+	assert.ErrorIs(t, db.RemovePasswordResetByID("h1"), ErrNotFound)
+	_, err = db.GetPasswordReset("h1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Nil(t, db.RemovePasswordResetsByUserID(ivan))
+	_, err = db.GetPasswordReset("h2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGetNoteByID(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	id, err := db.AddNote(&entities.Note{Title: "Beach", Content: "ocean", UserID: ivan})
+	require.Nil(t, err)
+
+	note, err := db.GetNoteByID(id)
+	assert.Nil(t, err)
+	assert.Equal(t, &entities.Note{ID: id, Title: "Beach", Content: "ocean", UserID: ivan, Version: 1}, note)
+
+	_, err = db.GetNoteByID(id + 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestListNotes(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+	igor, err := db.AddUser(&entities.User{Name: "Igor", Password: "x"})
+	require.Nil(t, err)
+
+	for _, title := range []string{"Beta", "Alpha", "100% done", "Beta", "Alpha_1"} {
+		_, err = db.AddNote(&entities.Note{Title: title, Content: "x", UserID: ivan})
+		require.Nil(t, err)
+	}
+	_, err = db.AddNote(&entities.Note{Title: "Alpha", Content: "x", UserID: igor})
+	require.Nil(t, err)
+
+	titles := func(notes []*entities.Note) []string {
+		result := []string{}
+		for _, n := range notes {
+			result = append(result, n.Title)
+		}
+		return result
+	}
+
+	// Вторая страница по заголовку начинается после курсора, одинаковые заголовки упорядочены по id
+	q := entities.NoteQuery{Limit: 2, Sort: entities.NoteSortTitle}
+	notes, err := db.ListNotes(ivan, q)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"100% done", "Alpha"}, titles(notes))
+
+	q.After = q.CursorAfter(notes[1])
+	notes, err = db.ListNotes(ivan, q)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Alpha_1", "Beta"}, titles(notes))
+
+	q.After = q.CursorAfter(notes[1])
+	notes, err = db.ListNotes(ivan, q)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Beta"}, titles(notes))
+
+	notes, err = db.ListNotes(ivan, entities.NoteQuery{Limit: 10, Desc: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Alpha_1", "Beta", "100% done", "Alpha", "Beta"}, titles(notes))
+
+	// Символы % и _ в фильтре не работают как шаблон
+	notes, err = db.ListNotes(ivan, entities.NoteQuery{Limit: 10, Title: "_"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Alpha_1"}, titles(notes))
+
+	notes, err = db.ListNotes(ivan, entities.NoteQuery{Limit: 10, Title: "0%"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"100% done"}, titles(notes))
+}
+
+func TestTimestamps(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x", CreatedAt: created})
+	require.Nil(t, err)
+
+	login := created.Add(time.Hour)
+	assert.Nil(t, db.UpdateUserLastLogin(ivan, login))
+
+	user, err := db.GetUserByID(ivan)
+	assert.Nil(t, err)
+	assert.True(t, created.Equal(user.CreatedAt))
+	require.NotNil(t, user.LastLoginAt)
+	assert.True(t, login.Equal(*user.LastLoginAt))
+
+	// Первая заметка изменена последней
+	for i, title := range []string{"Beach", "Hills", "Lake"} {
+		at := created.Add(time.Duration(i) * time.Minute)
+		_, err = db.AddNote(&entities.Note{Title: title, Content: "x", UserID: ivan, CreatedAt: at, UpdatedAt: at})
+		require.Nil(t, err)
+	}
+
+	notes, err := db.ListNotes(ivan, entities.NoteQuery{Limit: 10, Sort: entities.NoteSortCreated})
+	require.Nil(t, err)
+	beach := notes[0]
+	beach.UpdatedAt = created.Add(time.Hour)
+	assert.Nil(t, db.UpdateNote(beach))
+
+	q := entities.NoteQuery{Limit: 2, Sort: entities.NoteSortUpdated, Desc: true}
+	notes, err = db.ListNotes(ivan, q)
+	assert.Nil(t, err)
+	require.Equal(t, 2, len(notes))
+	assert.Equal(t, "Beach", notes[0].Title)
+	assert.True(t, created.Equal(notes[0].CreatedAt))
+	assert.True(t, beach.UpdatedAt.Equal(notes[0].UpdatedAt))
+	assert.Equal(t, "Lake", notes[1].Title)
+
+	q.After = q.CursorAfter(notes[1])
+	notes, err = db.ListNotes(ivan, q)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(notes))
+	assert.Equal(t, "Hills", notes[0].Title)
+}
+
+func TestSearchNotes(t *testing.T) {
+	// Проверяется и с индексом FTS5 (go test -tags sqlite_fts5), и без него
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+	igor, err := db.AddUser(&entities.User{Name: "Igor", Password: "x"})
+	require.Nil(t, err)
+
+	now := time.Now()
+	add := func(userID uint64, title, content string) uint64 {
+		id, err := db.AddNote(&entities.Note{Title: title, Content: content, UserID: userID, CreatedAt: now, UpdatedAt: now})
+		require.Nil(t, err)
+		return id
+	}
+
+	beach := add(ivan, "Beach", "Sandy beach with a nice ocean view")
+	add(ivan, "Forest", "Walk along the river")
+	ocean := add(ivan, "Ocean trip", "Boat and waves")
+	add(igor, "Ocean", "Someone else's ocean")
+
+	titles := func(query string) []string {
+		matches, err := db.SearchNotes(ivan, search.Parse(query), 10)
+		require.Nil(t, err)
+
+		result := []string{}
+		for _, m := range matches {
+			result = append(result, m.Note.Title)
+		}
+		return result
+	}
+
+	// Совпадение в заголовке ранжируется выше совпадения в тексте
+	assert.Equal(t, []string{"Ocean trip", "Beach"}, titles("ocean"))
+	assert.Equal(t, []string{"Ocean trip", "Beach"}, titles("oce*"))
+	assert.Equal(t, []string{"Beach"}, titles(`"nice ocean"`))
+	assert.Empty(t, titles(`"ocean nice"`))
+	assert.Equal(t, []string{"Forest"}, titles("RIVER walk"))
+	assert.Empty(t, titles("riv"))
+
+	matches, err := db.SearchNotes(ivan, search.Parse("sandy"), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(matches))
+	assert.Contains(t, matches[0].Snippet, search.HighlightStart+"Sandy"+search.HighlightEnd)
+
+	// Индекс следует за изменением и удалением заметок
+	require.Nil(t, db.UpdateNote(&entities.Note{ID: beach, Title: "Beach", Content: "Pebbles", UpdatedAt: now}))
+	assert.Equal(t, []string{"Ocean trip"}, titles("ocean"))
+	assert.Equal(t, []string{"Beach"}, titles("pebbles"))
+
+	require.Nil(t, db.RemoveNoteByID(ocean))
+	assert.Empty(t, titles("ocean"))
+}
+
+func TestSearchIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+	db, err := NewSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+
+	if !db.fts {
+		db.CloseSQLiteDatabase()
+		t.Skip("SQLite is built without FTS5")
+	}
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	// Сборка без FTS5 удаляет триггеры, и индекс отстает от заметок
+	_, err = db.db.Exec(dropSearchTriggers)
+	require.Nil(t, err)
+	_, err = db.AddNote(&entities.Note{Title: "Beach", Content: "ocean", UserID: ivan})
+	require.Nil(t, err)
+	require.Nil(t, db.CloseSQLiteDatabase())
+
+	db, err = NewSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	matches, err := db.SearchNotes(ivan, search.Parse("ocean"), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(matches))
+}
+
+func TestSearchWithoutFTS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+	db, err := NewSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+
+	if db.fts {
+		db.CloseSQLiteDatabase()
+		t.Skip("SQLite is built with FTS5")
+	}
+
+	// Триггер, оставшийся от сборки с FTS5, удаляется при открытии и не мешает записи
+	_, err = db.db.Exec(`CREATE TRIGGER notes_fts_insert AFTER INSERT ON notes BEGIN
+		INSERT INTO notes_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+	END`)
+	require.Nil(t, err)
+	require.Nil(t, db.CloseSQLiteDatabase())
+
+	db, err = NewSQLiteDatabase(path, logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+	_, err = db.AddNote(&entities.Note{Title: "Beach", Content: "ocean", UserID: ivan})
+	assert.Nil(t, err)
+}
+
+func TestTags(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+	igor, err := db.AddUser(&entities.User{Name: "Igor", Password: "x"})
+	require.Nil(t, err)
+
+	add := func(userID uint64, title string, tags ...string) *entities.Note {
+		note := &entities.Note{Title: title, Content: "text", UserID: userID, Tags: tags}
+		_, err := db.AddNote(note)
+		require.Nil(t, err)
+		return note
+	}
+
+	beach := add(ivan, "Beach", "summer", "travel")
+	hills := add(ivan, "Hills", "hiking", "travel")
+	add(ivan, "Plain")
+	add(igor, "Igor", "travel")
+
+	note, err := db.GetNoteByID(beach.ID)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"summer", "travel"}, note.Tags)
+
+	titles := func(tags []string, anyTag bool) []string {
+		notes, err := db.ListNotes(ivan, entities.NoteQuery{Limit: 10, Tags: tags, AnyTag: anyTag})
+		require.Nil(t, err)
+
+		result := []string{}
+		for _, n := range notes {
+			result = append(result, n.Title)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"Beach", "Hills"}, titles([]string{"travel"}, false))
+	assert.Equal(t, []string{"Hills"}, titles([]string{"travel", "hiking"}, false))
+	assert.Equal(t, []string{"Beach", "Hills"}, titles([]string{"summer", "hiking"}, true))
+	assert.Empty(t, titles([]string{"summer", "hiking"}, false))
+
+	// Метка, которая больше нигде не стоит, удаляется
+	hills.Tags = []string{"travel", "rivers"}
+	require.Nil(t, db.UpdateNote(hills))
+
+	tags, err := db.GetTagsByUserID(ivan)
+	require.Nil(t, err)
+	names := []string{}
+	for _, tag := range tags {
+		names = append(names, fmt.Sprintf("%s:%d", tag.Name, tag.Notes))
+	}
+	assert.Equal(t, []string{"rivers:1", "summer:1", "travel:2"}, names)
+
+	// Имена меток уникальны в пределах пользователя
+	assert.ErrorIs(t, db.RenameTag(ivan, "rivers", "travel"), ErrAlreadyExists)
+	assert.ErrorIs(t, db.RenameTag(ivan, "missing", "other"), ErrNotFound)
+	require.Nil(t, db.RenameTag(ivan, "rivers", "water"))
+
+	require.Nil(t, db.MergeTags(ivan, "summer", "travel"))
+	assert.ErrorIs(t, db.MergeTags(ivan, "summer", "travel"), ErrNotFound)
+
+	note, err = db.GetNoteByID(beach.ID)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"travel"}, note.Tags)
+
+	hills.Tags = []string{"hiking"}
+	require.Nil(t, db.SetNoteTags(hills))
+	assert.ErrorIs(t, db.SetNoteTags(&entities.Note{ID: 1000, UserID: ivan}), ErrNotFound)
+
+	require.Nil(t, db.RemoveTag(ivan, "travel"))
+	assert.ErrorIs(t, db.RemoveTag(ivan, "travel"), ErrNotFound)
+	assert.Empty(t, titles([]string{"travel"}, false))
+
+	// Удаление заметки убирает ее единственную метку
+	require.Nil(t, db.RemoveNoteByID(hills.ID))
+	tags, err = db.GetTagsByUserID(ivan)
+	require.Nil(t, err)
+	assert.Empty(t, tags)
+
+	tags, err = db.GetTagsByUserID(igor)
+	require.Nil(t, err)
+	if assert.Equal(t, 1, len(tags)) {
+		assert.Equal(t, "travel", tags[0].Name)
+	}
+}
+
+func TestNotebooks(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	add := func(name string, parentID *uint64) *entities.Notebook {
+		nb := &entities.Notebook{UserID: ivan, ParentID: parentID, Name: name, CreatedAt: time.Now()}
+		_, err := db.AddNotebook(nb)
+		require.Nil(t, err)
+		return nb
+	}
+
+	work := add("Work", nil)
+	projects := add("Projects", &work.ID)
+	archive := add("Archive", &projects.ID)
+
+	// Имя уникально среди соседей, в том числе на верхнем уровне
+	_, err = db.AddNotebook(&entities.Notebook{UserID: ivan, Name: "work", CreatedAt: time.Now()})
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+	_, err = db.AddNotebook(&entities.Notebook{UserID: ivan, ParentID: &work.ID, Name: "PROJECTS", CreatedAt: time.Now()})
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+	inbox := add("Projects", nil)
+
+	note := &entities.Note{Title: "Plan", Content: "text", UserID: ivan, NotebookID: archive.ID, Tags: []string{"plan"}}
+	_, err = db.AddNote(note)
+	require.Nil(t, err)
+
+	found, err := db.GetNotebookByID(archive.ID)
+	require.Nil(t, err)
+	assert.Equal(t, projects.ID, *found.ParentID)
+	assert.Equal(t, 1, found.Notes)
+
+	notes, err := db.ListNotes(ivan, entities.NoteQuery{Limit: 10, NotebookID: archive.ID})
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+	notes, err = db.ListNotes(ivan, entities.NoteQuery{Limit: 10, NotebookID: work.ID})
+	require.Nil(t, err)
+	assert.Empty(t, notes)
+
+	// Перенос и переименование меняют родителя и имя
+	archive.ParentID = nil
+	archive.Name = "Old"
+	require.Nil(t, db.UpdateNotebook(archive))
+	require.Nil(t, db.MoveNote(note.ID, work.ID))
+	note, err = db.GetNoteByID(note.ID)
+	require.Nil(t, err)
+	assert.Equal(t, work.ID, note.NotebookID)
+
+	// Удаление блокнота удаляет вложенные блокноты, а их заметки переносит в корзину
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.Nil(t, db.RemoveNotebookByID(work.ID, &entities.Notebook{ID: inbox.ID, UserID: ivan}, now))
+	_, err = db.GetNotebookByID(projects.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	trash, err := db.GetTrashByUserID(ivan)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(trash))
+	assert.Equal(t, note.ID, trash[0].ID)
+	assert.Equal(t, inbox.ID, trash[0].NotebookID)
+	assert.True(t, now.Equal(*trash[0].DeletedAt))
+	assert.Equal(t, []string{"plan"}, trash[0].Tags)
+
+	notebooks, err := db.GetNotebooksByUserID(ivan)
+	require.Nil(t, err)
+	names := []string{}
+	for _, nb := range notebooks {
+		names = append(names, nb.Name)
+	}
+	assert.Equal(t, []string{"Old", "Projects"}, names)
+
+	// Вместо последнего блокнота верхнего уровня создается новый, даже с тем же именем
+	require.Nil(t, db.RestoreNote(note.ID))
+	require.Nil(t, db.RemoveNotebookByID(archive.ID, &entities.Notebook{ID: inbox.ID, UserID: ivan}, now))
+	replacement := &entities.Notebook{UserID: ivan, Name: "Projects", CreatedAt: now}
+	require.Nil(t, db.RemoveNotebookByID(inbox.ID, replacement, now.Add(time.Hour)))
+	assert.NotEqual(t, inbox.ID, replacement.ID)
+
+	notebooks, err = db.GetNotebooksByUserID(ivan)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(notebooks))
+	assert.Equal(t, replacement.ID, notebooks[0].ID)
+
+	trash, err = db.GetTrashByUserID(ivan)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(trash))
+	assert.Equal(t, replacement.ID, trash[0].NotebookID)
+	assert.True(t, now.Add(time.Hour).Equal(*trash[0].DeletedAt))
+}
+
+func TestRevisions(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	note := &entities.Note{Title: "Plan", Content: "one", UserID: ivan, CreatedAt: created, UpdatedAt: created}
+	_, err = db.AddNote(note)
+	require.Nil(t, err)
+
+	// Каждое изменение заметки добавляет правку со следующим номером
+	note.Content = "two"
+	note.UpdatedAt = created.Add(time.Hour)
+	require.Nil(t, db.UpdateNote(note))
+
+	revisions, err := db.GetRevisionsByNoteID(note.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(revisions))
+	assert.Equal(t, 2, revisions[0].Number)
+	assert.Equal(t, "two", revisions[0].Content)
+	assert.Equal(t, "Ivan", revisions[0].Author)
+	assert.Equal(t, note.UpdatedAt, revisions[0].CreatedAt)
+	assert.Equal(t, "one", revisions[1].Content)
+
+	first, err := db.GetRevision(note.ID, 1)
+	require.Nil(t, err)
+	assert.Equal(t, revisions[1], first)
+	_, err = db.GetRevision(note.ID, 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Изменение несуществующей заметки не оставляет правки
+	assert.ErrorIs(t, db.UpdateNote(&entities.Note{ID: note.ID + 1, Title: "T", Content: "C", UserID: ivan}), ErrNotFound)
+	revisions, err = db.GetRevisionsByNoteID(note.ID + 1)
+	require.Nil(t, err)
+	assert.Empty(t, revisions)
+
+	// Правки не меняются и удаляются вместе с заметкой
+	_, err = db.db.Exec(`UPDATE note_revisions SET content = 'changed' WHERE note_id = ?`, note.ID)
+	assert.NotNil(t, err)
+
+	require.Nil(t, db.RemoveNoteByID(note.ID))
+	revisions, err = db.GetRevisionsByNoteID(note.ID)
+	require.Nil(t, err)
+	assert.Empty(t, revisions)
+}
+
+func TestNoteVersion(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+
+	note := &entities.Note{Title: "Plan", Content: "one", UserID: ivan}
+	_, err = db.AddNote(note)
+	require.Nil(t, err)
+	assert.Equal(t, 1, note.Version)
+
+	// Изменение с текущей версией увеличивает ее
+	update := &entities.Note{ID: note.ID, Title: "Plan", Content: "two", UserID: ivan, Version: 1}
+	require.Nil(t, db.UpdateNote(update))
+	assert.Equal(t, 2, update.Version)
+
+	// Устаревшая версия не меняет заметку и не добавляет правку
+	stale := &entities.Note{ID: note.ID, Title: "Plan", Content: "stale", UserID: ivan, Version: 1}
+	assert.ErrorIs(t, db.UpdateNote(stale), ErrVersionMismatch)
+	assert.Equal(t, 1, stale.Version)
+
+	stored, err := db.GetNoteByID(note.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "two", stored.Content)
+	assert.Equal(t, 2, stored.Version)
+
+	revisions, err := db.GetRevisionsByNoteID(note.ID)
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+
+	// Без версии изменение не проверяется, для несуществующей заметки ошибка прежняя
+	forced := &entities.Note{ID: note.ID, Title: "Plan", Content: "forced", UserID: ivan}
+	require.Nil(t, db.UpdateNote(forced))
+	assert.Equal(t, 3, forced.Version)
+	assert.ErrorIs(t, db.UpdateNote(&entities.Note{ID: note.ID + 1, Title: "T", Content: "C", Version: 1}), ErrNotFound)
+}
+
+func TestTrash(t *testing.T) {
+	db, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "notes.db"), logrus.New())
+	require.Nil(t, err)
+	defer db.CloseSQLiteDatabase()
+
+	ivan, err := db.AddUser(&entities.User{Name: "Ivan", Password: "x"})
+	require.Nil(t, err)
+	igor, err := db.AddUser(&entities.User{Name: "Igor", Password: "x"})
+	require.Nil(t, err)
+
+	nb := &entities.Notebook{UserID: ivan, Name: "Trips", CreatedAt: time.Now()}
+	_, err = db.AddNotebook(nb)
+	require.Nil(t, err)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	add := func(userID uint64, title string) *entities.Note {
+		note := &entities.Note{Title: title, Content: title + " ocean", UserID: userID, Tags: []string{"travel"}, CreatedAt: now, UpdatedAt: now}
+		if userID == ivan {
+			note.NotebookID = nb.ID
+		}
+		_, err := db.AddNote(note)
+		require.Nil(t, err)
+		return note
+	}
+
+	beach := add(ivan, "Beach")
+	hills := add(ivan, "Hills")
+	lake := add(igor, "Lake")
+
+	require.Nil(t, db.TrashNote(beach.ID, now))
+	assert.ErrorIs(t, db.TrashNote(beach.ID, now), ErrNotFound)
+	assert.ErrorIs(t, db.TrashNote(lake.ID+1, now), ErrNotFound)
+
+	// Заметка из корзины не попадает в списки, поиск и счетчики
+	notes, err := db.GetNotesByUserName("Ivan")
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+	assert.NotNil(t, notes[hills.ID])
+
+	page, err := db.ListNotes(ivan, entities.NoteQuery{Limit: 10})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(page))
+	assert.Equal(t, hills.ID, page[0].ID)
+
+	matches, err := db.SearchNotes(ivan, search.Parse("beach"), 10)
+	require.Nil(t, err)
+	assert.Empty(t, matches)
+
+	tags, err := db.GetTagsByUserID(ivan)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tags))
+	assert.Equal(t, 1, tags[0].Notes)
+
+	notebook, err := db.GetNotebookByID(nb.ID)
+	require.Nil(t, err)
+	assert.Equal(t, 1, notebook.Notes)
+
+	// По id заметка доступна вместе с отметкой удаления
+	stored, err := db.GetNoteByID(beach.ID)
+	require.Nil(t, err)
+	require.NotNil(t, stored.DeletedAt)
+	assert.True(t, now.Equal(*stored.DeletedAt))
+
+	trash, err := db.GetTrashByUserID(ivan)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(trash))
+	assert.Equal(t, beach.ID, trash[0].ID)
+	assert.Equal(t, []string{"travel"}, trash[0].Tags)
+
+	// Восстановленная заметка возвращается в поиск
+	require.Nil(t, db.RestoreNote(beach.ID))
+	assert.ErrorIs(t, db.RestoreNote(beach.ID), ErrNotFound)
+	matches, err = db.SearchNotes(ivan, search.Parse("beach"), 10)
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(matches))
+
+	// Очистка корзины затрагивает только ее владельца
+	require.Nil(t, db.TrashNote(beach.ID, now))
+	require.Nil(t, db.TrashNote(lake.ID, now))
+	n, err := db.EmptyTrash(ivan)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = db.GetNoteByID(beach.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	revisions, err := db.GetRevisionsByNoteID(beach.ID)
+	require.Nil(t, err)
+	assert.Empty(t, revisions)
+
+	// Очистка по сроку удаляет только заметки, попавшие в корзину раньше него
+	require.Nil(t, db.TrashNote(hills.ID, now.Add(time.Hour)))
+	n, err = db.PurgeTrash(now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = db.GetNoteByID(lake.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	trash, err = db.GetTrashByUserID(ivan)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(trash))
+	assert.Equal(t, hills.ID, trash[0].ID)
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"my_notes_project/internal/entities"
+	"strings"
+)
+
+// Запросы и транзакции, в которых можно записать метки заметки
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *SQLiteDatabase) SetNoteTags(note *entities.Note) error {
+	// Заменяем метки заметки, заголовок и содержимое не меняются
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM notes WHERE id = ?)`, note.ID).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrNotFound
+	}
+
+	if err = writeNoteTags(tx, note); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func writeNoteTags(tx execer, note *entities.Note) error {
+	// Метки, которых у пользователя еще нет, создаем, снятые с заметки убираем.
+	// Метку, которая больше нигде не стоит, удаляет триггер note_tags_cleanup
+	if len(note.Tags) == 0 {
+		_, err := tx.Exec(`DELETE FROM note_tags WHERE note_id = ?`, note.ID)
+		return err
+	}
+
+	args := []interface{}{note.ID, note.UserID}
+	for _, name := range note.Tags {
+		args = append(args, name)
+	}
+
+	_, err := tx.Exec(`
+		DELETE FROM note_tags
+		WHERE note_id = ? AND tag_id NOT IN (SELECT id FROM tags WHERE user_id = ? AND name IN (`+placeholders(len(note.Tags))+`))`,
+		args...)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range note.Tags {
+		if _, err = tx.Exec(`INSERT OR IGNORE INTO tags (user_id, name) VALUES (?, ?)`, note.UserID, name); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT OR IGNORE INTO note_tags (note_id, tag_id) SELECT ?, id FROM tags WHERE user_id = ? AND name = ?`,
+			note.ID, note.UserID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteDatabase) GetTagsByUserID(userID uint64) ([]*entities.Tag, error) {
+	// Метки пользователя по алфавиту вместе с числом заметок, заметки из корзины не считаем
+	rows, err := s.db.Query(`
+		SELECT t.id, t.user_id, t.name, COUNT(n.id)
+		FROM tags t
+		LEFT JOIN note_tags nt ON nt.tag_id = t.id
+		LEFT JOIN notes n ON n.id = nt.note_id AND n.deleted_at IS NULL
+		WHERE t.user_id = ?
+		GROUP BY t.id
+		ORDER BY t.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*entities.Tag{}
+	for rows.Next() {
+		tag := &entities.Tag{}
+		if err = rows.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.Notes); err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *SQLiteDatabase) RenameTag(userID uint64, from, to string) error {
+	// Переименовываем метку на всех заметках сразу, занятое имя нарушает уникальность
+	res, err := s.db.Exec(`UPDATE tags SET name = ? WHERE user_id = ? AND name = ?`, to, userID, from)
+	if err != nil {
+		return convertError(err)
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) MergeTags(userID uint64, from, into string) error {
+	// Ставим метку into на все заметки с меткой from, затем удаляем from
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fromID uint64
+	err = tx.QueryRow(`SELECT id FROM tags WHERE user_id = ? AND name = ?`, userID, from).Scan(&fromID)
+	if err != nil {
+		return convertError(err)
+	}
+
+	if _, err = tx.Exec(`INSERT OR IGNORE INTO tags (user_id, name) VALUES (?, ?)`, userID, into); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO note_tags (note_id, tag_id)
+		SELECT nt.note_id, t.id FROM note_tags nt, tags t
+		WHERE nt.tag_id = ? AND t.user_id = ? AND t.name = ?`, fromID, userID, into)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM tags WHERE id = ?`, fromID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) RemoveTag(userID uint64, name string) error {
+	// Снимаем метку со всех заметок пользователя
+	res, err := s.db.Exec(`DELETE FROM tags WHERE user_id = ? AND name = ?`, userID, name)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// Сколько заметок загружаем метки за один запрос, чтобы не превысить число параметров
+const tagsBatchSize = 500
+
+func (s *SQLiteDatabase) loadTags(notes []*entities.Note) error {
+	// Заполняем метки уже прочитанных заметок
+	byID := make(map[uint64]*entities.Note, len(notes))
+	for _, note := range notes {
+		byID[note.ID] = note
+	}
+
+	for start := 0; start < len(notes); start += tagsBatchSize {
+		batch := notes[start:min(start+tagsBatchSize, len(notes))]
+
+		args := make([]interface{}, 0, len(batch))
+		for _, note := range batch {
+			args = append(args, note.ID)
+		}
+
+		rows, err := s.db.Query(`
+			SELECT nt.note_id, t.name
+			FROM note_tags nt
+			JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.note_id IN (`+placeholders(len(batch))+`)
+			ORDER BY t.name`, args...)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var noteID uint64
+			var name string
+			if err = rows.Scan(&noteID, &name); err != nil {
+				rows.Close()
+				return err
+			}
+
+			byID[noteID].Tags = append(byID[noteID].Tags, name)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteDatabase) loadTagsMap(notes map[uint64]*entities.Note) error {
+	list := make([]*entities.Note, 0, len(notes))
+	for _, note := range notes {
+		list = append(list, note)
+	}
+
+	return s.loadTags(list)
+}
+
+func placeholders(n int) string {
+	// Список "?, ?, ?" для условия IN
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
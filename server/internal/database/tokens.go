@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"my_notes_project/internal/entities"
+	"strings"
+	"time"
+)
+
+const tokenColumns = `id, user_id, name, hash, scopes, created_at, expires_at, last_used_at`
+
+func (s *SQLiteDatabase) AddAPIToken(token *entities.APIToken) (uint64, error) {
+	// Сохраняем токен, области доступа храним через пробел
+	res, err := s.db.Exec(`
+		INSERT INTO api_tokens (user_id, name, hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		token.UserID, token.Name, token.Hash, strings.Join(token.Scopes, " "),
+		token.CreatedAt.UTC(), nullTime(token.ExpiresAt))
+	if err != nil {
+		return 0, convertError(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	token.ID = uint64(id)
+
+	return token.ID, nil
+}
+
+func (s *SQLiteDatabase) GetAPITokenByHash(hash string) (*entities.APIToken, error) {
+	// Ищем токен по хешу
+	row := s.db.QueryRow(`SELECT `+tokenColumns+` FROM api_tokens WHERE hash = ?`, hash)
+
+	token, err := scanAPIToken(row)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return token, nil
+}
+
+func (s *SQLiteDatabase) GetAPITokensByUserID(userID uint64) ([]*entities.APIToken, error) {
+	// Получаем все токены пользователя
+	rows, err := s.db.Query(`SELECT `+tokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*entities.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *SQLiteDatabase) RemoveAPITokenByID(id uint64) error {
+	// Отзываем токен
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) UpdateAPITokenLastUsed(id uint64, at time.Time) error {
+	// Запоминаем время последнего использования токена
+	res, err := s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIToken(row rowScanner) (*entities.APIToken, error) {
+	token := &entities.APIToken{}
+
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, &scopes,
+		&token.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.ExpiresAt = timePtr(expiresAt)
+	token.LastUsedAt = timePtr(lastUsedAt)
+
+	return token, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"my_notes_project/internal/entities"
+)
+
+func (s *SQLiteDatabase) GetTOTP(userID uint64) (*entities.TOTP, error) {
+	// Получаем настройки второго фактора пользователя
+	totp := &entities.TOTP{}
+	err := s.db.QueryRow(`SELECT user_id, secret, enabled, last_counter, created_at FROM totp WHERE user_id = ?`, userID).
+		Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastCounter, &totp.CreatedAt)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return totp, nil
+}
+
+func (s *SQLiteDatabase) SaveTOTP(totp *entities.TOTP) error {
+	// Создаем или обновляем настройки второго фактора
+	_, err := s.db.Exec(`
+		INSERT INTO totp (user_id, secret, enabled, last_counter, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = excluded.secret,
+			enabled = excluded.enabled,
+			last_counter = excluded.last_counter,
+			created_at = excluded.created_at`,
+		totp.UserID, totp.Secret, totp.Enabled, totp.LastCounter, totp.CreatedAt.UTC())
+
+	return convertError(err)
+}
+
+func (s *SQLiteDatabase) AdvanceTOTPCounter(userID, counter uint64) error {
+	// Запоминаем окно использованного кода, только если оно новее прежнего.
+	// Проверка и запись в одном запросе: из двух входов с одним кодом пройдет один
+	res, err := s.db.Exec(`UPDATE totp SET last_counter = ? WHERE user_id = ? AND last_counter < ?`, counter, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) RemoveTOTP(userID uint64) error {
+	// Отключаем второй фактор вместе с кодами восстановления
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM totp WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) ReplaceRecoveryCodes(userID uint64, hashes []string) error {
+	// Новый набор кодов восстановления заменяет старый целиком
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if _, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) UseRecoveryCode(userID uint64, hash string) error {
+	// Код восстановления одноразовый, после использования удаляем его
+	res, err := s.db.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, userID, hash)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) CountRecoveryCodes(userID uint64) (int, error) {
+	// Сколько неиспользованных кодов осталось
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`, userID).Scan(&count)
+
+	return count, err
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"my_notes_project/internal/entities"
+	"time"
+)
+
+func (s *SQLiteDatabase) TrashNote(id uint64, at time.Time) error {
+	// Переносим заметку в корзину, заметку уже из корзины не трогаем
+	res, err := s.db.Exec(`UPDATE notes SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) RestoreNote(id uint64) error {
+	// Возвращаем заметку из корзины на прежнее место
+	res, err := s.db.Exec(`UPDATE notes SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *SQLiteDatabase) GetTrashByUserID(userID uint64) ([]*entities.Note, error) {
+	// Заметки из корзины пользователя, недавно удаленные первыми
+	rows, err := s.db.Query(`
+		SELECT id, title, content, user_id, notebook_id, created_at, updated_at, version, deleted_at
+		FROM notes
+		WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*entities.Note{}
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		notes = append(notes, note)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notes, s.loadTags(notes)
+}
+
+func (s *SQLiteDatabase) EmptyTrash(userID uint64) (int, error) {
+	// Окончательно удаляем заметки из корзины пользователя вместе с правками и метками
+	res, err := s.db.Exec(`DELETE FROM notes WHERE user_id = ? AND deleted_at IS NOT NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLiteDatabase) PurgeTrash(before time.Time) (int, error) {
+	// Окончательно удаляем заметки всех пользователей, попавшие в корзину раньше before
+	res, err := s.db.Exec(`DELETE FROM notes WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Драйвер SQLite с функцией username_key, она нужна миграциям
+const driverName = "sqlite3_notes"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("username_key", UsernameKey, true)
+		},
+	})
+}
+
+// Ключ имени пользователя для сравнения без учета регистра в любом алфавите.
+// NOCASE в SQLite складывает только ASCII, поэтому уникальность держится на ключе
+func UsernameKey(name string) string {
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(name)))
+}
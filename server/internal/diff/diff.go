@@ -0,0 +1,189 @@
+package diff
+
+import "strings"
+
+// Что произошло со строкой при переходе от старого текста к новому
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Наибольшее число правок, которое ищет алгоритм, более далекие тексты
+// показываются как удаление старых строк и вставка новых целиком
+const maxEdits = 1000
+
+func (o Op) String() string {
+	switch o {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// Строка результата сравнения
+type Line struct {
+	Op   Op
+	Text string
+	// Номера строки в старом и новом тексте, начиная с 1, 0 если строки там нет
+	Old int
+	New int
+}
+
+// Сравнивает тексты построчно, переводы строк \r\n и \n считаются одинаковыми
+func Lines(a, b string) []Line {
+	return Strings(split(a), split(b))
+}
+
+// Сравнивает списки строк алгоритмом Майерса, в результате сначала идут
+// удаленные строки, затем вставленные на их место
+func Strings(a, b []string) []Line {
+	// Общее начало и конец не участвуют в поиске правок
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(a)+len(b)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, Line{Op: Equal, Text: a[i], Old: i + 1, New: i + 1})
+	}
+
+	for _, l := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		if l.Old != 0 {
+			l.Old += prefix
+		}
+		if l.New != 0 {
+			l.New += prefix
+		}
+		lines = append(lines, l)
+	}
+
+	for i := suffix; i > 0; i-- {
+		lines = append(lines, Line{Op: Equal, Text: a[len(a)-i], Old: len(a) - i + 1, New: len(b) - i + 1})
+	}
+
+	return lines
+}
+
+// Есть ли в результате сравнения измененные строки
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+
+	return false
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	// Последний перевод строки не дает лишней пустой строки
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b)
+	}
+
+	// v[k] самая дальняя точка x на диагонали k = x - y после d правок.
+	// Для восстановления пути храним v каждого шага, но только диагонали -d-1..d+1
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max && d <= maxEdits; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	return replace(a, b)
+}
+
+func backtrack(a, b []string, trace [][]int) []Line {
+	// Идем от конца к началу, на каждом шаге снимаем одну правку и диагональ перед ней
+	lines := []Line{}
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+
+		prevX := v(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, Line{Op: Equal, Text: a[x-1], Old: x, New: y})
+			x, y = x-1, y-1
+		}
+
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, Line{Op: Insert, Text: b[y-1], New: y})
+			} else {
+				lines = append(lines, Line{Op: Delete, Text: a[x-1], Old: x})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
+
+func replace(a, b []string) []Line {
+	lines := make([]Line, 0, len(a)+len(b))
+	for i, s := range a {
+		lines = append(lines, Line{Op: Delete, Text: s, Old: i + 1})
+	}
+	for i, s := range b {
+		lines = append(lines, Line{Op: Insert, Text: s, New: i + 1})
+	}
+
+	return lines
+}
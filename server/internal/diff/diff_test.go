@@ -0,0 +1,89 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Результат сравнения в виде " a", "-b", "+c"
+func format(lines []Line) []string {
+	out := []string{}
+	for _, l := range lines {
+		mark := map[Op]string{Equal: " ", Insert: "+", Delete: "-"}[l.Op]
+		out = append(out, mark+l.Text)
+	}
+
+	return out
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []string
+	}{
+		{"", "", []string{}},
+		{"a\nb\n", "a\nb", []string{" a", " b"}},
+		{"", "a\nb", []string{"+a", "+b"}},
+		{"a\nb", "", []string{"-a", "-b"}},
+		{"a\nb\nc", "a\nx\nc", []string{" a", "-b", "+x", " c"}},
+		{"a\nb\nc\nd", "a\nc\nd\ne", []string{" a", "-b", " c", " d", "+e"}},
+		// Переводы строк из формы браузера не считаются изменением
+		{"a\r\nb\r\n", "a\nb\nc", []string{" a", " b", "+c"}},
+		{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc", []string{"-a", "-b", " c", "+b", " a", " b", "-b", " a", "+c"}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, format(Lines(tt.a, tt.b)), "%q -> %q", tt.a, tt.b)
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	lines := Lines("a\nb\nc\nd", "a\nx\nc\nd\ne")
+	assert.Equal(t, []Line{
+		{Op: Equal, Text: "a", Old: 1, New: 1},
+		{Op: Delete, Text: "b", Old: 2},
+		{Op: Insert, Text: "x", New: 2},
+		{Op: Equal, Text: "c", Old: 3, New: 3},
+		{Op: Equal, Text: "d", Old: 4, New: 4},
+		{Op: Insert, Text: "e", New: 5},
+	}, lines)
+
+	assert.True(t, Changed(lines))
+	assert.False(t, Changed(Lines("a\nb", "a\nb\n")))
+}
+
+func TestEditsRebuildText(t *testing.T) {
+	// Из результата всегда восстанавливаются оба текста, в том числе когда правок больше предела
+	words := func(prefix string, n int) string {
+		lines := []string{}
+		for i := 0; i < n; i++ {
+			if i%3 == 0 {
+				lines = append(lines, "same")
+			} else {
+				lines = append(lines, fmt.Sprintf("%s%d", prefix, i))
+			}
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	for _, n := range []int{10, 100, 2 * maxEdits} {
+		a, b := words("old", n), words("new", n)
+
+		var old, new []string
+		for _, l := range Lines(a, b) {
+			if l.Op != Insert {
+				old = append(old, l.Text)
+			}
+			if l.Op != Delete {
+				new = append(new, l.Text)
+			}
+		}
+
+		assert.Equal(t, a, strings.Join(old, "\n"))
+		assert.Equal(t, b, strings.Join(new, "\n"))
+	}
+}
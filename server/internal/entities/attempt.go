@@ -0,0 +1,12 @@
+package entities
+
+import "time"
+
+type LoginAttempt struct {
+	// Ключ счетчика, например имя пользователя или IP адрес клиента
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	// Пока время не наступило, попытки входа отклоняются без проверки пароля
+	LockedUntil *time.Time
+}
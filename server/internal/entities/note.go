@@ -1,8 +1,21 @@
 package entities
 
+import "time"
+
 type Note struct {
 	ID      uint64 `json:"id"`
 	Title   string `json:"title"`
 	Content string `json:"content"`
 	UserID  uint64 `json:"user_id"`
+	// Каждая заметка лежит ровно в одном блокноте
+	NotebookID uint64    `json:"notebook_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// Имена меток в нормальной форме по алфавиту
+	Tags []string `json:"tags,omitempty"`
+	// Растет с каждым изменением заметки, при изменении указывается версия,
+	// которую видел пользователь, 0 означает изменение без проверки
+	Version int `json:"version"`
+	// Время переноса в корзину, nil у заметок не из корзины
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
@@ -0,0 +1,23 @@
+package entities
+
+import "time"
+
+// Блокнот пользователя, блокноты вкладываются друг в друга
+type Notebook struct {
+	ID     uint64 `json:"id"`
+	UserID uint64 `json:"user_id"`
+	// nil у блокнота верхнего уровня
+	ParentID  *uint64   `json:"parent_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Сколько заметок лежит прямо в блокноте, без вложенных
+	Notes int `json:"notes"`
+}
+
+// Блокнот в дереве блокнотов пользователя
+type NotebookNode struct {
+	Notebook *Notebook
+	// Глубина вложенности, у блокнотов верхнего уровня 0
+	Depth    int
+	Children []*NotebookNode
+}
@@ -0,0 +1,91 @@
+package entities
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Поле, по которому упорядочивается список заметок
+type NoteSort string
+
+const (
+	NoteSortCreated NoteSort = "created"
+	NoteSortUpdated NoteSort = "updated"
+	NoteSortTitle   NoteSort = "title"
+)
+
+// Параметры выборки заметок пользователя
+type NoteQuery struct {
+	Limit int
+	Sort  NoteSort
+	Desc  bool
+	// Подстрока в заголовке, пустая строка не фильтрует
+	Title string
+	// Только заметки из этого блокнота без вложенных, 0 не фильтрует
+	NotebookID uint64
+	// Метки, которые должны стоять на заметке: все сразу или, если AnyTag, хотя бы одна
+	Tags   []string
+	AnyTag bool
+	// Последняя заметка предыдущей страницы, nil для первой страницы
+	After *NoteCursor
+}
+
+// Позиция в списке: значение поля сортировки и id последней заметки страницы
+type NoteCursor struct {
+	ID    uint64     `json:"id"`
+	Title string     `json:"title,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+}
+
+// Одна страница списка заметок
+type NotePage struct {
+	Notes []*Note
+	// nil, если страница последняя
+	Next *NoteCursor
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Курсор в виде непрозрачной строки для ссылок и API
+func (c *NoteCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseNoteCursor(s string) (*NoteCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &NoteCursor{}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// Курсор, с которого начнется следующая страница после note
+func (q NoteQuery) CursorAfter(note *Note) *NoteCursor {
+	c := &NoteCursor{ID: note.ID}
+	switch q.Sort {
+	case NoteSortTitle:
+		c.Title = note.Title
+	case NoteSortUpdated:
+		c.Time = &note.UpdatedAt
+	default:
+		c.Time = &note.CreatedAt
+	}
+
+	return c
+}
+
+// Заметка, найденная поиском
+type NoteMatch struct {
+	Note *Note
+	// Фрагмент текста, найденные слова отмечены search.HighlightStart и search.HighlightEnd
+	Snippet string
+}
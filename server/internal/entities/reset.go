@@ -0,0 +1,11 @@
+package entities
+
+import "time"
+
+type PasswordReset struct {
+	// В базе данных хранится только хеш токена из письма
+	ID        string
+	UserID    uint64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
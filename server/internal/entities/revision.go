@@ -0,0 +1,17 @@
+package entities
+
+import "time"
+
+// Сохраненное состояние заметки после создания или изменения, не меняется
+type Revision struct {
+	ID     uint64 `json:"id"`
+	NoteID uint64 `json:"note_id"`
+	// Порядковый номер правки заметки, первая правка создается вместе с заметкой
+	Number   int    `json:"number"`
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+	AuthorID uint64 `json:"author_id"`
+	// Имя автора правки на момент чтения
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package entities
+
+import "time"
+
+type Session struct {
+	// В базе данных хранится только хеш идентификатора сессии из куки
+	ID        string
+	UserID    uint64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Пароль проверен, но второй фактор еще нет, такая сессия не дает доступа
+	MFAPending bool
+}
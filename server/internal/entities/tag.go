@@ -0,0 +1,10 @@
+package entities
+
+// Метка из пространства имен пользователя
+type Tag struct {
+	ID     uint64 `json:"id"`
+	UserID uint64 `json:"user_id"`
+	Name   string `json:"name"`
+	// Сколько заметок отмечено этой меткой
+	Notes int `json:"notes"`
+}
@@ -0,0 +1,25 @@
+package entities
+
+import "time"
+
+type APIToken struct {
+	// Сам токен показывается пользователю один раз, в базе хранится его хеш
+	ID         uint64
+	UserID     uint64
+	Name       string
+	Hash       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
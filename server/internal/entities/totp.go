@@ -0,0 +1,14 @@
+package entities
+
+import "time"
+
+type TOTP struct {
+	UserID uint64
+	// Секрет в base32, нужен в открытом виде для вычисления кодов
+	Secret string
+	// До подтверждения первым кодом второй фактор не действует
+	Enabled bool
+	// Последнее принятое окно, код из него не принимается повторно
+	LastCounter uint64
+	CreatedAt   time.Time
+}
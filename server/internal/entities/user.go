@@ -1,9 +1,16 @@
 package entities
 
+import "time"
+
 type User struct {
 	ID       uint64
 	Name     string
 	Password string
+	// Адрес для восстановления пароля, может быть пустым
+	Email     string
+	CreatedAt time.Time
+	// nil, если пользователь еще ни разу не входил
+	LastLoginAt *time.Time
 }
 
 func NewUser(name, password string) *User {
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Письмо в виде простого текста
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Отправка писем, реализация выбирается в конфигурации
+type Mailer interface {
+	Send(Message) error
+}
+
+// Отправка через SMTP сервер
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+	}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	// Без логина отправляем анонимно, например на локальный relay
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, fmt.Sprint(m.Port))
+	if err := smtp.SendMail(addr, auth, m.From, []string{msg.To}, format(m.From, msg)); err != nil {
+		return fmt.Errorf("send mail to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+// Письма дописываются в файл, удобно для локальной разработки без сети
+type FileMailer struct {
+	path string
+	from string
+	mu   sync.Mutex
+}
+
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{
+		path: path,
+		from: from,
+	}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(format(m.from, msg), "\r\n"...))
+	return err
+}
+
+// Письма только пишутся в лог
+type LogMailer struct {
+	logger *logrus.Logger
+}
+
+func NewLogMailer(logger *logrus.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.logger.WithFields(logrus.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Info(msg.Body)
+
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	// Заголовки и тело письма в формате RFC 5322
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	m := NewFileMailer(path, "notes@example.com")
+
+	require.Nil(t, m.Send(Message{To: "ivan@example.com", Subject: "Восстановление пароля", Body: "line 1\nline 2"}))
+	require.Nil(t, m.Send(Message{To: "igor@example.com", Subject: "Hello", Body: "text"}))
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+
+	content := string(data)
+	assert.Contains(t, content, "From: notes@example.com\r\n")
+	assert.Contains(t, content, "To: ivan@example.com\r\n")
+	assert.Contains(t, content, "Subject: =?utf-8?q?")
+	assert.Contains(t, content, "line 1\r\nline 2\r\n")
+	assert.Contains(t, content, "To: igor@example.com\r\n")
+}
@@ -0,0 +1,28 @@
+package password
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// Список часто используемых паролей из утечек, по одному в строке
+//
+//go:embed common.txt
+var commonList string
+
+var common = func() map[string]struct{} {
+	m := map[string]struct{}{}
+	for _, line := range strings.Split(commonList, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			m[line] = struct{}{}
+		}
+	}
+
+	return m
+}()
+
+// Пароль есть в списке часто используемых, регистр не учитывается
+func IsCommon(plain string) bool {
+	_, exists := common[strings.ToLower(plain)]
+	return exists
+}
@@ -0,0 +1,125 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Префикс хеша argon2id в формате PHC: $argon2id$v=19$m=...,t=...,p=...$соль$хеш
+const argon2idPrefix = "$argon2id$"
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Параметры argon2id, которые сохраняются вместе с хешем
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Рекомендованные параметры из RFC 9106
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  1,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func Hash(password string, p Params) (string, error) {
+	// Генерируем случайную соль
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	// Сохраняем алгоритм и параметры рядом с хешем, чтобы их можно было менять
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func Verify(encoded, password string) (bool, error) {
+	// Старые записи хранят пароль открытым текстом
+	if !IsHashed(encoded) {
+		return subtle.ConstantTimeCompare([]byte(encoded), []byte(password)) == 1, nil
+	}
+
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	// Считаем хеш с теми же параметрами и сравниваем за постоянное время
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func IsHashed(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func NeedsRehash(encoded string, p Params) bool {
+	// Перехешируем открытые пароли и хеши с устаревшими параметрами
+	if !IsHashed(encoded) {
+		return true
+	}
+
+	current, salt, key, err := decode(encoded)
+	if err != nil {
+		return true
+	}
+
+	return current.Memory != p.Memory ||
+		current.Iterations != p.Iterations ||
+		current.Parallelism != p.Parallelism ||
+		uint32(len(salt)) != p.SaltLength ||
+		uint32(len(key)) != p.KeyLength
+}
+
+func decode(encoded string) (Params, []byte, []byte, error) {
+	// Разбираем строку вида $argon2id$v=19$m=65536,t=1,p=4$соль$хеш
+	var p Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
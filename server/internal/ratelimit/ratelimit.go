@@ -0,0 +1,220 @@
+package ratelimit
+
+import (
+	"errors"
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Хранилище счетчиков, в приложении это база данных
+type Store interface {
+	GetLoginAttempt(string) (*entities.LoginAttempt, error)
+	SaveLoginAttempt(*entities.LoginAttempt) error
+	RemoveLoginAttempt(string) error
+}
+
+// Правила блокировки после неудачных попыток
+type Policy struct {
+	// Сколько неудачных попыток подряд допускается без блокировки
+	FreeAttempts int
+	// Первая блокировка, каждая следующая вдвое длиннее
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// После такого перерыва без ошибок счетчик начинается заново
+	Window time.Duration
+}
+
+// Для одной учетной записи: после 5 ошибок блокировка от 30 секунд до 15 минут
+var DefaultAccountPolicy = Policy{
+	FreeAttempts: 5,
+	BaseDelay:    30 * time.Second,
+	MaxDelay:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// Для одного IP допускаем больше ошибок, за ним может быть несколько пользователей
+var DefaultIPPolicy = Policy{
+	FreeAttempts: 20,
+	BaseDelay:    time.Minute,
+	MaxDelay:     time.Hour,
+	Window:       time.Hour,
+}
+
+// Счетчик неудачных попыток с экспоненциальной задержкой
+type Limiter struct {
+	store  Store
+	logger *logrus.Logger
+	policy Policy
+	// Префикс ключа отделяет счетчики разных видов в одной таблице
+	prefix string
+	now    func() time.Time
+	// Попытки, которые прошли проверку, но еще не завершились
+	pending map[string]int
+	mu      sync.Mutex
+}
+
+// Дополнительная настройка счетчика
+type Option func(*Limiter)
+
+// Источник текущего времени для блокировок, в тестах подменяется
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+func New(store Store, logger *logrus.Logger, prefix string, policy Policy, opts ...Option) *Limiter {
+	l := &Limiter{
+		store:   store,
+		logger:  logger,
+		policy:  policy,
+		prefix:  prefix,
+		now:     time.Now,
+		pending: map[string]int{},
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Возвращает, сколько еще длится блокировка ключа, 0 если ее нет
+func (l *Limiter) Check(key string) (time.Duration, error) {
+	attempt, err := l.get(key)
+	if err != nil || attempt == nil || attempt.LockedUntil == nil {
+		return 0, err
+	}
+
+	if wait := attempt.LockedUntil.Sub(l.now()); wait > 0 {
+		return wait, nil
+	}
+
+	return 0, nil
+}
+
+// Проверяет блокировку и в том же шаге резервирует попытку. Если ждать не нужно,
+// попытку надо завершить вызовом Fail или Release
+func (l *Limiter) Reserve(key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attempt, err := l.get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	now := l.now()
+	failures := 0
+	if attempt != nil {
+		if attempt.LockedUntil != nil {
+			if wait := attempt.LockedUntil.Sub(now); wait > 0 {
+				return wait, nil
+			}
+		}
+
+		if now.Sub(attempt.LastFailureAt) <= l.policy.Window {
+			failures = attempt.Failures
+		}
+	}
+
+	// Незавершенные попытки считаем будущими ошибками: если бы все они оказались
+	// неудачными, эта попытка уже попала бы под блокировку
+	if pending := l.pending[key]; pending > 0 {
+		if delay := l.delay(failures + pending); delay > 0 {
+			return delay, nil
+		}
+	}
+
+	l.pending[key]++
+	return 0, nil
+}
+
+// Завершает зарезервированную попытку без ошибки
+func (l *Limiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.release(key)
+}
+
+// Засчитывает неудачную попытку и при необходимости блокирует ключ
+func (l *Limiter) Fail(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.release(key)
+
+	attempt, err := l.get(key)
+	if err != nil {
+		return err
+	}
+
+	now := l.now()
+	if attempt == nil || now.Sub(attempt.LastFailureAt) > l.policy.Window {
+		attempt = &entities.LoginAttempt{Key: l.prefix + key}
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = now
+
+	if delay := l.delay(attempt.Failures); delay > 0 {
+		lockedUntil := now.Add(delay)
+		attempt.LockedUntil = &lockedUntil
+		l.logger.Warnf("login locked for %s%s until %s after %d failed attempts",
+			l.prefix, key, lockedUntil.Format(time.RFC3339), attempt.Failures)
+	}
+
+	return l.store.SaveLoginAttempt(attempt)
+}
+
+// Сбрасывает счетчик ключа
+func (l *Limiter) Reset(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.store.RemoveLoginAttempt(l.prefix + key)
+}
+
+func (l *Limiter) release(key string) {
+	if l.pending[key] > 1 {
+		l.pending[key]--
+	} else {
+		delete(l.pending, key)
+	}
+}
+
+func (l *Limiter) get(key string) (*entities.LoginAttempt, error) {
+	// Отсутствие записи не ошибка, а чистый счетчик
+	attempt, err := l.store.GetLoginAttempt(l.prefix + key)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil
+	}
+
+	return attempt, err
+}
+
+func (l *Limiter) delay(failures int) time.Duration {
+	// Задержка удваивается с каждой ошибкой сверх допустимых
+	over := failures - l.policy.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	// Сдвиг ограничен, чтобы задержка не переполнилась
+	if over > 20 {
+		over = 20
+	}
+
+	delay := l.policy.BaseDelay << (over - 1)
+	if l.policy.MaxDelay > 0 && delay > l.policy.MaxDelay {
+		return l.policy.MaxDelay
+	}
+
+	return delay
+}
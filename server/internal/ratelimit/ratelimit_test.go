@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"my_notes_project/internal/database"
+	"my_notes_project/internal/entities"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore map[string]*entities.LoginAttempt
+
+func (m memoryStore) GetLoginAttempt(key string) (*entities.LoginAttempt, error) {
+	if a, exists := m[key]; exists {
+		return a, nil
+	}
+
+	return nil, database.ErrNotFound
+}
+
+func (m memoryStore) SaveLoginAttempt(attempt *entities.LoginAttempt) error {
+	m[attempt.Key] = attempt
+
+	return nil
+}
+
+func (m memoryStore) RemoveLoginAttempt(key string) error {
+	delete(m, key)
+
+	return nil
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(memoryStore{}, logrus.New(), "user:", Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Minute,
+		MaxDelay:     5 * time.Minute,
+		Window:       time.Hour,
+	})
+	l.now = func() time.Time { return now }
+
+	// Допустимые ошибки не блокируют
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, l.Fail("ivan"))
+		wait, err := l.Check("ivan")
+		assert.Nil(t, err)
+		assert.Zero(t, wait)
+	}
+
+	// Дальше задержка удваивается до максимума
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		assert.Nil(t, l.Fail("ivan"))
+		wait, err := l.Check("ivan")
+		assert.Nil(t, err)
+		assert.Equal(t, expected, wait)
+	}
+
+	// Блокировка заканчивается со временем
+	now = now.Add(5 * time.Minute)
+	wait, err := l.Check("ivan")
+	assert.Nil(t, err)
+	assert.Zero(t, wait)
+
+	// Другие ключи не затрагиваются
+	wait, err = l.Check("igor")
+	assert.Nil(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestWindowAndReset(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := memoryStore{}
+	l := New(store, logrus.New(), "ip:", Policy{FreeAttempts: 1, BaseDelay: time.Minute, Window: time.Hour})
+	l.now = func() time.Time { return now }
+
+	assert.Nil(t, l.Fail("10.0.0.1"))
+	assert.Equal(t, 1, store["ip:10.0.0.1"].Failures)
+
+	// После долгого перерыва счетчик начинается заново
+	now = now.Add(2 * time.Hour)
+	assert.Nil(t, l.Fail("10.0.0.1"))
+	assert.Equal(t, 1, store["ip:10.0.0.1"].Failures)
+	assert.Nil(t, store["ip:10.0.0.1"].LockedUntil)
+
+	assert.Nil(t, l.Fail("10.0.0.1"))
+	assert.NotNil(t, store["ip:10.0.0.1"].LockedUntil)
+
+	assert.Nil(t, l.Reset("10.0.0.1"))
+	assert.Empty(t, store)
+}
+
+func TestReserveCountsPendingAttempts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := memoryStore{}
+	l := New(store, logrus.New(), "user:", Policy{FreeAttempts: 2, BaseDelay: time.Minute, Window: time.Hour},
+		WithClock(func() time.Time { return now }))
+
+	// Параллельно проходят только те попытки, которые прошли бы и по очереди:
+	// две допустимые ошибки и третья, после которой наступит блокировка
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := l.Reserve("ivan")
+			assert.Nil(t, err)
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, allowed)
+
+	// Все они оказались ошибками, ключ заблокирован
+	for i := 0; i < allowed; i++ {
+		assert.Nil(t, l.Fail("ivan"))
+	}
+
+	wait, err := l.Reserve("ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, wait)
+
+	// После блокировки снова проходит одна попытка за раз
+	now = now.Add(time.Minute)
+	wait, err = l.Reserve("ivan")
+	assert.Nil(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = l.Reserve("ivan")
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Minute, wait)
+
+	// Успешная попытка освобождает резерв, не засчитывая ошибку
+	l.Release("ivan")
+	assert.Equal(t, 3, store["user:ivan"].Failures)
+	wait, err = l.Reserve("ivan")
+	assert.Nil(t, err)
+	assert.Zero(t, wait)
+	l.Release("ivan")
+	assert.Empty(t, l.pending)
+}
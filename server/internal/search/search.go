@@ -0,0 +1,226 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Границы выделенного фрагмента в сниппете, в тексте заметок не встречаются
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+	// Многоточие на месте обрезанного текста
+	Ellipsis = "…"
+	// Длина сниппета в символах
+	snippetLength = 160
+)
+
+// Слово или фраза из поискового запроса
+type Term struct {
+	// Одно слово или несколько слов подряд
+	Text string
+	// Последнее слово может быть началом более длинного слова
+	Prefix bool
+}
+
+// Разбирает запрос: "фраза в кавычках", слово* для поиска по началу слова,
+// остальные слова ищутся целиком, все части запроса должны найтись в заметке
+func Parse(query string) []Term {
+	terms := []Term{}
+
+	for query = strings.TrimSpace(query); query != ""; query = strings.TrimSpace(query) {
+		var text string
+		if query[0] == '"' {
+			end := strings.IndexByte(query[1:], '"')
+			if end < 0 {
+				// Незакрытая кавычка действует до конца запроса
+				text, query = query[1:], ""
+			} else {
+				text, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(query, " \t\n\"")
+			if end < 0 {
+				end = len(query)
+			}
+			text, query = query[:end], query[end:]
+		}
+
+		prefix := false
+		if strings.HasPrefix(query, "*") {
+			prefix, query = true, query[1:]
+		}
+		if strings.HasSuffix(text, "*") {
+			prefix, text = true, strings.TrimRight(text, "*")
+		}
+
+		// Знаки препинания между словами не важны, как и в полнотекстовом индексе
+		words := strings.FieldsFunc(text, isSeparator)
+		if len(words) == 0 {
+			continue
+		}
+
+		terms = append(terms, Term{Text: strings.Join(words, " "), Prefix: prefix})
+	}
+
+	return terms
+}
+
+// Выражение для FTS5 MATCH, каждая часть запроса в кавычках,
+// поэтому пользовательский ввод не разбирается как синтаксис FTS5
+func MatchExpression(terms []Term) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		part := `"` + strings.ReplaceAll(t.Text, `"`, `""`) + `"`
+		if t.Prefix {
+			part += "*"
+		}
+
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Поиск без полнотекстового индекса: проверяет, что все части запроса есть
+// в заголовке или тексте, и возвращает сниппет и число частей, найденных в заголовке
+func Match(terms []Term, title, content string) (string, int, bool) {
+	if len(terms) == 0 {
+		return "", 0, false
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(terms))
+	inTitle := 0
+	for _, t := range terms {
+		re := pattern(t)
+		titleMatch := re.MatchString(title)
+		if !titleMatch && !re.MatchString(content) {
+			return "", 0, false
+		}
+
+		if titleMatch {
+			inTitle++
+		}
+
+		patterns = append(patterns, re)
+	}
+
+	// Сниппет строим по тексту, если совпадения только в заголовке, то по заголовку
+	text := content
+	if firstMatch(patterns, content) < 0 {
+		text = title
+	}
+
+	return snippet(patterns, text), inTitle, true
+}
+
+func pattern(t Term) *regexp.Regexp {
+	// Совпадение по границам слов без учета регистра, слова фразы разделены любыми не буквами
+	words := strings.Split(t.Text, " ")
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+
+	// Для поиска по началу слова выделяем слово целиком, как и FTS5
+	tail := `)(?:$|[^\p{L}\p{N}])`
+	if t.Prefix {
+		tail = `[\p{L}\p{N}]*)`
+	}
+
+	expr := `(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(words, `[^\p{L}\p{N}]+`) + tail
+
+	return regexp.MustCompile(expr)
+}
+
+func firstMatch(patterns []*regexp.Regexp, text string) int {
+	first := -1
+	for _, re := range patterns {
+		if loc := re.FindStringSubmatchIndex(text); loc != nil && (first < 0 || loc[2] < first) {
+			first = loc[2]
+		}
+	}
+
+	return first
+}
+
+func findAll(re *regexp.Regexp, text string) [][2]int {
+	// Граница слова перед совпадением входит в выражение, поэтому следующий поиск
+	// начинаем с последнего символа предыдущего совпадения, а не после него
+	matches := [][2]int{}
+	for pos := 0; pos < len(text); {
+		from := pos
+		if pos > 0 {
+			_, size := utf8.DecodeLastRuneInString(text[:pos])
+			from -= size
+		}
+
+		loc := re.FindStringSubmatchIndex(text[from:])
+		if loc == nil {
+			break
+		}
+
+		matches = append(matches, [2]int{from + loc[2], from + loc[3]})
+		pos = from + loc[3]
+	}
+
+	return matches
+}
+
+func snippet(patterns []*regexp.Regexp, text string) string {
+	// Окно вокруг первого совпадения, обрезанное по границам символов
+	start := firstMatch(patterns, text)
+	if start < 0 {
+		start = 0
+	}
+
+	for back := 0; start > 0 && back < snippetLength/4; back++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+
+	end := start
+	for n := 0; end < len(text) && n < snippetLength; n++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+
+	// Выделяем совпадения внутри окна, пересекающиеся выделения объединяем
+	marked := make([]bool, len(text))
+	for _, re := range patterns {
+		for _, m := range findAll(re, text) {
+			for i := m[0]; i < m[1]; i++ {
+				marked[i] = true
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString(Ellipsis)
+	}
+
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString(HighlightStart)
+		}
+
+		b.WriteByte(text[i])
+
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString(HighlightEnd)
+		}
+	}
+
+	if end < len(text) {
+		b.WriteString(Ellipsis)
+	}
+
+	return b.String()
+}
+
+func isSeparator(r rune) bool {
+	// Как у токенизатора unicode61: слова состоят из букв и цифр
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		terms []Term
+	}{
+		{"", []Term{}},
+		{"  ocean  beach ", []Term{{Text: "ocean"}, {Text: "beach"}}},
+		{`"sandy beach" oce*`, []Term{{Text: "sandy beach"}, {Text: "oce", Prefix: true}}},
+		{`"sandy beach"* wave`, []Term{{Text: "sandy beach", Prefix: true}, {Text: "wave"}}},
+		// Синтаксис FTS5 и знаки препинания не передаются в запрос
+		{`ocean AND (beach OR NEAR) "unclosed`, []Term{{Text: "ocean"}, {Text: "AND"}, {Text: "beach"}, {Text: "OR"}, {Text: "NEAR"}, {Text: "unclosed"}}},
+		{`"don't stop"`, []Term{{Text: "don t stop"}}},
+		{`*** "" -`, []Term{}},
+		{"Море*", []Term{{Text: "Море", Prefix: true}}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.terms, Parse(tt.query), tt.query)
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	assert.Equal(t, `"sandy beach" "oce"*`, MatchExpression(Parse(`"sandy beach" oce*`)))
+}
+
+func TestMatch(t *testing.T) {
+	mark := func(s string) string {
+		return strings.NewReplacer("[", HighlightStart, "]", HighlightEnd).Replace(s)
+	}
+
+	// Все части запроса обязательны, регистр не важен
+	snippet, inTitle, ok := Match(Parse("ocean beach"), "Beach", "Nice OCEAN view, ocean breeze")
+	assert.True(t, ok)
+	assert.Equal(t, 1, inTitle)
+	assert.Equal(t, mark("Nice [OCEAN] view, [ocean] breeze"), snippet)
+
+	_, _, ok = Match(Parse("ocean forest"), "Beach", "Nice ocean view")
+	assert.False(t, ok)
+
+	// Слово целиком, если не указан поиск по началу слова
+	_, _, ok = Match(Parse("ocea"), "", "Nice ocean view")
+	assert.False(t, ok)
+
+	snippet, _, ok = Match(Parse("ocea*"), "", "Nice ocean view")
+	assert.True(t, ok)
+	assert.Equal(t, mark("Nice [ocean] view"), snippet)
+
+	// Фраза ищется подряд, знаки препинания между словами не мешают
+	snippet, _, ok = Match(Parse(`"nice ocean"`), "", "Very nice, ocean view")
+	assert.True(t, ok)
+	assert.Equal(t, mark("Very [nice, ocean] view"), snippet)
+
+	_, _, ok = Match(Parse(`"ocean nice"`), "", "Very nice, ocean view")
+	assert.False(t, ok)
+
+	snippet, _, ok = Match(Parse("море"), "", "Тёплое Море и пляж")
+	assert.True(t, ok)
+	assert.Equal(t, mark("Тёплое [Море] и пляж"), snippet)
+
+	// Совпадение только в заголовке
+	snippet, _, ok = Match(Parse("beach"), "Beach", "Nice view")
+	assert.True(t, ok)
+	assert.Equal(t, mark("[Beach]"), snippet)
+
+	// Длинный текст обрезается вокруг первого совпадения
+	long := strings.Repeat("word ", 100) + "ocean " + strings.Repeat("word ", 100)
+	snippet, _, ok = Match(Parse("ocean"), "", long)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(snippet, Ellipsis))
+	assert.True(t, strings.HasSuffix(snippet, Ellipsis))
+	assert.Contains(t, snippet, mark("[ocean]"))
+}
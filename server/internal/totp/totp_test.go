@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Секрет "12345678901234567890" из тестовых векторов RFC 6238
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestRFC6238Vectors(t *testing.T) {
+	// Последние шесть цифр восьмизначных кодов из приложения B
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		code, err := Code(rfcSecret, Counter(time.Unix(unix, 0)))
+		require.Nil(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.Nil(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, Counter(now))
+	require.Nil(t, err)
+
+	counter, ok := Validate(secret, code, now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	// Соседнее окно допускается, дальнее нет
+	_, ok = Validate(secret, code, now.Add(Period), 1)
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(2*Period), 1)
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "12345", now, 1)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Notes", "ivan", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Notes:ivan?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Notes")
+}